@@ -8,9 +8,21 @@ import (
 	"gpt-tools/backend/internal/server"
 )
 
+// 映射规则目录，与 backend/main.go 相同，假设在 backend 目录下启动
+const mappingDir = "../scripts/mappings"
+
 func main() {
 	gin.SetMode(gin.ReleaseMode)
 
+	// 映射规则中声明的来源（如 open_webui）同样作为合法的 source
+	added, err := server.AddMappingSources(mappingDir)
+	if err != nil {
+		log.Printf("load mapping sources: %v", err)
+	}
+	if len(added) > 0 {
+		log.Printf("mapping sources: %v", added)
+	}
+
 	r := server.NewRouter()
 	if err := r.Run(":8080"); err != nil {
 		log.Fatalf("failed to start api server: %v", err)
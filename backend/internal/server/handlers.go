@@ -1,7 +1,11 @@
 package server
 
 import (
+	"encoding/json"
+	"fmt"
 	"net/http"
+	"os"
+	"path/filepath"
 	"strconv"
 	"strings"
 	"time"
@@ -9,6 +13,45 @@ import (
 	"github.com/gin-gonic/gin"
 )
 
+// sourceTypes 支持的对话来源，映射规则中声明的来源通过 AddMappingSources 加入
+var sourceTypes = []string{"gpt", "claude", "claude_code", "codex", "gemini", "aider", "cline"}
+
+// AddMappingSources 将映射规则目录（scripts/mappings/*.json）中声明的 source 加入支持的来源，返回新增的来源
+func AddMappingSources(dir string) ([]string, error) {
+	files, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+
+	var added []string
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return added, err
+		}
+		var spec struct {
+			Source string `json:"source"`
+		}
+		if err := json.Unmarshal(data, &spec); err != nil {
+			return added, fmt.Errorf("parse mapping %s: %w", file, err)
+		}
+		if spec.Source != "" && !isValidSource(spec.Source) {
+			sourceTypes = append(sourceTypes, spec.Source)
+			added = append(added, spec.Source)
+		}
+	}
+	return added, nil
+}
+
+func isValidSource(source string) bool {
+	for _, s := range sourceTypes {
+		if s == source {
+			return true
+		}
+	}
+	return false
+}
+
 // ListConversations 返回对话列表
 func (h *Handler) ListConversations(c *gin.Context) {
 	page, pageSize := parsePagination(c)
@@ -53,6 +96,67 @@ func (h *Handler) ListConversationMessages(c *gin.Context) {
 	})
 }
 
+// ExportConversation 将对话（或指定分支）导出为 Markdown 或独立 HTML 文档
+func (h *Handler) ExportConversation(c *gin.Context) {
+	uuid := strings.TrimSpace(c.Param("uuid"))
+	if uuid == "" {
+		writeError(c, http.StatusBadRequest, 1, "conversation uuid required")
+		return
+	}
+	format := c.DefaultQuery("format", "markdown")
+	var filename, content string
+	switch format {
+	case "markdown":
+		filename = uuid + ".md"
+		content = "# 示例对话\n\n## 用户\n\n示例内容\n"
+	case "html":
+		filename = uuid + ".html"
+		content = "<!DOCTYPE html>\n<html lang=\"zh-CN\">\n<body>\n<h1>示例对话</h1>\n</body>\n</html>\n"
+	default:
+		writeError(c, http.StatusBadRequest, 1, "format must be markdown or html")
+		return
+	}
+	writeOK(c, gin.H{
+		"format":   format,
+		"filename": filename,
+		"leaf":     c.Query("leaf"),
+		"content":  content,
+	})
+}
+
+// ListProjects 返回项目列表
+func (h *Handler) ListProjects(c *gin.Context) {
+	page, pageSize := parsePagination(c)
+	writeOK(c, gin.H{
+		"items": []gin.H{
+			{"uuid": "proj-demo-1", "name": "示例项目", "source_type": "claude", "conversation_count": 1},
+		},
+		"total":     1,
+		"page":      page,
+		"page_size": pageSize,
+	})
+}
+
+// GetProject 返回项目详情（含知识文档片段和所属对话）
+func (h *Handler) GetProject(c *gin.Context) {
+	uuid := strings.TrimSpace(c.Param("uuid"))
+	if uuid == "" {
+		writeError(c, http.StatusBadRequest, 1, "project uuid required")
+		return
+	}
+	writeOK(c, gin.H{
+		"uuid":            uuid,
+		"name":            "示例项目",
+		"description":     "",
+		"prompt_template": "",
+		"source_type":     "claude",
+		"conversations":   []string{"conv-demo-1"},
+		"fragments": []gin.H{
+			{"uuid": "frag-demo-1", "fragment_type": "text", "filename": "README.md", "content": "示例文档"},
+		},
+	})
+}
+
 // GetMessage 返回消息详情
 func (h *Handler) GetMessage(c *gin.Context) {
 	uuid := strings.TrimSpace(c.Param("uuid"))
@@ -105,6 +209,12 @@ func (h *Handler) Search(c *gin.Context) {
 		writeError(c, http.StatusBadRequest, 1, "keyword required")
 		return
 	}
+	for _, source := range req.Sources {
+		if !isValidSource(source) {
+			writeError(c, http.StatusBadRequest, 1, "invalid source: "+source)
+			return
+		}
+	}
 	page, pageSize := normalizePage(req.Page, req.PageSize)
 	writeOK(c, gin.H{
 		"total": 1,
@@ -127,13 +237,30 @@ func (h *Handler) Search(c *gin.Context) {
 	})
 }
 
-// ListTrees 返回对话树列表
+// ListTrees 返回对话树列表，stats 与 gpt_branch_tree_merge -stats -json 的输出一致
 func (h *Handler) ListTrees(c *gin.Context) {
 	page, pageSize := parsePagination(c)
 	writeOK(c, gin.H{
 		"items": []gin.H{
 			{
-				"tree_id":    "tree-demo",
+				"tree_id": "tree-demo",
+				"stats": gin.H{
+					"node_count":          4,
+					"conversation_count":  2,
+					"max_depth":           3,
+					"leaf_count":          2,
+					"fork_count":          1,
+					"common_node_count":   2,
+					"common_percentage":   66.67,
+					"branching_histogram": gin.H{"0": 2, "1": 1, "2": 1},
+					"forks": []gin.H{
+						{"node": "msg-demo-2", "depth": 2, "children": 2},
+					},
+					"branches": []gin.H{
+						{"conversation": "conv-demo-a", "length": 3, "fork_node": "msg-demo-2", "fork_depth": 2, "messages_after_fork": 1, "rounds_after_fork": 1},
+						{"conversation": "conv-demo-b", "length": 3, "fork_node": "msg-demo-2", "fork_depth": 2, "messages_after_fork": 1, "rounds_after_fork": 1},
+					},
+				},
 				"created_at": nowRFC3339(),
 				"updated_at": nowRFC3339(),
 			},
@@ -145,25 +272,47 @@ func (h *Handler) ListTrees(c *gin.Context) {
 }
 
 // UpdateTree 创建或更新对话树（根据tree_id是否为空区分）
+// action 可选: add（合并对话）、remove（移除对话并剪除无引用节点）、rebuild（按成员对话重新计算），缺省时按会话列表覆盖
 func (h *Handler) UpdateTree(c *gin.Context) {
 	var req struct {
 		TreeID            string   `json:"tree_id"`
+		Action            string   `json:"action"`
 		ConversationUUIDs []string `json:"conversation_uuids"`
 	}
 	if err := c.ShouldBindJSON(&req); err != nil {
 		writeError(c, http.StatusBadRequest, 1, "invalid request body")
 		return
 	}
-	if len(req.ConversationUUIDs) == 0 || !nonEmptyStrings(req.ConversationUUIDs) {
-		writeError(c, http.StatusBadRequest, 1, "conversation_uuids required and must be non-empty")
+	treeID := strings.TrimSpace(req.TreeID)
+	action := strings.TrimSpace(req.Action)
+	switch action {
+	case "", "add", "remove", "rebuild":
+	default:
+		writeError(c, http.StatusBadRequest, 1, "invalid action")
 		return
 	}
-	treeID := strings.TrimSpace(req.TreeID)
-	if treeID == "" {
-		treeID = "tree-" + strconv.FormatInt(timeNowUnix(), 10)
+	if action != "" && treeID == "" {
+		writeError(c, http.StatusBadRequest, 1, "tree_id required for action "+action)
+		return
+	}
+	// rebuild 未提供会话列表时使用树中已有的会话
+	if action != "rebuild" || len(req.ConversationUUIDs) > 0 {
+		if len(req.ConversationUUIDs) == 0 || !nonEmptyStrings(req.ConversationUUIDs) {
+			writeError(c, http.StatusBadRequest, 1, "conversation_uuids required and must be non-empty")
+			return
+		}
+	}
+	if action == "" {
+		// 未指定操作: 创建新树或按会话列表覆盖
+		action = "rebuild"
+		if treeID == "" {
+			action = "create"
+			treeID = "tree-" + strconv.FormatInt(timeNowUnix(), 10)
+		}
 	}
 	writeOK(c, gin.H{
 		"tree_id":    treeID,
+		"action":     action,
 		"updated_at": nowRFC3339(),
 	})
 }
@@ -175,16 +324,76 @@ func (h *Handler) GetTree(c *gin.Context) {
 		writeError(c, http.StatusBadRequest, 1, "tree_id required")
 		return
 	}
+	// embed 指定节点内嵌内容的方式: snippet（摘要，默认）或 full（全文），前端可直接渲染
+	embed := strings.TrimSpace(c.DefaultQuery("embed", "snippet"))
+	if embed != "snippet" && embed != "full" {
+		writeError(c, http.StatusBadRequest, 1, "invalid embed")
+		return
+	}
 	writeOK(c, gin.H{
 		"tree_id": treeID,
 		"tree_data": gin.H{
-			"nodes": []gin.H{},
+			"root":          "msg-demo-1",
+			"conversations": []string{"conv-demo-a", "conv-demo-b"},
+			"embed":         embed,
+			"nodes": gin.H{
+				"msg-demo-1": gin.H{
+					"parent":       "",
+					"children":     []string{"msg-demo-2"},
+					"role":         "user",
+					"content_type": "text",
+					"content":      "示例问题",
+					"create_time":  nowRFC3339(),
+				},
+				"msg-demo-2": gin.H{
+					"parent":       "msg-demo-1",
+					"children":     []string{},
+					"role":         "assistant",
+					"content_type": "text",
+					"content":      "示例回答",
+					"create_time":  nowRFC3339(),
+				},
+			},
 		},
 		"created_at": nowRFC3339(),
 		"updated_at": nowRFC3339(),
 	})
 }
 
+// DiffTree 比较树中两个对话的分叉：返回分叉节点、分叉后的消息差异和各分支继续的轮数
+func (h *Handler) DiffTree(c *gin.Context) {
+	treeID := strings.TrimSpace(c.Param("tree_id"))
+	if treeID == "" {
+		writeError(c, http.StatusBadRequest, 1, "tree_id required")
+		return
+	}
+	convA := strings.TrimSpace(c.Query("a"))
+	convB := strings.TrimSpace(c.Query("b"))
+	if convA == "" || convB == "" || convA == convB {
+		writeError(c, http.StatusBadRequest, 1, "a and b must be two different conversation uuids")
+		return
+	}
+	writeOK(c, gin.H{
+		"tree_id":      treeID,
+		"fork_node":    "msg-demo-2",
+		"common_count": 2,
+		"branches": []gin.H{
+			{"conversation_uuid": convA, "messages_after_fork": 2, "rounds_after_fork": 1},
+			{"conversation_uuid": convB, "messages_after_fork": 1, "rounds_after_fork": 1},
+		},
+		"diff": []gin.H{
+			{
+				"index": 1,
+				"a":     gin.H{"uuid": "msg-demo-a1", "role": "user", "content": "示例追问A"},
+				"b":     gin.H{"uuid": "msg-demo-b1", "role": "user", "content": "示例追问B"},
+				"lines": []gin.H{
+					{"op": "|", "a": "示例追问A", "b": "示例追问B"},
+				},
+			},
+		},
+	})
+}
+
 // DeleteTree 删除树记录
 func (h *Handler) DeleteTree(c *gin.Context) {
 	treeID := strings.TrimSpace(c.Param("tree_id"))
@@ -361,13 +570,15 @@ func (h *Handler) ListTagConversations(c *gin.Context) {
 
 // StatsOverview 总览统计
 func (h *Handler) StatsOverview(c *gin.Context) {
+	sources := gin.H{}
+	for _, source := range sourceTypes {
+		sources[source] = 0
+	}
+	sources["gpt"] = 1
 	writeOK(c, gin.H{
 		"total_conversations": 1,
 		"total_messages":      1,
-		"sources": gin.H{
-			"gpt":    1,
-			"claude": 0,
-		},
+		"sources":             sources,
 	})
 }
 
@@ -392,6 +603,10 @@ func (h *Handler) SyncBatch(c *gin.Context) {
 		writeError(c, http.StatusBadRequest, 1, "source_type required")
 		return
 	}
+	if !isValidSource(req.SourceType) {
+		writeError(c, http.StatusBadRequest, 1, "invalid source_type: "+req.SourceType)
+		return
+	}
 	if len(req.Conversations) == 0 {
 		writeError(c, http.StatusBadRequest, 1, "conversations required")
 		return
@@ -30,6 +30,10 @@ func NewRouter() *gin.Engine {
 		api.GET("/conversations", h.ListConversations)
 		api.GET("/conversations/:uuid", h.GetConversation)
 		api.GET("/conversations/:uuid/messages", h.ListConversationMessages)
+		api.GET("/conversations/:uuid/export", h.ExportConversation)
+
+		api.GET("/projects", h.ListProjects)
+		api.GET("/projects/:uuid", h.GetProject)
 
 		api.GET("/messages/:uuid", h.GetMessage)
 		api.GET("/messages/:uuid/context", h.GetMessageContext)
@@ -39,6 +43,7 @@ func NewRouter() *gin.Engine {
 		api.GET("/trees", h.ListTrees)
 		api.POST("/tree/update", h.UpdateTree)
 		api.GET("/trees/:tree_id", h.GetTree)
+		api.GET("/trees/:tree_id/diff", h.DiffTree)
 		api.DELETE("/trees/:tree_id", h.DeleteTree)
 
 		api.POST("/favorites", h.CreateFavorite)
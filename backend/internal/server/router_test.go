@@ -4,6 +4,8 @@ import (
 	"encoding/json"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"path/filepath"
 	"strings"
 	"testing"
 )
@@ -20,13 +22,23 @@ func TestAPIHappyPaths(t *testing.T) {
 		{"list_conversations", http.MethodGet, "/api/v1/conversations", ""},
 		{"get_conversation", http.MethodGet, "/api/v1/conversations/conv-1", ""},
 		{"list_conversation_messages", http.MethodGet, "/api/v1/conversations/conv-1/messages", ""},
+		{"export_conversation_markdown", http.MethodGet, "/api/v1/conversations/conv-1/export", ""},
+		{"export_conversation_html", http.MethodGet, "/api/v1/conversations/conv-1/export?format=html&leaf=msg-1", ""},
+		{"list_projects", http.MethodGet, "/api/v1/projects", ""},
+		{"get_project", http.MethodGet, "/api/v1/projects/proj-1", ""},
 		{"get_message", http.MethodGet, "/api/v1/messages/msg-1", ""},
 		{"get_message_context", http.MethodGet, "/api/v1/messages/msg-1/context", ""},
 		{"search", http.MethodPost, "/api/v1/search", `{"keyword":"demo","page":1,"page_size":10}`},
+		{"search_sources", http.MethodPost, "/api/v1/search", `{"keyword":"demo","sources":["codex","gemini"]}`},
 		{"list_trees", http.MethodGet, "/api/v1/trees", ""},
 		{"update_tree_create", http.MethodPost, "/api/v1/tree/update", `{"conversation_uuids":["conv-1","conv-2"]}`},
 		{"update_tree_update", http.MethodPost, "/api/v1/tree/update", `{"tree_id":"tree-1","conversation_uuids":["conv-1"]}`},
+		{"update_tree_add", http.MethodPost, "/api/v1/tree/update", `{"tree_id":"tree-1","action":"add","conversation_uuids":["conv-3"]}`},
+		{"update_tree_remove", http.MethodPost, "/api/v1/tree/update", `{"tree_id":"tree-1","action":"remove","conversation_uuids":["conv-2"]}`},
+		{"update_tree_rebuild", http.MethodPost, "/api/v1/tree/update", `{"tree_id":"tree-1","action":"rebuild"}`},
 		{"get_tree", http.MethodGet, "/api/v1/trees/tree-1", ""},
+		{"get_tree_embed_full", http.MethodGet, "/api/v1/trees/tree-1?embed=full", ""},
+		{"diff_tree", http.MethodGet, "/api/v1/trees/tree-1/diff?a=conv-1&b=conv-2", ""},
 		{"delete_tree", http.MethodDelete, "/api/v1/trees/tree-1", ""},
 		{"create_favorite", http.MethodPost, "/api/v1/favorites", `{"target_type":"message","target_id":"msg-1","category":"default","notes":"demo"}`},
 		{"list_favorites", http.MethodGet, "/api/v1/favorites", ""},
@@ -41,6 +53,7 @@ func TestAPIHappyPaths(t *testing.T) {
 		{"stats_overview", http.MethodGet, "/api/v1/stats/overview", ""},
 		{"stats_by_date", http.MethodGet, "/api/v1/stats/by-date", ""},
 		{"sync_batch", http.MethodPost, "/internal/v1/sync/batch", `{"source_type":"gpt","conversations":[{"uuid":"conv-1"}]}`},
+		{"sync_batch_gemini", http.MethodPost, "/internal/v1/sync/batch", `{"source_type":"gemini","conversations":[{"uuid":"session-1"}]}`},
 	}
 
 	for _, tt := range tests {
@@ -66,3 +79,29 @@ func TestAPIHappyPaths(t *testing.T) {
 		})
 	}
 }
+
+func TestAddMappingSources(t *testing.T) {
+	defer func(saved []string) { sourceTypes = saved }(append([]string(nil), sourceTypes...))
+
+	dir := t.TempDir()
+	specs := map[string]string{
+		"open_webui.json": `{"source":"open_webui"}`,
+		"codex.json":      `{"source":"codex"}`,
+	}
+	for name, body := range specs {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(body), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	added, err := AddMappingSources(dir)
+	if err != nil {
+		t.Fatalf("AddMappingSources 失败: %v", err)
+	}
+	if len(added) != 1 || added[0] != "open_webui" {
+		t.Fatalf("新增来源应为 [open_webui]，实际 %v", added)
+	}
+	if !isValidSource("open_webui") {
+		t.Fatal("open_webui 应成为合法来源")
+	}
+}
@@ -20,6 +20,45 @@ const (
 // 全局变量存储项目根目录
 var projectRoot string
 
+// 支持的对话来源（对应 parsed/{source} 目录）
+// 启动时还会加入映射规则（scripts/mappings/*.json）中声明的来源
+var sources = []string{"gpt", "claude", "claude_code", "codex", "gemini", "aider", "cline"}
+
+// mappingSources 读取映射规则目录中每个规则声明的 source
+func mappingSources(dir string) []string {
+	files, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return nil
+	}
+
+	var result []string
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			continue
+		}
+		var spec struct {
+			Source string `json:"source"`
+		}
+		if err := json.Unmarshal(data, &spec); err != nil || spec.Source == "" {
+			log.Printf("警告: 映射规则 %s 中没有 source", file)
+			continue
+		}
+		result = append(result, spec.Source)
+	}
+	return result
+}
+
+// isValidSource 判断是否为支持的来源
+func isValidSource(source string) bool {
+	for _, s := range sources {
+		if s == source {
+			return true
+		}
+	}
+	return false
+}
+
 func init() {
 	// 获取当前执行文件的目录
 	execPath, err := os.Executable()
@@ -40,6 +79,13 @@ func init() {
 	}
 
 	log.Printf("项目根目录: %s", projectRoot)
+
+	// 通过映射规则导入的来源（parsed/<source>/conversation）同样可以访问
+	for _, source := range mappingSources(filepath.Join(projectRoot, "scripts", "mappings")) {
+		if !isValidSource(source) {
+			sources = append(sources, source)
+		}
+	}
 }
 
 // ConversationHandler 处理对话请求
@@ -72,15 +118,8 @@ func ConversationHandler(w http.ResponseWriter, r *http.Request) {
 	conversationID := pathParts[1]
 
 	// 验证 source
-	validSources := map[string]bool{
-		"gpt":         true,
-		"claude":      true,
-		"claude_code": true,
-		"codex":       true,
-	}
-
-	if !validSources[source] {
-		http.Error(w, fmt.Sprintf("无效的来源: %s。有效值为: gpt, claude, claude_code, codex", source), http.StatusBadRequest)
+	if !isValidSource(source) {
+		http.Error(w, fmt.Sprintf("无效的来源: %s。有效值为: %s", source, strings.Join(sources, ", ")), http.StatusBadRequest)
 		log.Printf("错误: 无效的来源: %s", source)
 		return
 	}
@@ -148,7 +187,6 @@ func ListSourcesHandler(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 	w.Header().Set("Access-Control-Allow-Origin", "*")
 
-	sources := []string{"gpt", "claude", "claude_code", "codex"}
 	result := make(map[string]interface{})
 
 	for _, source := range sources {
@@ -205,7 +243,7 @@ func main() {
 	log.Printf("  - GET /health - 健康检查")
 	log.Printf("  - GET /list - 列出所有可用的对话")
 	log.Printf("  - GET /{source}/{conversation_id} - 获取对话数据")
-	log.Printf("    有效的 source: gpt, claude, claude_code, codex")
+	log.Printf("    有效的 source: %s", strings.Join(sources, ", "))
 	log.Printf("    示例: http://localhost:%s/gpt/d4d4ddf6-5452-4dbb-9c1c-8a59ebfdb8fa", Port)
 
 	if err := http.ListenAndServe(addr, nil); err != nil {
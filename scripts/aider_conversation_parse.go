@@ -0,0 +1,438 @@
+package main
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Aider 聊天记录（.aider.chat.history.md）格式：
+//
+//	# aider chat started at 2024-05-01 10:00:00      会话开始
+//	> Aider v0.50.1                                  以 > 开头的是 aider 自身输出（命令、提交、token 统计等）
+//	#### add a hello function                        以 #### 开头的是用户输入，多行输入每行都带前缀
+//	其余内容为 assistant 回复，其中可能包含 SEARCH/REPLACE 编辑块
+const historyFileName = ".aider.chat.history.md"
+
+var (
+	sessionHeaderRe = regexp.MustCompile(`^# aider chat started at (.+)$`)
+	searchStartRe   = regexp.MustCompile(`^<{5,9} SEARCH\s*$`)
+	dividerRe       = regexp.MustCompile(`^={5,9}\s*$`)
+	replaceEndRe    = regexp.MustCompile(`^>{5,9} REPLACE\s*$`)
+	versionRe       = regexp.MustCompile(`^Aider (v\S+)`)
+	modelRe         = regexp.MustCompile(`^(?:Main model|Model): (\S+)`)
+	commitRe        = regexp.MustCompile(`^Commit ([0-9a-f]{7,40}) (.+)$`)
+)
+
+// errEmptySession 表示会话只有启动信息、没有对话内容
+var errEmptySession = errors.New("没有有效的内容节点")
+
+// 一个会话：开始时间和按顺序排列的消息块
+type AiderSession struct {
+	StartedAt string
+	Blocks    []AiderBlock
+}
+
+// 消息块：连续的同类行
+type AiderBlock struct {
+	Kind  string // user / assistant / output
+	Lines []string
+}
+
+// SEARCH/REPLACE 编辑块
+type AiderEdit struct {
+	File    string `json:"file"`
+	Search  string `json:"search"`
+	Replace string `json:"replace"`
+}
+
+// 输出节点结构（与GPT格式保持一致）
+type OutputNode struct {
+	ID          string                 `json:"id"`
+	ParentID    string                 `json:"parent_id"`
+	ChildID     string                 `json:"child_id"`
+	Role        string                 `json:"role"`
+	ContentType string                 `json:"content_type"`
+	Content     string                 `json:"content,omitempty"`
+	ToolData    map[string]interface{} `json:"tool_data,omitempty"`
+	CreateTime  *string                `json:"create_time"`
+}
+
+// 输出文件结构
+type OutputFile struct {
+	RoundCount int                    `json:"round_count"` // 对话轮数（user消息数量）
+	TotalCount int                    `json:"total_count"` // 总消息数量
+	Metadata   map[string]interface{} `json:"metadata,omitempty"`
+	Data       []OutputNode           `json:"data"`
+}
+
+func main() {
+	// 解析命令行参数
+	inputFile := flag.String("input", "", "输入的 .aider.chat.history.md 文件路径")
+	inputDir := flag.String("dir", "", "递归查找目录中的 "+historyFileName+" 文件")
+	outputDir := flag.String("output", "parsed/aider/conversation", "输出目录")
+	flag.Parse()
+
+	if *inputFile == "" && *inputDir == "" {
+		fmt.Println("错误: 必须指定输入文件或目录")
+		fmt.Println("用法: aider_conversation_parse -input <file> [-output <dir>]")
+		fmt.Println("      aider_conversation_parse -dir <projects_dir> [-output <dir>]")
+		os.Exit(1)
+	}
+	if *inputFile != "" && *inputDir != "" {
+		fmt.Println("错误: -input 和 -dir 不能同时指定")
+		os.Exit(1)
+	}
+
+	// 创建输出目录
+	if err := os.MkdirAll(*outputDir, 0755); err != nil {
+		fmt.Printf("创建输出目录失败: %v\n", err)
+		os.Exit(1)
+	}
+
+	files := []string{*inputFile}
+	if *inputDir != "" {
+		var err error
+		files, err = collectHistoryFiles(*inputDir)
+		if err != nil {
+			fmt.Printf("遍历目录失败: %v\n", err)
+			os.Exit(1)
+		}
+		if len(files) == 0 {
+			fmt.Printf("警告: 目录中没有 %s 文件\n", historyFileName)
+			os.Exit(0)
+		}
+		fmt.Printf("共找到 %d 个聊天记录文件\n", len(files))
+	}
+
+	sessionCount, failed := 0, 0
+	for _, file := range files {
+		sessions, err := readHistory(file)
+		if err != nil {
+			fmt.Printf("[失败] %s: %v\n", file, err)
+			failed++
+			continue
+		}
+
+		for _, session := range sessions {
+			outputFile, count, err := processSession(session, file, *outputDir)
+			if errors.Is(err, errEmptySession) {
+				// 只有启动信息、没有对话内容的会话很常见，直接跳过
+				continue
+			}
+			if err != nil {
+				fmt.Printf("[失败] %s (%s): %v\n", file, session.StartedAt, err)
+				failed++
+				continue
+			}
+			sessionCount++
+			fmt.Printf("已生成: %s (共 %d 条消息)\n", outputFile, count)
+		}
+	}
+
+	fmt.Printf("\n处理完成: 共 %d 个会话", sessionCount)
+	if failed > 0 {
+		fmt.Printf("，%d 个失败", failed)
+	}
+	fmt.Println()
+	if failed > 0 {
+		os.Exit(1)
+	}
+}
+
+// collectHistoryFiles 递归查找 aider 聊天记录文件（按路径排序）
+func collectHistoryFiles(dir string) ([]string, error) {
+	var files []string
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() && (d.Name() == ".git" || d.Name() == "node_modules") {
+			return filepath.SkipDir
+		}
+		if !d.IsDir() && d.Name() == historyFileName {
+			files = append(files, path)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Strings(files)
+	return files, nil
+}
+
+// readHistory 按 "# aider chat started at" 拆分会话，并将每个会话切分为消息块
+func readHistory(filename string) ([]AiderSession, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var sessions []AiderSession
+	var current *AiderSession
+	inFence := false // assistant 回复的代码块内可能出现以 > 开头的行
+
+	scanner := bufio.NewScanner(file)
+	// 增加缓冲区大小以处理长行
+	buf := make([]byte, 0, 1024*1024)
+	scanner.Buffer(buf, 10*1024*1024)
+
+	for scanner.Scan() {
+		line := scanner.Text()
+
+		if match := sessionHeaderRe.FindStringSubmatch(line); match != nil {
+			sessions = append(sessions, AiderSession{StartedAt: strings.TrimSpace(match[1])})
+			current = &sessions[len(sessions)-1]
+			inFence = false
+			continue
+		}
+		if current == nil {
+			continue
+		}
+
+		kind, text := "assistant", line
+		switch {
+		case inFence:
+		case strings.HasPrefix(line, "#### ") || line == "####":
+			kind, text = "user", strings.TrimPrefix(strings.TrimPrefix(line, "####"), " ")
+		case strings.HasPrefix(line, "> ") || line == ">":
+			// aider 输出行末尾带有 markdown 换行用的两个空格
+			kind, text = "output", strings.TrimRight(strings.TrimPrefix(strings.TrimPrefix(line, ">"), " "), " ")
+		}
+		if kind == "assistant" && strings.HasPrefix(strings.TrimSpace(line), "```") {
+			inFence = !inFence
+		}
+
+		// 空行归入当前块，避免把一段回复拆开
+		last := len(current.Blocks) - 1
+		if last >= 0 && (current.Blocks[last].Kind == kind || (line == "" && current.Blocks[last].Kind == "assistant")) {
+			current.Blocks[last].Lines = append(current.Blocks[last].Lines, text)
+			continue
+		}
+		if line == "" {
+			continue
+		}
+		current.Blocks = append(current.Blocks, AiderBlock{Kind: kind, Lines: []string{text}})
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return sessions, nil
+}
+
+// sessionID 根据开始时间和首条用户输入生成稳定的会话 id
+// 聊天记录只会追加，重复解析或会话继续增长时 id 保持不变
+func sessionID(session AiderSession) string {
+	firstPrompt := ""
+	for _, block := range session.Blocks {
+		if block.Kind == "user" {
+			firstPrompt = blockText(block)
+			break
+		}
+	}
+
+	hash := sha1.Sum([]byte(session.StartedAt + "\n" + firstPrompt))
+	prefix := "aider"
+	if startedAt, err := time.ParseInLocation("2006-01-02 15:04:05", session.StartedAt, time.Local); err == nil {
+		prefix += "-" + startedAt.Format("20060102-150405")
+	}
+	return prefix + "-" + hex.EncodeToString(hash[:])[:8]
+}
+
+func processSession(session AiderSession, historyFile string, outputDir string) (string, int, error) {
+	id := sessionID(session)
+	metadata := map[string]interface{}{
+		"session_id": id,
+		"started_at": session.StartedAt,
+	}
+	if absPath, err := filepath.Abs(historyFile); err == nil {
+		metadata["cwd"] = filepath.Dir(absPath)
+	}
+
+	nodes := []OutputNode{}
+	addNode := func(node OutputNode) {
+		node.ID = fmt.Sprintf("%s-%d", id, len(nodes))
+		if len(nodes) > 0 {
+			node.ParentID = nodes[len(nodes)-1].ID
+		}
+		nodes = append(nodes, node)
+	}
+
+	for _, block := range session.Blocks {
+		text := blockText(block)
+		if text == "" {
+			continue
+		}
+
+		switch block.Kind {
+		case "user":
+			addNode(OutputNode{Role: "user", ContentType: "text", Content: text})
+
+		case "assistant":
+			node := OutputNode{Role: "assistant", ContentType: "text", Content: text}
+			if edits := extractEdits(block.Lines); len(edits) > 0 {
+				node.ContentType = "tool_use"
+				node.ToolData = map[string]interface{}{
+					"name":  "search_replace",
+					"edits": edits,
+				}
+			}
+			addNode(node)
+
+		case "output":
+			// 启动信息中提取版本和模型
+			for _, line := range block.Lines {
+				if match := versionRe.FindStringSubmatch(line); match != nil {
+					metadata["aider_version"] = match[1]
+				}
+				if match := modelRe.FindStringSubmatch(line); match != nil {
+					if _, exists := metadata["model"]; !exists {
+						metadata["model"] = match[1]
+					}
+				}
+			}
+			if len(nodes) == 0 {
+				// 会话开始前的启动信息只作为元数据保留
+				continue
+			}
+
+			// 编辑块之后的输出（应用结果、提交记录）合并到对应的编辑节点
+			last := &nodes[len(nodes)-1]
+			if last.ContentType == "tool_use" && last.ToolData["output"] == nil {
+				last.ToolData["output"] = text
+				for _, line := range block.Lines {
+					if match := commitRe.FindStringSubmatch(line); match != nil {
+						last.ToolData["commit"] = match[1]
+						last.ToolData["commit_message"] = match[2]
+					}
+				}
+				continue
+			}
+			addNode(OutputNode{Role: "system", ContentType: "aider_output", Content: text})
+		}
+	}
+
+	roundCount := 0
+	for _, node := range nodes {
+		if node.Role == "user" {
+			roundCount++
+		}
+	}
+	if roundCount == 0 {
+		return "", 0, errEmptySession
+	}
+	linkChildren(nodes)
+
+	// 聊天记录中只有会话开始时间
+	startedAt := session.StartedAt
+	if t, err := time.ParseInLocation("2006-01-02 15:04:05", session.StartedAt, time.Local); err == nil {
+		startedAt = t.Format(time.RFC3339)
+	}
+	nodes[0].CreateTime = &startedAt
+
+	// 构建输出文件结构
+	output := OutputFile{
+		RoundCount: roundCount,
+		TotalCount: len(nodes),
+		Metadata:   metadata,
+		Data:       nodes,
+	}
+
+	// 序列化为JSON
+	jsonData, err := json.MarshalIndent(output, "", "  ")
+	if err != nil {
+		return "", 0, fmt.Errorf("序列化JSON失败: %v", err)
+	}
+
+	// 写入文件
+	outputFile := filepath.Join(outputDir, id+".json")
+	if err := ioutil.WriteFile(outputFile, jsonData, 0644); err != nil {
+		return "", 0, fmt.Errorf("写入文件失败: %v", err)
+	}
+
+	return outputFile, len(nodes), nil
+}
+
+// blockText 拼接消息块的文本并去掉首尾空行
+func blockText(block AiderBlock) string {
+	return strings.TrimSpace(strings.Join(block.Lines, "\n"))
+}
+
+// extractEdits 提取回复中的 SEARCH/REPLACE 编辑块
+// 文件名位于 <<<<<<< SEARCH 之前最近的非空行（跳过代码块围栏）
+func extractEdits(lines []string) []AiderEdit {
+	var edits []AiderEdit
+	for i := 0; i < len(lines); i++ {
+		if !searchStartRe.MatchString(lines[i]) {
+			continue
+		}
+
+		edit := AiderEdit{File: editFilename(lines[:i])}
+		var search, replace []string
+		inReplace := false
+		j := i + 1
+		for ; j < len(lines); j++ {
+			if !inReplace && dividerRe.MatchString(lines[j]) {
+				inReplace = true
+				continue
+			}
+			if inReplace && replaceEndRe.MatchString(lines[j]) {
+				break
+			}
+			if inReplace {
+				replace = append(replace, lines[j])
+			} else {
+				search = append(search, lines[j])
+			}
+		}
+
+		edit.Search = strings.Join(search, "\n")
+		edit.Replace = strings.Join(replace, "\n")
+		edits = append(edits, edit)
+		i = j
+	}
+	return edits
+}
+
+func editFilename(lines []string) string {
+	for i := len(lines) - 1; i >= 0; i-- {
+		line := strings.TrimSpace(lines[i])
+		if line == "" || strings.HasPrefix(line, "```") {
+			continue
+		}
+		return strings.Trim(line, "`*: ")
+	}
+	return ""
+}
+
+// linkChildren 根据 parent 关系补全 child_id
+func linkChildren(nodes []OutputNode) {
+	firstChild := make(map[string]string)
+	for _, node := range nodes {
+		if node.ParentID == "" {
+			continue
+		}
+		if _, exists := firstChild[node.ParentID]; !exists {
+			firstChild[node.ParentID] = node.ID
+		}
+	}
+	for i := range nodes {
+		nodes[i].ChildID = firstChild[nodes[i].ID]
+	}
+}
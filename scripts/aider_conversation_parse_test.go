@@ -0,0 +1,172 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// 运行方式（scripts 目录下各工具都是独立的 main 包，需要指定文件）:
+//
+//	go test -v aider_conversation_parse.go aider_conversation_parse_test.go
+
+func TestProcessSessionErrors(t *testing.T) {
+	dir := t.TempDir()
+	history := filepath.Join(dir, historyFileName)
+
+	startup := AiderSession{
+		StartedAt: "2024-05-01 10:00:00",
+		Blocks:    []AiderBlock{{Kind: "output", Lines: []string{"Aider v0.50.1"}}},
+	}
+	if _, _, err := processSession(startup, history, dir); !errors.Is(err, errEmptySession) {
+		t.Fatalf("只有启动信息的会话应返回 errEmptySession，实际 %v", err)
+	}
+
+	// 输出目录不存在时写入失败，不能被当作空会话跳过
+	chat := AiderSession{
+		StartedAt: "2024-05-01 10:00:00",
+		Blocks:    []AiderBlock{{Kind: "user", Lines: []string{"add a hello function"}}},
+	}
+	_, _, err := processSession(chat, history, filepath.Join(dir, "missing"))
+	if err == nil || errors.Is(err, errEmptySession) {
+		t.Fatalf("写入失败应返回其他错误，实际 %v", err)
+	}
+
+	if _, count, err := processSession(chat, history, dir); err != nil || count != 1 {
+		t.Fatalf("正常会话应生成 1 个节点，实际 %d, %v", count, err)
+	}
+}
+
+func TestReadHistory(t *testing.T) {
+	tests := []struct {
+		name     string
+		lines    []string
+		expected []AiderSession
+	}{
+		{
+			name: "按会话开始标记拆分，忽略第一个会话之前的内容",
+			lines: []string{
+				"stray",
+				"# aider chat started at 2024-05-01 10:00:00",
+				"#### hi",
+				"# aider chat started at 2024-05-02 09:00:00",
+				"#### again",
+			},
+			expected: []AiderSession{
+				{StartedAt: "2024-05-01 10:00:00", Blocks: []AiderBlock{{Kind: "user", Lines: []string{"hi"}}}},
+				{StartedAt: "2024-05-02 09:00:00", Blocks: []AiderBlock{{Kind: "user", Lines: []string{"again"}}}},
+			},
+		},
+		{
+			name: "多行输入合并为一个用户块，输出行去掉前缀和行尾空格",
+			lines: []string{
+				"# aider chat started at 2024-05-01 10:00:00",
+				"> Aider v0.50.1  ",
+				"> Main model: gpt-4o  ",
+				"",
+				"#### add a hello",
+				"#### function",
+				"",
+				"Sure.",
+			},
+			expected: []AiderSession{{StartedAt: "2024-05-01 10:00:00", Blocks: []AiderBlock{
+				{Kind: "output", Lines: []string{"Aider v0.50.1", "Main model: gpt-4o"}},
+				{Kind: "user", Lines: []string{"add a hello", "function"}},
+				{Kind: "assistant", Lines: []string{"Sure."}},
+			}}},
+		},
+		{
+			name: "代码块内以 > 开头的行属于回复",
+			lines: []string{
+				"# aider chat started at 2024-05-01 10:00:00",
+				"#### quote",
+				"```",
+				"> not output",
+				"```",
+				"> Applied edit to a.py",
+			},
+			expected: []AiderSession{{StartedAt: "2024-05-01 10:00:00", Blocks: []AiderBlock{
+				{Kind: "user", Lines: []string{"quote"}},
+				{Kind: "assistant", Lines: []string{"```", "> not output", "```"}},
+				{Kind: "output", Lines: []string{"Applied edit to a.py"}},
+			}}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), historyFileName)
+			if err := os.WriteFile(path, []byte(strings.Join(tt.lines, "\n")+"\n"), 0644); err != nil {
+				t.Fatal(err)
+			}
+			sessions, err := readHistory(path)
+			if err != nil {
+				t.Fatalf("readHistory 失败: %v", err)
+			}
+			if !reflect.DeepEqual(sessions, tt.expected) {
+				t.Errorf("readHistory() = %+v，期望 %+v", sessions, tt.expected)
+			}
+		})
+	}
+}
+
+func TestExtractEdits(t *testing.T) {
+	tests := []struct {
+		name     string
+		lines    []string
+		expected []AiderEdit
+	}{
+		{
+			name:     "没有编辑块",
+			lines:    []string{"just text"},
+			expected: nil,
+		},
+		{
+			name: "文件名在代码块围栏之前",
+			lines: []string{
+				"hello.py",
+				"```python",
+				"<<<<<<< SEARCH",
+				"old",
+				"=======",
+				"new",
+				"line",
+				">>>>>>> REPLACE",
+				"```",
+			},
+			expected: []AiderEdit{{File: "hello.py", Search: "old", Replace: "new\nline"}},
+		},
+		{
+			name: "多个编辑块，文件名带 markdown 标记，新建文件的 SEARCH 为空",
+			lines: []string{
+				"**a.py**",
+				"<<<<<<< SEARCH",
+				"x = 1",
+				"=======",
+				"x = 2",
+				">>>>>>> REPLACE",
+				"",
+				"`b.py`:",
+				"<<<<<<< SEARCH",
+				"=======",
+				"print()",
+				">>>>>>> REPLACE",
+			},
+			expected: []AiderEdit{
+				{File: "a.py", Search: "x = 1", Replace: "x = 2"},
+				{File: "b.py", Search: "", Replace: "print()"},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if edits := extractEdits(tt.lines); !reflect.DeepEqual(edits, tt.expected) {
+				t.Errorf("extractEdits() = %+v，期望 %+v", edits, tt.expected)
+			}
+		})
+	}
+}
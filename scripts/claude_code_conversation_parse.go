@@ -5,27 +5,80 @@ import (
 	"encoding/json"
 	"flag"
 	"fmt"
+	"io/fs"
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"runtime"
+	"sort"
 	"strings"
+	"sync"
+	"time"
 )
 
 // Claude Code 数据结构定义
 type ClaudeCodeMessage struct {
-	Type        string                 `json:"type"`
-	UUID        string                 `json:"uuid"`
-	ParentUUID  *string                `json:"parentUuid"`
-	SessionID   string                 `json:"sessionId"`
-	Timestamp   string                 `json:"timestamp"`
-	Message     *MessageContent        `json:"message"`
-	IsMeta      bool                   `json:"isMeta"`
-	Children    []string               `json:"-"` // 用于构建关系
+	Type       string          `json:"type"`
+	UUID       string          `json:"uuid"`
+	ParentUUID *string         `json:"parentUuid"`
+	SessionID  string          `json:"sessionId"`
+	Timestamp  string          `json:"timestamp"`
+	Message    *MessageContent `json:"message"`
+	IsMeta     bool            `json:"isMeta"`
+
+	// 运行环境
+	Cwd       string `json:"cwd"`
+	GitBranch string `json:"gitBranch"`
+	Version   string `json:"version"`
+	UserType  string `json:"userType"`
+	RequestID string `json:"requestId"`
+
+	// 子代理（Task）线程
+	IsSidechain bool   `json:"isSidechain"`
+	AgentID     string `json:"agentId"`
+
+	// summary 记录
+	Summary  string `json:"summary"`
+	LeafUUID string `json:"leafUuid"`
+
+	// 压缩边界（system/compact_boundary）及压缩后的摘要消息
+	Subtype           string           `json:"subtype"`
+	Content           string           `json:"content"`
+	LogicalParentUUID string           `json:"logicalParentUuid"`
+	CompactMetadata   *CompactMetadata `json:"compactMetadata"`
+	IsCompactSummary  bool             `json:"isCompactSummary"`
+
+	// 以下字段不来自 JSON，合并流式片段时填充
+	Aliases   []string          `json:"-"` // 被合并片段的 uuid
+	ToolTimes map[string]string `json:"-"` // tool_use id -> 携带该调用的片段时间
+}
+
+type CompactMetadata struct {
+	Trigger   string `json:"trigger"`
+	PreTokens int    `json:"preTokens"`
 }
 
 type MessageContent struct {
+	ID      string          `json:"id"` // 流式写入时同一回复的多行记录共用此 id
 	Role    string          `json:"role"`
+	Model   string          `json:"model"`
 	Content json.RawMessage `json:"content"`
+	Usage   *Usage          `json:"usage,omitempty"`
+}
+
+// token 用量
+type Usage struct {
+	InputTokens              int `json:"input_tokens"`
+	OutputTokens             int `json:"output_tokens"`
+	CacheCreationInputTokens int `json:"cache_creation_input_tokens"`
+	CacheReadInputTokens     int `json:"cache_read_input_tokens"`
+}
+
+// tool_result 内容块
+type ToolResult struct {
+	ToolUseID string
+	Output    string
+	IsError   bool
 }
 
 // 输出节点结构（与GPT格式保持一致）
@@ -38,56 +91,291 @@ type OutputNode struct {
 	Content     string                 `json:"content,omitempty"`
 	Images      []string               `json:"images,omitempty"`
 	ToolData    map[string]interface{} `json:"tool_data,omitempty"`
+	Blocks      []ContentBlock         `json:"blocks,omitempty"` // 多个内容块时按原始顺序保留
+	Metadata    map[string]interface{} `json:"metadata,omitempty"`
+	Aliases     []string               `json:"aliases,omitempty"` // 合并到此节点的流式片段 uuid，收藏和链接可通过它们找到本节点
 	CreateTime  *string                `json:"create_time"`
 }
 
+// 内容块结构（text、thinking、tool_use 等）
+type ContentBlock struct {
+	Type     string                 `json:"type"`
+	Text     string                 `json:"text,omitempty"`
+	ToolData map[string]interface{} `json:"tool_data,omitempty"`
+}
+
 // 输出文件结构
 type OutputFile struct {
-	RoundCount int          `json:"round_count"` // 对话轮数（user/human消息数量）
-	TotalCount int          `json:"total_count"` // 总消息数量
-	ProjectID  string       `json:"project_id,omitempty"`
-	Data       []OutputNode `json:"data"`
+	RoundCount int                    `json:"round_count"` // 对话轮数（user/human消息数量）
+	TotalCount int                    `json:"total_count"` // 总消息数量
+	ProjectID  string                 `json:"project_id,omitempty"`
+	Metadata   map[string]interface{} `json:"metadata,omitempty"`
+	Data       []OutputNode           `json:"data"`
+	Subagents  []SubagentThread       `json:"subagents,omitempty"` // Task 工具启动的子代理对话
+}
+
+// 子代理对话线程，通过 tool_use_id 关联启动它的 Task 调用
+type SubagentThread struct {
+	ID           string       `json:"id"`
+	ToolUseID    string       `json:"tool_use_id,omitempty"`
+	Description  string       `json:"description,omitempty"`
+	SubagentType string       `json:"subagent_type,omitempty"`
+	RoundCount   int          `json:"round_count"`
+	TotalCount   int          `json:"total_count"`
+	Data         []OutputNode `json:"data"`
+}
+
+// 单个会话文件的处理结果
+type FileResult struct {
+	InputFile  string
+	OutputFile string
+	ProjectID  string
+	Count      int
+	Skipped    bool // 文件中没有有效消息
+	Err        error
 }
 
 func main() {
 	// 解析命令行参数
 	inputFile := flag.String("input", "", "输入的JSONL文件路径")
+	inputDir := flag.String("dir", "", "项目目录（如 ~/.claude/projects），递归解析其中所有 .jsonl 文件")
 	outputDir := flag.String("output", "parsed/claude_code/conversation", "输出目录")
+	workers := flag.Int("workers", runtime.NumCPU(), "目录模式下的并发解析数")
 	flag.Parse()
 
-	if *inputFile == "" {
-		fmt.Println("错误: 必须指定输入文件")
+	if *inputFile == "" && *inputDir == "" {
+		fmt.Println("错误: 必须指定输入文件或目录")
 		fmt.Println("用法: claude_code_conversation_parse -input <file> [-output <dir>]")
+		fmt.Println("      claude_code_conversation_parse -dir <projects_dir> [-output <dir>] [-workers <n>]")
 		os.Exit(1)
 	}
-
-	// 读取并解析JSONL文件
-	messages, sessionID, err := readJSONL(*inputFile)
-	if err != nil {
-		fmt.Printf("读取文件失败: %v\n", err)
+	if *inputFile != "" && *inputDir != "" {
+		fmt.Println("错误: -input 和 -dir 不能同时指定")
 		os.Exit(1)
 	}
 
-	if len(messages) == 0 {
-		fmt.Println("警告: 文件中没有有效的消息")
-		os.Exit(0)
-	}
-
 	// 创建输出目录
 	if err := os.MkdirAll(*outputDir, 0755); err != nil {
 		fmt.Printf("创建输出目录失败: %v\n", err)
 		os.Exit(1)
 	}
 
-	// 处理对话
-	if err := processConversation(messages, sessionID, *outputDir); err != nil {
-		fmt.Printf("处理对话失败: %v\n", err)
+	// 目录模式
+	if *inputDir != "" {
+		files, err := collectSessionFiles(*inputDir)
+		if err != nil {
+			fmt.Printf("遍历目录失败: %v\n", err)
+			os.Exit(1)
+		}
+		if len(files) == 0 {
+			fmt.Println("警告: 目录中没有 .jsonl 文件")
+			os.Exit(0)
+		}
+
+		// 并发写入前为每个文件分配不重复的输出文件名
+		outputNames := planOutputNames(files)
+		parse := func(file string) FileResult {
+			return parseFile(file, *outputDir, outputNames[file])
+		}
+
+		fmt.Printf("共找到 %d 个会话文件，并发数 %d\n", len(files), *workers)
+		results := runBatch(files, *workers, parse)
+		if failed := printResults(results); failed > 0 {
+			os.Exit(1)
+		}
+		return
+	}
+
+	// 单文件模式
+	result := parseFile(*inputFile, *outputDir, "")
+	if result.Err != nil {
+		fmt.Printf("处理对话失败: %v\n", result.Err)
 		os.Exit(1)
 	}
+	if result.Skipped {
+		fmt.Println("警告: 文件中没有有效的消息")
+		os.Exit(0)
+	}
 
+	fmt.Printf("已生成: %s (共 %d 条消息)\n", result.OutputFile, result.Count)
 	fmt.Println("处理完成")
 }
 
+// parseFile 解析单个会话文件并写入输出目录，outputName 为空时按会话ID命名输出文件
+func parseFile(inputFile string, outputDir string, outputName string) FileResult {
+	result := FileResult{
+		InputFile: inputFile,
+		ProjectID: projectIDFromPath(inputFile),
+	}
+
+	messages, sessionID, err := readJSONL(inputFile)
+	if err != nil {
+		result.Err = fmt.Errorf("读取文件失败: %v", err)
+		return result
+	}
+
+	if !hasMessages(messages) {
+		result.Skipped = true
+		return result
+	}
+
+	// 新版本将子代理记录写入同目录下的 agent-*.jsonl，按 sessionId 合并
+	if sessionID != "" {
+		messages = append(messages, readAgentFiles(inputFile, sessionID)...)
+	}
+
+	result.OutputFile, result.Count, result.Err = processConversation(messages, sessionID, outputName, result.ProjectID, outputDir)
+	return result
+}
+
+// projectIDFromPath 从会话文件所在目录名解码项目路径
+// Claude Code 将项目路径中的 / 和 . 等字符替换为 - 作为目录名，
+// 例如 -Users-foo-projects-bar 对应 /Users/foo/projects/bar。
+// 该编码不可逆（原路径中的 - 也会被还原为 /），仅用于生成可读的项目标识。
+func projectIDFromPath(inputFile string) string {
+	dirName := filepath.Base(filepath.Dir(inputFile))
+	if !strings.HasPrefix(dirName, "-") {
+		return ""
+	}
+	return decodeProjectPath(dirName)
+}
+
+func decodeProjectPath(encoded string) string {
+	parts := strings.Split(strings.TrimPrefix(encoded, "-"), "-")
+	segments := []string{}
+	for _, part := range parts {
+		// 连续的 - 通常来自隐藏目录（如 /.config），还原为 .
+		if part == "" {
+			if len(segments) > 0 {
+				segments[len(segments)-1] += "."
+			}
+			continue
+		}
+		if len(segments) > 0 && strings.HasSuffix(segments[len(segments)-1], ".") {
+			segments[len(segments)-1] = strings.TrimSuffix(segments[len(segments)-1], ".")
+			segments = append(segments, "."+part)
+			continue
+		}
+		segments = append(segments, part)
+	}
+	return "/" + strings.Join(segments, "/")
+}
+
+// collectSessionFiles 递归收集目录下的所有 .jsonl 文件（按路径排序）
+func collectSessionFiles(dir string) ([]string, error) {
+	var files []string
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		// agent-*.jsonl 是子代理记录，随所属会话一起解析
+		if !d.IsDir() && strings.HasSuffix(d.Name(), ".jsonl") && !isAgentFile(path) {
+			files = append(files, path)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Strings(files)
+	return files, nil
+}
+
+// planOutputNames 按文件顺序为会话ID相同的文件（如恢复会话时复制了原会话记录）分配不同的输出文件名：
+// 第一个文件使用会话ID，之后的依次加 _2、_3 后缀。文件已排序，因此结果与并发顺序无关
+func planOutputNames(files []string) map[string]string {
+	names := make(map[string]string, len(files))
+	used := make(map[string]int)
+	for _, file := range files {
+		sessionID := sanitizeFilename(peekSessionID(file))
+		if sessionID == "" {
+			continue
+		}
+		used[sessionID]++
+		if used[sessionID] == 1 {
+			names[file] = sessionID
+		} else {
+			names[file] = fmt.Sprintf("%s_%d", sessionID, used[sessionID])
+		}
+	}
+	return names
+}
+
+// peekSessionID 读取文件中第一个带 sessionId 的记录，不解析完整内容
+func peekSessionID(filename string) string {
+	file, err := os.Open(filename)
+	if err != nil {
+		return ""
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	const maxCapacity = 1024 * 1024 // 1MB
+	scanner.Buffer(make([]byte, maxCapacity), maxCapacity)
+	for scanner.Scan() {
+		var msg struct {
+			SessionID string `json:"sessionId"`
+		}
+		if json.Unmarshal(scanner.Bytes(), &msg) == nil && msg.SessionID != "" {
+			return msg.SessionID
+		}
+	}
+	return ""
+}
+
+// runBatch 以有限并发解析所有文件，结果顺序与输入顺序一致
+func runBatch(files []string, workers int, parse func(string) FileResult) []FileResult {
+	if workers < 1 {
+		workers = 1
+	}
+
+	results := make([]FileResult, len(files))
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				results[i] = parse(files[i])
+			}
+		}()
+	}
+
+	for i := range files {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+
+	return results
+}
+
+// printResults 输出每个文件的处理结果和汇总，返回失败数量
+func printResults(results []FileResult) int {
+	success, skipped, failed := 0, 0, 0
+	projects := make(map[string]int)
+	for _, result := range results {
+		switch {
+		case result.Err != nil:
+			failed++
+			fmt.Printf("[失败] %s: %v\n", result.InputFile, result.Err)
+		case result.Skipped:
+			skipped++
+			fmt.Printf("[跳过] %s: 没有有效的消息\n", result.InputFile)
+		default:
+			success++
+			projects[result.ProjectID]++
+			fmt.Printf("[成功] %s -> %s (项目: %s, 共 %d 条消息)\n", result.InputFile, result.OutputFile, result.ProjectID, result.Count)
+		}
+	}
+
+	fmt.Printf("\n处理完成: 成功 %d, 跳过 %d, 失败 %d, 共 %d (涉及 %d 个项目)\n", success, skipped, failed, len(results), len(projects))
+	return failed
+}
+
 func readJSONL(filename string) ([]ClaudeCodeMessage, string, error) {
 	file, err := os.Open(filename)
 	if err != nil {
@@ -116,6 +404,12 @@ func readJSONL(filename string) ([]ClaudeCodeMessage, string, error) {
 			continue
 		}
 
+		// summary 记录和压缩边界没有 message 字段，单独保留
+		if msg.Type == "summary" || (msg.Type == "system" && msg.Subtype == "compact_boundary" && msg.UUID != "") {
+			messages = append(messages, msg)
+			continue
+		}
+
 		// 只处理有消息内容的记录
 		if msg.Message != nil && msg.UUID != "" {
 			// 跳过元数据消息
@@ -136,178 +430,704 @@ func readJSONL(filename string) ([]ClaudeCodeMessage, string, error) {
 	return messages, sessionID, nil
 }
 
-func processConversation(messages []ClaudeCodeMessage, sessionID string, outputDir string) error {
-	if len(messages) == 0 {
-		return fmt.Errorf("没有有效的消息节点")
+// hasMessages 判断记录中是否有 summary 以外的消息
+func hasMessages(messages []ClaudeCodeMessage) bool {
+	for _, msg := range messages {
+		if msg.Type != "summary" {
+			return true
+		}
 	}
+	return false
+}
 
-	// 构建父子关系
-	msgMap := make(map[string]*ClaudeCodeMessage)
-	for i := range messages {
-		msgMap[messages[i].UUID] = &messages[i]
-	}
+func isAgentFile(path string) bool {
+	return strings.HasPrefix(filepath.Base(path), "agent-")
+}
+
+// 子代理文件索引：目录 -> 会话ID -> 该会话的 agent-*.jsonl 文件，每个目录只扫描一次
+var agentIndex = struct {
+	sync.Mutex
+	dirs map[string]map[string][]string
+}{dirs: make(map[string]map[string][]string)}
+
+// agentFilesFor 返回目录中属于指定会话的子代理文件，首次访问目录时建立索引
+func agentFilesFor(dir string, sessionID string) []string {
+	agentIndex.Lock()
+	defer agentIndex.Unlock()
 
-	// 构建子节点列表
-	for i := range messages {
-		if messages[i].ParentUUID != nil && *messages[i].ParentUUID != "" {
-			if parent, exists := msgMap[*messages[i].ParentUUID]; exists {
-				parent.Children = append(parent.Children, messages[i].UUID)
+	index, exists := agentIndex.dirs[dir]
+	if !exists {
+		index = make(map[string][]string)
+		agentFiles, _ := filepath.Glob(filepath.Join(dir, "agent-*.jsonl"))
+		sort.Strings(agentFiles)
+		for _, agentFile := range agentFiles {
+			if agentSessionID := peekSessionID(agentFile); agentSessionID != "" {
+				index[agentSessionID] = append(index[agentSessionID], agentFile)
 			}
 		}
+		agentIndex.dirs[dir] = index
 	}
+	return index[sessionID]
+}
 
-	// 转换为输出格式
-	nodes := []OutputNode{}
-	for _, msg := range messages {
-		contentType, content, toolData := extractContent(msg.Message)
+// readAgentFiles 读取与会话文件同目录、属于同一会话的子代理记录
+func readAgentFiles(inputFile string, sessionID string) []ClaudeCodeMessage {
+	if isAgentFile(inputFile) {
+		return nil
+	}
 
-		// 如果内容为空且没有tool_data，跳过
-		if content == "" && toolData == nil {
+	var records []ClaudeCodeMessage
+	for _, agentFile := range agentFilesFor(filepath.Dir(inputFile), sessionID) {
+		agentMessages, _, err := readJSONL(agentFile)
+		if err != nil {
 			continue
 		}
+		for _, msg := range agentMessages {
+			msg.IsSidechain = true
+			records = append(records, msg)
+		}
+	}
+	return records
+}
+
+func processConversation(messages []ClaudeCodeMessage, sessionID string, outputName string, projectID string, outputDir string) (string, int, error) {
+	if len(messages) == 0 {
+		return "", 0, fmt.Errorf("没有有效的消息节点")
+	}
+
+	// 区分主链记录、子代理记录和会话摘要
+	var mainRecords, sideRecords []ClaudeCodeMessage
+	var summaries []map[string]interface{}
+	for _, msg := range messages {
+		switch {
+		case msg.Type == "summary":
+			if msg.Summary != "" {
+				summaries = append(summaries, map[string]interface{}{
+					"summary":   msg.Summary,
+					"leaf_uuid": msg.LeafUUID,
+				})
+			}
+		case msg.IsSidechain:
+			sideRecords = append(sideRecords, msg)
+		default:
+			mainRecords = append(mainRecords, msg)
+		}
+	}
+
+	// 单独解析 agent-*.jsonl 时，整个文件就是一条子代理线程
+	if len(mainRecords) == 0 {
+		mainRecords, sideRecords = sideRecords, nil
+	}
+
+	nodes := buildNodes(mainRecords)
+	if len(nodes) == 0 {
+		return "", 0, fmt.Errorf("没有有效的内容节点")
+	}
+	subagents := buildSubagents(sideRecords, nodes)
+
+	// 生成输出文件名
+	if outputName == "" {
+		outputName = sessionID
+	}
+	if outputName == "" {
+		outputName = mainRecords[0].UUID
+	}
+	outputName = sanitizeFilename(outputName)
+	outputFile := filepath.Join(outputDir, outputName+".json")
+
+	// 构建输出文件结构
+	output := OutputFile{
+		RoundCount: countRounds(nodes),
+		TotalCount: len(nodes),
+		ProjectID:  projectID,
+		Data:       nodes,
+		Subagents:  subagents,
+	}
+	output.Metadata = sessionMetadata(nodes, subagents)
+	if len(summaries) > 0 {
+		output.Metadata["summaries"] = summaries
+	}
 
+	// 序列化为JSON
+	jsonData, err := json.MarshalIndent(output, "", "  ")
+	if err != nil {
+		return "", 0, fmt.Errorf("序列化JSON失败: %v", err)
+	}
+
+	// 写入文件
+	if err := ioutil.WriteFile(outputFile, jsonData, 0644); err != nil {
+		return "", 0, fmt.Errorf("写入文件失败: %v", err)
+	}
+
+	return outputFile, len(nodes), nil
+}
+
+// countRounds 统计对话轮数（用户消息数量，不含压缩摘要）
+func countRounds(nodes []OutputNode) int {
+	roundCount := 0
+	for _, node := range nodes {
+		if (node.Role == "user" || node.Role == "human") && node.ContentType != "compact_summary" {
+			roundCount++
+		}
+	}
+	return roundCount
+}
+
+// buildNodes 将一条消息链上的记录转换为输出节点
+func buildNodes(messages []ClaudeCodeMessage) []OutputNode {
+	nodes := []OutputNode{}
+	toolIndex := make(map[string]map[string]interface{}) // tool_use id -> 调用的 tool_data
+	toolTimes := make(map[string]string)                 // tool_use id -> 调用时间
+	skippedParent := make(map[string]string)             // 被跳过的消息 uuid -> 其父消息 uuid
+
+	messages, fragments := mergeFragments(messages)
+	for uuid, headUUID := range fragments {
+		skippedParent[uuid] = headUUID
+	}
+
+	for _, msg := range messages {
 		parentID := ""
 		if msg.ParentUUID != nil {
 			parentID = *msg.ParentUUID
 		}
 
-		childID := ""
-		if len(msg.Children) > 0 {
-			childID = msg.Children[0] // 取第一个子节点
+		// 压缩边界：parentUuid 为空，通过 logicalParentUuid 接回压缩前的消息链
+		if msg.Type == "system" {
+			if parentID == "" {
+				parentID = msg.LogicalParentUUID
+			}
+			node := OutputNode{
+				ID:          msg.UUID,
+				ParentID:    parentID,
+				Role:        "system",
+				ContentType: msg.Subtype,
+				Content:     msg.Content,
+				CreateTime:  &msg.Timestamp,
+			}
+			if msg.CompactMetadata != nil {
+				node.Metadata = map[string]interface{}{
+					"trigger":    msg.CompactMetadata.Trigger,
+					"pre_tokens": msg.CompactMetadata.PreTokens,
+				}
+			}
+			nodes = append(nodes, node)
+			continue
+		}
+
+		// tool_result 按 tool_use_id 合并到对应的 tool_use 节点
+		merged := make(map[string]bool)
+		for _, result := range extractToolResults(msg.Message) {
+			if toolData, ok := toolIndex[result.ToolUseID]; ok {
+				attachToolResult(toolData, result, toolTimes[result.ToolUseID], msg.Timestamp)
+				merged[result.ToolUseID] = true
+			}
+		}
+
+		// 找不到对应调用的 tool_result（例如调用记录已被压缩）在原位置保留为 tool_result 块，
+		// 消息只有这些结果时作为 tool 节点
+		contentType, content, toolData, blocks := extractContent(msg.Message, merged)
+		role := msg.Message.Role
+		if onlyToolResults(contentType, blocks) {
+			role = "tool"
+		}
+
+		// 如果内容为空且没有tool_data（如只携带 tool_result 的用户消息），跳过
+		// 并记录其父节点，使子节点可以挂接到最近的保留祖先上
+		if content == "" && toolData == nil && blocks == nil {
+			skippedParent[msg.UUID] = parentID
+			continue
+		}
+
+		// 压缩后自动生成的摘要消息不是用户输入
+		if msg.IsCompactSummary {
+			contentType = "compact_summary"
 		}
 
 		node := OutputNode{
 			ID:          msg.UUID,
 			ParentID:    parentID,
-			ChildID:     childID,
-			Role:        msg.Message.Role,
+			Role:        role,
 			ContentType: contentType,
 			Content:     content,
 			ToolData:    toolData,
+			Blocks:      blocks,
+			Metadata:    nodeMetadata(msg),
+			Aliases:     msg.Aliases,
 			CreateTime:  &msg.Timestamp,
 		}
 
 		nodes = append(nodes, node)
+		for _, toolUse := range nodeToolUses(node) {
+			if id, ok := toolUse["id"].(string); ok && id != "" {
+				toolIndex[id] = toolUse
+				// 合并的回复中，调用时间取携带该 tool_use 的片段时间，而不是首个片段的时间
+				toolTimes[id] = msg.Timestamp
+				if fragmentTime, ok := msg.ToolTimes[id]; ok {
+					toolTimes[id] = fragmentTime
+				}
+			}
+		}
 	}
 
-	if len(nodes) == 0 {
-		return fmt.Errorf("没有有效的内容节点")
+	// 重新挂接父节点，并根据parent关系补全child_id
+	for i := range nodes {
+		nodes[i].ParentID = resolveParent(nodes[i].ParentID, skippedParent)
 	}
+	linkChildren(nodes)
 
-	// 生成输出文件名
-	if sessionID == "" {
-		sessionID = messages[0].UUID
+	return nodes
+}
+
+// nodeMetadata 提取单条记录的运行环境、模型和用量信息
+func nodeMetadata(msg ClaudeCodeMessage) map[string]interface{} {
+	metadata := make(map[string]interface{})
+	setIfNotEmpty := func(key, value string) {
+		if value != "" {
+			metadata[key] = value
+		}
+	}
+	setIfNotEmpty("cwd", msg.Cwd)
+	setIfNotEmpty("git_branch", msg.GitBranch)
+	setIfNotEmpty("version", msg.Version)
+	setIfNotEmpty("user_type", msg.UserType)
+	setIfNotEmpty("request_id", msg.RequestID)
+	if msg.Message != nil {
+		// 合成消息（如中断提示）的 model 为 <synthetic>，不计入
+		if msg.Message.Model != "<synthetic>" {
+			setIfNotEmpty("model", msg.Message.Model)
+		}
+		if msg.Message.Usage != nil {
+			metadata["usage"] = msg.Message.Usage
+		}
 	}
-	sessionID = sanitizeFilename(sessionID)
-	outputFile := filepath.Join(outputDir, sessionID+".json")
 
-	// 计算统计信息
-	totalCount := len(nodes)
-	roundCount := 0
-	for _, node := range nodes {
-		if node.Role == "user" || node.Role == "human" {
-			roundCount++
+	if len(metadata) == 0 {
+		return nil
+	}
+	return metadata
+}
+
+// sessionMetadata 汇总会话级信息：工作目录、分支、使用的模型、token 用量和缓存命中率
+// 子代理线程的用量同样计入会话总量
+func sessionMetadata(nodes []OutputNode, subagents []SubagentThread) map[string]interface{} {
+	metadata := make(map[string]interface{})
+	total := Usage{}
+	var cwds, branches, models []string
+	appendUnique := func(list []string, value interface{}) []string {
+		str, _ := value.(string)
+		if str == "" {
+			return list
+		}
+		for _, existing := range list {
+			if existing == str {
+				return list
+			}
 		}
+		return append(list, str)
 	}
 
-	// 构建输出文件结构
-	output := OutputFile{
-		RoundCount: roundCount,
-		TotalCount: totalCount,
-		Data:       nodes,
+	allNodes := append([]OutputNode{}, nodes...)
+	for _, thread := range subagents {
+		allNodes = append(allNodes, thread.Data...)
+	}
+	for _, node := range allNodes {
+		if node.Metadata == nil {
+			continue
+		}
+		cwds = appendUnique(cwds, node.Metadata["cwd"])
+		branches = appendUnique(branches, node.Metadata["git_branch"])
+		models = appendUnique(models, node.Metadata["model"])
+		if usage, ok := node.Metadata["usage"].(*Usage); ok {
+			total.InputTokens += usage.InputTokens
+			total.OutputTokens += usage.OutputTokens
+			total.CacheCreationInputTokens += usage.CacheCreationInputTokens
+			total.CacheReadInputTokens += usage.CacheReadInputTokens
+		}
+		if version, ok := node.Metadata["version"].(string); ok {
+			metadata["version"] = version
+		}
 	}
 
-	// 序列化为JSON
-	jsonData, err := json.MarshalIndent(output, "", "  ")
-	if err != nil {
-		return fmt.Errorf("序列化JSON失败: %v", err)
+	if len(cwds) > 0 {
+		metadata["cwd"] = cwds[0]
+	}
+	if len(cwds) > 1 {
+		metadata["cwds"] = cwds
+	}
+	if len(branches) > 0 {
+		metadata["git_branch"] = branches[0]
+	}
+	if len(branches) > 1 {
+		metadata["git_branches"] = branches
+	}
+	if len(models) > 0 {
+		metadata["models"] = models
 	}
 
-	// 写入文件
-	if err := ioutil.WriteFile(outputFile, jsonData, 0644); err != nil {
-		return fmt.Errorf("写入文件失败: %v", err)
+	// 缓存命中率 = 缓存读取 / 全部输入（未缓存输入 + 缓存写入 + 缓存读取）
+	totalInput := total.InputTokens + total.CacheCreationInputTokens + total.CacheReadInputTokens
+	if totalInput > 0 || total.OutputTokens > 0 {
+		metadata["usage"] = total
 	}
+	if totalInput > 0 {
+		metadata["cache_hit_rate"] = float64(total.CacheReadInputTokens) / float64(totalInput)
+	}
+
+	return metadata
+}
+
+// mergeFragments 将流式写入的同一条 assistant 回复（message.id 相同、uuid 不同）合并为一条记录
+// 合并后的记录保留首个片段的 uuid、位置和时间，内容块按原始顺序拼接，
+// 被合并片段的 uuid 记入 Aliases，其中 tool_use 的时间记入 ToolTimes；
+// 返回的映射记录被合并片段 uuid -> 首个片段 uuid，用于重新挂接父节点
+func mergeFragments(messages []ClaudeCodeMessage) ([]ClaudeCodeMessage, map[string]string) {
+	merged := []ClaudeCodeMessage{}
+	fragments := make(map[string]string)
+	heads := make(map[string]int) // message.id -> merged 中的下标
+
+	for _, msg := range messages {
+		if msg.Message == nil || msg.Message.ID == "" {
+			merged = append(merged, msg)
+			continue
+		}
+
+		idx, exists := heads[msg.Message.ID]
+		if !exists {
+			heads[msg.Message.ID] = len(merged)
+			merged = append(merged, msg)
+			continue
+		}
+
+		head := &merged[idx]
+		combined := *head.Message
+		items := append(contentItems(combined.Content), contentItems(msg.Message.Content)...)
+		if data, err := json.Marshal(items); err == nil {
+			combined.Content = data
+		}
+		combined.Usage = combineUsage(combined.Usage, msg.Message.Usage)
+		head.Message = &combined
+		head.Aliases = append(head.Aliases, msg.UUID)
+		for _, id := range toolUseIDs(msg.Message.Content) {
+			if head.ToolTimes == nil {
+				head.ToolTimes = make(map[string]string)
+			}
+			head.ToolTimes[id] = msg.Timestamp
+		}
+		fragments[msg.UUID] = head.UUID
+	}
+
+	return merged, fragments
+}
 
-	fmt.Printf("已生成: %s (共 %d 条消息)\n", outputFile, len(nodes))
+// toolUseIDs 返回 content 中所有 tool_use 块的 id
+func toolUseIDs(raw json.RawMessage) []string {
+	var ids []string
+	for _, item := range contentItems(raw) {
+		var block struct {
+			Type string `json:"type"`
+			ID   string `json:"id"`
+		}
+		if json.Unmarshal(item, &block) == nil && block.Type == "tool_use" && block.ID != "" {
+			ids = append(ids, block.ID)
+		}
+	}
+	return ids
+}
+
+// contentItems 将 content 统一为内容块数组，字符串内容转换为 text 块
+func contentItems(raw json.RawMessage) []json.RawMessage {
+	var items []json.RawMessage
+	if err := json.Unmarshal(raw, &items); err == nil {
+		return items
+	}
+
+	var text string
+	if err := json.Unmarshal(raw, &text); err == nil && text != "" {
+		if data, err := json.Marshal(map[string]string{"type": "text", "text": text}); err == nil {
+			return []json.RawMessage{data}
+		}
+	}
 	return nil
 }
 
-func extractContent(msg *MessageContent) (string, string, map[string]interface{}) {
+// combineUsage 合并同一回复各片段的用量
+// 片段中的 usage 是流式过程中的累计快照，因此逐项取最大值而不是相加
+func combineUsage(a, b *Usage) *Usage {
+	if a == nil {
+		return b
+	}
+	if b == nil {
+		return a
+	}
+	return &Usage{
+		InputTokens:              maxInt(a.InputTokens, b.InputTokens),
+		OutputTokens:             maxInt(a.OutputTokens, b.OutputTokens),
+		CacheCreationInputTokens: maxInt(a.CacheCreationInputTokens, b.CacheCreationInputTokens),
+		CacheReadInputTokens:     maxInt(a.CacheReadInputTokens, b.CacheReadInputTokens),
+	}
+}
+
+func maxInt(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+// buildSubagents 将子代理记录按线程分组，并关联到主链中启动它们的 Task 调用
+// 新版本记录带有 agentId，直接按其分组；旧版本沿 parentUuid 找到线程根消息分组
+func buildSubagents(records []ClaudeCodeMessage, mainNodes []OutputNode) []SubagentThread {
+	if len(records) == 0 {
+		return nil
+	}
+
+	parents := make(map[string]string)
+	for _, msg := range records {
+		parents[msg.UUID] = ""
+		if msg.ParentUUID != nil {
+			parents[msg.UUID] = *msg.ParentUUID
+		}
+	}
+	threadRoot := func(msg ClaudeCodeMessage) string {
+		if msg.AgentID != "" {
+			return msg.AgentID
+		}
+		current := msg.UUID
+		seen := make(map[string]bool)
+		for !seen[current] {
+			seen[current] = true
+			parent := parents[current]
+			if _, inThread := parents[parent]; !inThread {
+				break
+			}
+			current = parent
+		}
+		return current
+	}
+
+	var threadIDs []string
+	threadRecords := make(map[string][]ClaudeCodeMessage)
+	for _, msg := range records {
+		id := threadRoot(msg)
+		if _, exists := threadRecords[id]; !exists {
+			threadIDs = append(threadIDs, id)
+		}
+		threadRecords[id] = append(threadRecords[id], msg)
+	}
+
+	// 主链中的 Task 调用（按出现顺序）
+	var tasks []map[string]interface{}
+	var taskTimes []string
+	for _, node := range mainNodes {
+		for _, toolUse := range nodeToolUses(node) {
+			if toolUse["name"] == "Task" {
+				tasks = append(tasks, toolUse)
+				taskTimes = append(taskTimes, *node.CreateTime)
+			}
+		}
+	}
+	linked := make([]bool, len(tasks))
+
+	var threads []SubagentThread
+	for _, id := range threadIDs {
+		nodes := buildNodes(threadRecords[id])
+		if len(nodes) == 0 {
+			continue
+		}
+
+		thread := SubagentThread{
+			ID:         id,
+			RoundCount: countRounds(nodes),
+			TotalCount: len(nodes),
+			Data:       nodes,
+		}
+
+		if taskIdx := matchTask(tasks, taskTimes, linked, nodes[0]); taskIdx >= 0 {
+			linked[taskIdx] = true
+			task := tasks[taskIdx]
+			task["subagent_id"] = id
+			thread.ToolUseID, _ = task["id"].(string)
+			if input, ok := task["input"].(map[string]interface{}); ok {
+				thread.Description, _ = input["description"].(string)
+				thread.SubagentType, _ = input["subagent_type"].(string)
+			}
+		}
+		threads = append(threads, thread)
+	}
+	return threads
+}
+
+// matchTask 为子代理线程查找启动它的 Task 调用：
+// 优先匹配 prompt 与线程首条消息相同的调用，否则取线程开始前最近一次未关联的调用
+func matchTask(tasks []map[string]interface{}, taskTimes []string, linked []bool, first OutputNode) int {
+	prompt := strings.TrimSpace(first.Content)
+	for i, task := range tasks {
+		if linked[i] {
+			continue
+		}
+		if input, ok := task["input"].(map[string]interface{}); ok {
+			if taskPrompt, _ := input["prompt"].(string); strings.TrimSpace(taskPrompt) == prompt && prompt != "" {
+				return i
+			}
+		}
+	}
+
+	start, err := time.Parse(time.RFC3339Nano, *first.CreateTime)
+	if err != nil {
+		return -1
+	}
+	best := -1
+	for i := range tasks {
+		if linked[i] {
+			continue
+		}
+		taskTime, err := time.Parse(time.RFC3339Nano, taskTimes[i])
+		if err == nil && !taskTime.After(start) {
+			best = i
+		}
+	}
+	return best
+}
+
+// resolveParent 沿被跳过的消息向上查找，返回最近的保留祖先
+func resolveParent(parentID string, skippedParent map[string]string) string {
+	seen := make(map[string]bool)
+	for parentID != "" && !seen[parentID] {
+		next, skipped := skippedParent[parentID]
+		if !skipped {
+			break
+		}
+		seen[parentID] = true
+		parentID = next
+	}
+	return parentID
+}
+
+// linkChildren 根据parent_id补全child_id（取文件中出现的第一个子节点）
+func linkChildren(nodes []OutputNode) {
+	firstChild := make(map[string]string)
+	for _, node := range nodes {
+		if node.ParentID == "" {
+			continue
+		}
+		if _, exists := firstChild[node.ParentID]; !exists {
+			firstChild[node.ParentID] = node.ID
+		}
+	}
+	for i := range nodes {
+		nodes[i].ChildID = firstChild[nodes[i].ID]
+	}
+}
+
+// extractToolResults 提取消息中的 tool_result 内容块
+func extractToolResults(msg *MessageContent) []ToolResult {
+	if msg == nil {
+		return nil
+	}
+
+	var blocks []map[string]interface{}
+	if err := json.Unmarshal(msg.Content, &blocks); err != nil {
+		return nil
+	}
+
+	var results []ToolResult
+	for _, block := range blocks {
+		if blockType, _ := block["type"].(string); blockType != "tool_result" {
+			continue
+		}
+		results = append(results, toolResultFrom(block))
+	}
+	return results
+}
+
+// toolResultFrom 解析单个 tool_result 块
+func toolResultFrom(block map[string]interface{}) ToolResult {
+	result := ToolResult{}
+	result.ToolUseID, _ = block["tool_use_id"].(string)
+	result.IsError, _ = block["is_error"].(bool)
+	result.Output = toolResultText(block["content"])
+	return result
+}
+
+// onlyToolResults 判断消息内容是否只有 tool_result 块
+func onlyToolResults(contentType string, blocks []ContentBlock) bool {
+	if len(blocks) == 0 {
+		return contentType == "tool_result"
+	}
+	for _, block := range blocks {
+		if block.Type != "tool_result" {
+			return false
+		}
+	}
+	return true
+}
+
+// toolResultText 将 tool_result 的 content（字符串或内容块数组）转换为文本
+func toolResultText(content interface{}) string {
+	switch v := content.(type) {
+	case string:
+		return v
+	case []interface{}:
+		var textParts []string
+		for _, item := range v {
+			if itemMap, ok := item.(map[string]interface{}); ok {
+				if text, ok := itemMap["text"].(string); ok && text != "" {
+					textParts = append(textParts, text)
+				} else if itemType, _ := itemMap["type"].(string); itemType == "image" {
+					textParts = append(textParts, "[图片]")
+				}
+			}
+		}
+		return strings.Join(textParts, "\n")
+	}
+	return ""
+}
+
+// attachToolResult 将工具输出、错误状态和耗时写入 tool_use 节点的 tool_data
+func attachToolResult(toolData map[string]interface{}, result ToolResult, startTime, endTime string) {
+	toolData["output"] = result.Output
+	toolData["is_error"] = result.IsError
+
+	start, err1 := time.Parse(time.RFC3339Nano, startTime)
+	end, err2 := time.Parse(time.RFC3339Nano, endTime)
+	if err1 == nil && err2 == nil && !end.Before(start) {
+		toolData["duration_ms"] = end.Sub(start).Milliseconds()
+	}
+}
+
+// extractContent 提取消息内容，返回内容类型、合并后的文本、工具数据和有序内容块
+// 只有一个内容块时沿用扁平结构（content/tool_data），多个内容块时通过 blocks 保留全部块及其顺序
+// merged 中的 tool_result 已合并到对应的调用，不再作为内容块输出
+func extractContent(msg *MessageContent, merged map[string]bool) (string, string, map[string]interface{}, []ContentBlock) {
 	if msg == nil {
-		return "text", "", nil
+		return "text", "", nil, nil
 	}
 
 	// 尝试将content解析为字符串
 	var contentStr string
 	if err := json.Unmarshal(msg.Content, &contentStr); err == nil {
-		return "text", contentStr, nil
+		return "text", contentStr, nil, nil
 	}
 
 	// 尝试将content解析为数组
 	var contentArray []interface{}
 	if err := json.Unmarshal(msg.Content, &contentArray); err == nil {
-		var textParts []string
-		var contentType string = "text"
-		var toolData map[string]interface{}
-
+		var blocks []ContentBlock
 		for _, item := range contentArray {
 			if itemMap, ok := item.(map[string]interface{}); ok {
-				// 获取type字段
-				if typeVal, ok := itemMap["type"].(string); ok && typeVal != "" {
-					// 优先使用非text类型
-					if contentType == "text" || typeVal != "text" {
-						contentType = typeVal
-					}
-				}
-
-				// 提取tool_use的数据
-				if typeVal, ok := itemMap["type"].(string); ok && typeVal == "tool_use" {
-					if name, ok := itemMap["name"].(string); ok && name != "" {
-						toolData = make(map[string]interface{})
-						toolData["name"] = name
-
-						if input, ok := itemMap["input"].(map[string]interface{}); ok {
-							// 特殊处理TodoWrite
-							if name == "TodoWrite" {
-								if todosData, ok := input["todos"].([]interface{}); ok {
-									var todos []map[string]interface{}
-									for _, todoItem := range todosData {
-										if todoMap, ok := todoItem.(map[string]interface{}); ok {
-											status, _ := todoMap["status"].(string)
-											activeForm, _ := todoMap["activeForm"].(string)
-											if status != "" && activeForm != "" {
-												todos = append(todos, map[string]interface{}{
-													"status":     status,
-													"activeForm": activeForm,
-												})
-											}
-										}
-									}
-									if len(todos) > 0 {
-										toolData["todos"] = todos
-									}
-								}
-							} else {
-								// 其他tool直接保存input
-								toolData["input"] = input
-							}
-						}
-					}
-				}
-
-				// 提取text字段
-				if text, ok := itemMap["text"].(string); ok && text != "" {
-					textParts = append(textParts, text)
+				block, ok := extractBlock(itemMap)
+				if !ok {
+					continue
 				}
-				// 提取tool_result内容
-				if content, ok := itemMap["content"].(string); ok && content != "" {
-					textParts = append(textParts, content)
+				if id, _ := block.ToolData["tool_use_id"].(string); block.Type == "tool_result" && merged[id] {
+					continue
 				}
-			} else if str, ok := item.(string); ok {
-				textParts = append(textParts, str)
+				blocks = append(blocks, block)
+			} else if str, ok := item.(string); ok && str != "" {
+				blocks = append(blocks, ContentBlock{Type: "text", Text: str})
 			}
 		}
-		return contentType, strings.Join(textParts, "\n"), toolData
+		return summarizeBlocks(blocks)
 	}
 
 	// 尝试将content解析为对象
@@ -319,11 +1139,125 @@ func extractContent(msg *MessageContent) (string, string, map[string]interface{}
 		}
 
 		if text, ok := contentObj["text"].(string); ok {
-			return contentType, text, nil
+			return contentType, text, nil, nil
+		}
+	}
+
+	return "text", "", nil, nil
+}
+
+// extractBlock 将单个内容块转换为输出结构，空块返回 false
+func extractBlock(item map[string]interface{}) (ContentBlock, bool) {
+	typeVal, _ := item["type"].(string)
+	if typeVal == "" {
+		typeVal = "text"
+	}
+
+	switch typeVal {
+	case "tool_result":
+		result := toolResultFrom(item)
+		return ContentBlock{Type: typeVal, ToolData: map[string]interface{}{
+			"tool_use_id": result.ToolUseID,
+			"output":      result.Output,
+			"is_error":    result.IsError,
+		}}, true
+	case "tool_use":
+		toolData := extractToolUse(item)
+		if toolData == nil {
+			return ContentBlock{}, false
 		}
+		return ContentBlock{Type: typeVal, ToolData: toolData}, true
 	}
 
-	return "text", "", nil
+	text, _ := item["text"].(string)
+	if text == "" {
+		return ContentBlock{}, false
+	}
+	return ContentBlock{Type: typeVal, Text: text}, true
+}
+
+// extractToolUse 提取 tool_use 块的名称、id 和输入
+func extractToolUse(item map[string]interface{}) map[string]interface{} {
+	name, ok := item["name"].(string)
+	if !ok || name == "" {
+		return nil
+	}
+
+	toolData := make(map[string]interface{})
+	toolData["name"] = name
+	if id, ok := item["id"].(string); ok && id != "" {
+		toolData["id"] = id
+	}
+
+	input, ok := item["input"].(map[string]interface{})
+	if !ok {
+		return toolData
+	}
+
+	// 特殊处理TodoWrite
+	if name == "TodoWrite" {
+		if todosData, ok := input["todos"].([]interface{}); ok {
+			var todos []map[string]interface{}
+			for _, todoItem := range todosData {
+				if todoMap, ok := todoItem.(map[string]interface{}); ok {
+					status, _ := todoMap["status"].(string)
+					activeForm, _ := todoMap["activeForm"].(string)
+					if status != "" && activeForm != "" {
+						todos = append(todos, map[string]interface{}{
+							"status":     status,
+							"activeForm": activeForm,
+						})
+					}
+				}
+			}
+			if len(todos) > 0 {
+				toolData["todos"] = todos
+			}
+		}
+	} else {
+		// 其他tool直接保存input
+		toolData["input"] = input
+	}
+	return toolData
+}
+
+// summarizeBlocks 根据内容块计算节点的内容类型、文本和工具数据
+// 所有块类型相同时沿用该类型，否则为 multipart
+func summarizeBlocks(blocks []ContentBlock) (string, string, map[string]interface{}, []ContentBlock) {
+	if len(blocks) == 0 {
+		return "text", "", nil, nil
+	}
+
+	contentType := blocks[0].Type
+	var textParts []string
+	for _, block := range blocks {
+		if block.Type != contentType {
+			contentType = "multipart"
+		}
+		if block.Text != "" {
+			textParts = append(textParts, block.Text)
+		}
+	}
+	content := strings.Join(textParts, "\n")
+
+	if len(blocks) == 1 {
+		return contentType, content, blocks[0].ToolData, nil
+	}
+	return contentType, content, nil, blocks
+}
+
+// nodeToolUses 返回节点中所有 tool_use 的工具数据（按出现顺序）
+func nodeToolUses(node OutputNode) []map[string]interface{} {
+	var toolUses []map[string]interface{}
+	if node.ToolData != nil {
+		toolUses = append(toolUses, node.ToolData)
+	}
+	for _, block := range node.Blocks {
+		if block.ToolData != nil {
+			toolUses = append(toolUses, block.ToolData)
+		}
+	}
+	return toolUses
 }
 
 func sanitizeFilename(name string) string {
@@ -0,0 +1,252 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// 运行方式（scripts 目录下各工具都是独立的 main 包，需要指定文件）:
+//
+//	go test -v claude_code_conversation_parse.go claude_code_conversation_parse_test.go
+
+// writeSession 在目录中写入只有一条用户消息的会话文件
+func writeSession(t *testing.T, dir, name, sessionID string) string {
+	t.Helper()
+	path := filepath.Join(dir, name)
+	line := `{"type":"user","uuid":"u-` + name + `","parentUuid":null,"sessionId":"` + sessionID + `","timestamp":"2025-01-01T00:00:00Z","message":{"role":"user","content":"hi"}}` + "\n"
+	if err := os.WriteFile(path, []byte(line), 0644); err != nil {
+		t.Fatalf("写入会话文件失败: %v", err)
+	}
+	return path
+}
+
+func TestPlanOutputNames(t *testing.T) {
+	dir := t.TempDir()
+	a := writeSession(t, dir, "a.jsonl", "s1")
+	b := writeSession(t, dir, "b.jsonl", "s2")
+	c := writeSession(t, dir, "c.jsonl", "s1")
+	d := writeSession(t, dir, "d.jsonl", "s1")
+
+	names := planOutputNames([]string{a, b, c, d})
+	expected := map[string]string{a: "s1", b: "s2", c: "s1_2", d: "s1_3"}
+	for file, name := range expected {
+		if names[file] != name {
+			t.Errorf("%s 的输出文件名为 %q，期望 %q", filepath.Base(file), names[file], name)
+		}
+	}
+}
+
+// records 将 JSONL 行解析为会话记录
+func records(t *testing.T, lines ...string) []ClaudeCodeMessage {
+	t.Helper()
+	var messages []ClaudeCodeMessage
+	for _, line := range lines {
+		var msg ClaudeCodeMessage
+		if err := json.Unmarshal([]byte(line), &msg); err != nil {
+			t.Fatalf("解析记录失败: %v\n%s", err, line)
+		}
+		messages = append(messages, msg)
+	}
+	return messages
+}
+
+// nodeShape 节点的结构特征：ID、父节点、角色、内容类型和内容块数量
+type nodeShape struct {
+	id, parent, role, contentType string
+	blocks                        int
+}
+
+func TestBuildNodesToolPairing(t *testing.T) {
+	tests := []struct {
+		name     string
+		lines    []string
+		expected []nodeShape
+		check    func(t *testing.T, nodes []OutputNode)
+	}{
+		{
+			name: "结果合并到调用",
+			lines: []string{
+				`{"type":"assistant","uuid":"a1","parentUuid":null,"timestamp":"2025-01-01T00:00:00Z","message":{"role":"assistant","content":[{"type":"tool_use","id":"t1","name":"Bash","input":{"command":"ls"}}]}}`,
+				`{"type":"user","uuid":"u1","parentUuid":"a1","timestamp":"2025-01-01T00:00:01.5Z","message":{"role":"user","content":[{"type":"tool_result","tool_use_id":"t1","content":"ok"}]}}`,
+				`{"type":"assistant","uuid":"a2","parentUuid":"u1","timestamp":"2025-01-01T00:00:02Z","message":{"role":"assistant","content":"完成"}}`,
+			},
+			expected: []nodeShape{
+				{"a1", "", "assistant", "tool_use", 0},
+				{"a2", "a1", "assistant", "text", 0},
+			},
+			check: func(t *testing.T, nodes []OutputNode) {
+				toolData := nodes[0].ToolData
+				if toolData["output"] != "ok" || toolData["duration_ms"] != int64(1500) {
+					t.Errorf("工具结果合并错误: %v", toolData)
+				}
+			},
+		},
+		{
+			name: "多个找不到调用的结果各保留一个块",
+			lines: []string{
+				`{"type":"user","uuid":"u1","parentUuid":"gone","timestamp":"2025-01-01T00:00:00Z","message":{"role":"user","content":[{"type":"tool_result","tool_use_id":"x1","content":"one"},{"type":"tool_result","tool_use_id":"x2","content":"two","is_error":true}]}}`,
+				`{"type":"assistant","uuid":"a1","parentUuid":"u1","timestamp":"2025-01-01T00:00:01Z","message":{"role":"assistant","content":"好的"}}`,
+			},
+			expected: []nodeShape{
+				{"u1", "gone", "tool", "tool_result", 2},
+				{"a1", "u1", "assistant", "text", 0},
+			},
+			check: func(t *testing.T, nodes []OutputNode) {
+				blocks := nodes[0].Blocks
+				if blocks[0].ToolData["tool_use_id"] != "x1" || blocks[1].ToolData["output"] != "two" || blocks[1].ToolData["is_error"] != true {
+					t.Errorf("结果块错误: %+v", blocks)
+				}
+			},
+		},
+		{
+			name: "单个找不到调用的结果沿用扁平结构",
+			lines: []string{
+				`{"type":"user","uuid":"u1","parentUuid":null,"timestamp":"2025-01-01T00:00:00Z","message":{"role":"user","content":[{"type":"tool_result","tool_use_id":"x1","content":"one"}]}}`,
+			},
+			expected: []nodeShape{
+				{"u1", "", "tool", "tool_result", 0},
+			},
+			check: func(t *testing.T, nodes []OutputNode) {
+				if nodes[0].ToolData["tool_use_id"] != "x1" {
+					t.Errorf("tool_data 错误: %v", nodes[0].ToolData)
+				}
+			},
+		},
+		{
+			name: "带文本的消息保留文本和结果",
+			lines: []string{
+				`{"type":"user","uuid":"u1","parentUuid":null,"timestamp":"2025-01-01T00:00:00Z","message":{"role":"user","content":[{"type":"tool_result","tool_use_id":"x1","content":"one"},{"type":"text","text":"继续"}]}}`,
+			},
+			expected: []nodeShape{
+				{"u1", "", "user", "multipart", 2},
+			},
+			check: func(t *testing.T, nodes []OutputNode) {
+				blocks := nodes[0].Blocks
+				if nodes[0].Content != "继续" || blocks[0].Type != "tool_result" || blocks[1].Type != "text" {
+					t.Errorf("内容块应保持原始顺序（tool_result、text），实际 %q / %+v", nodes[0].Content, blocks)
+				}
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			nodes := buildNodes(records(t, tt.lines...))
+			if len(nodes) != len(tt.expected) {
+				t.Fatalf("节点数量为 %d，期望 %d: %+v", len(nodes), len(tt.expected), nodes)
+			}
+			for i, want := range tt.expected {
+				node := nodes[i]
+				if node.ID != want.id || node.ParentID != want.parent || node.Role != want.role || node.ContentType != want.contentType || len(node.Blocks) != want.blocks {
+					t.Errorf("节点 %d 为 %s/%s/%s/%s/%d 个块，期望 %+v", i, node.ID, node.ParentID, node.Role, node.ContentType, len(node.Blocks), want)
+				}
+			}
+			tt.check(t, nodes)
+		})
+	}
+}
+
+func TestReadAgentFiles(t *testing.T) {
+	dir := t.TempDir()
+	session := writeSession(t, dir, "main.jsonl", "s1")
+	writeSession(t, dir, "agent-1.jsonl", "s1")
+	writeSession(t, dir, "agent-2.jsonl", "s2")
+	writeSession(t, dir, "agent-3.jsonl", "s1")
+
+	agentRecords := readAgentFiles(session, "s1")
+	if len(agentRecords) != 2 || agentRecords[0].UUID != "u-agent-1.jsonl" || agentRecords[1].UUID != "u-agent-3.jsonl" {
+		t.Fatalf("子代理记录错误: %+v", agentRecords)
+	}
+	for _, record := range agentRecords {
+		if !record.IsSidechain {
+			t.Errorf("子代理记录 %s 未标记为 sidechain", record.UUID)
+		}
+	}
+	if files := agentFilesFor(dir, "s2"); len(files) != 1 || filepath.Base(files[0]) != "agent-2.jsonl" {
+		t.Errorf("会话 s2 的子代理文件为 %v", files)
+	}
+}
+
+func TestMergeFragments(t *testing.T) {
+	tests := []struct {
+		name      string
+		lines     []string
+		uuids     []string          // 合并后的记录 uuid
+		aliases   map[string]int    // 合并后记录 uuid -> 别名数量
+		fragments map[string]string // 被合并片段 -> 首个片段
+	}{
+		{
+			name: "同一 message.id 的片段合并到首个片段",
+			lines: []string{
+				`{"type":"assistant","uuid":"f1","timestamp":"2025-01-01T00:00:00Z","message":{"id":"m1","role":"assistant","content":[{"type":"text","text":"先看看"}]}}`,
+				`{"type":"assistant","uuid":"f2","parentUuid":"f1","timestamp":"2025-01-01T00:00:01Z","message":{"id":"m1","role":"assistant","content":[{"type":"tool_use","id":"t1","name":"Bash","input":{}}]}}`,
+				`{"type":"assistant","uuid":"f3","parentUuid":"f2","timestamp":"2025-01-01T00:00:01.2Z","message":{"id":"m1","role":"assistant","content":[{"type":"text","text":"然后"}]}}`,
+			},
+			uuids:     []string{"f1"},
+			aliases:   map[string]int{"f1": 2},
+			fragments: map[string]string{"f2": "f1", "f3": "f1"},
+		},
+		{
+			name: "不同 message.id 和无 id 的记录不合并",
+			lines: []string{
+				`{"type":"user","uuid":"u1","timestamp":"2025-01-01T00:00:00Z","message":{"role":"user","content":"问题"}}`,
+				`{"type":"assistant","uuid":"a1","parentUuid":"u1","timestamp":"2025-01-01T00:00:01Z","message":{"id":"m1","role":"assistant","content":"回答"}}`,
+				`{"type":"assistant","uuid":"a2","parentUuid":"a1","timestamp":"2025-01-01T00:00:02Z","message":{"id":"m2","role":"assistant","content":"补充"}}`,
+			},
+			uuids:     []string{"u1", "a1", "a2"},
+			aliases:   map[string]int{},
+			fragments: map[string]string{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			merged, fragments := mergeFragments(records(t, tt.lines...))
+			if len(merged) != len(tt.uuids) {
+				t.Fatalf("合并后记录数为 %d，期望 %d", len(merged), len(tt.uuids))
+			}
+			for i, msg := range merged {
+				if msg.UUID != tt.uuids[i] {
+					t.Errorf("第 %d 条记录为 %s，期望 %s", i, msg.UUID, tt.uuids[i])
+				}
+				if len(msg.Aliases) != tt.aliases[msg.UUID] {
+					t.Errorf("%s 的别名为 %v", msg.UUID, msg.Aliases)
+				}
+			}
+			if len(fragments) != len(tt.fragments) {
+				t.Errorf("片段映射为 %v，期望 %v", fragments, tt.fragments)
+			}
+			for uuid, head := range tt.fragments {
+				if fragments[uuid] != head {
+					t.Errorf("片段 %s 映射到 %s，期望 %s", uuid, fragments[uuid], head)
+				}
+			}
+		})
+	}
+}
+
+func TestMergedFragmentToolDuration(t *testing.T) {
+	nodes := buildNodes(records(t,
+		`{"type":"assistant","uuid":"f1","parentUuid":null,"timestamp":"2025-01-01T00:00:00Z","message":{"id":"m1","role":"assistant","content":[{"type":"text","text":"先看看"}]}}`,
+		`{"type":"assistant","uuid":"f2","parentUuid":"f1","timestamp":"2025-01-01T00:00:01Z","message":{"id":"m1","role":"assistant","content":[{"type":"tool_use","id":"t1","name":"Bash","input":{}}]}}`,
+		`{"type":"user","uuid":"u1","parentUuid":"f2","timestamp":"2025-01-01T00:00:02Z","message":{"role":"user","content":[{"type":"tool_result","tool_use_id":"t1","content":"ok"}]}}`,
+		`{"type":"assistant","uuid":"a2","parentUuid":"u1","timestamp":"2025-01-01T00:00:03Z","message":{"id":"m2","role":"assistant","content":"完成"}}`,
+	))
+
+	if len(nodes) != 2 {
+		t.Fatalf("节点数量为 %d，期望 2: %+v", len(nodes), nodes)
+	}
+	head := nodes[0]
+	if head.ID != "f1" || len(head.Aliases) != 1 || head.Aliases[0] != "f2" {
+		t.Errorf("合并节点为 %s，别名 %v", head.ID, head.Aliases)
+	}
+	toolUses := nodeToolUses(head)
+	if len(toolUses) != 1 || toolUses[0]["duration_ms"] != int64(1000) {
+		t.Errorf("工具耗时应按携带 tool_use 的片段计算: %v", toolUses)
+	}
+	if nodes[1].ParentID != "f1" {
+		t.Errorf("后续节点的父节点为 %s，期望 f1", nodes[1].ParentID)
+	}
+}
@@ -17,9 +17,17 @@ type ClaudeConversation struct {
 	Summary      string              `json:"summary"`
 	CreatedAt    string              `json:"created_at"`
 	UpdatedAt    string              `json:"updated_at"`
+	ProjectUUID  string              `json:"project_uuid"`
+	Project      *ClaudeProjectRef   `json:"project"`
 	ChatMessages []ClaudeChatMessage `json:"chat_messages"`
 }
 
+// 部分导出版本以嵌套对象的形式给出所属项目
+type ClaudeProjectRef struct {
+	UUID string `json:"uuid"`
+	Name string `json:"name"`
+}
+
 type ClaudeChatMessage struct {
 	UUID      string          `json:"uuid"`
 	Text      string          `json:"text"`
@@ -35,6 +43,24 @@ type ClaudeContent struct {
 	Text string `json:"text"`
 }
 
+// projects.json 中的项目结构
+type ClaudeProject struct {
+	UUID           string      `json:"uuid"`
+	Name           string      `json:"name"`
+	Description    string      `json:"description"`
+	PromptTemplate string      `json:"prompt_template"`
+	CreatedAt      string      `json:"created_at"`
+	UpdatedAt      string      `json:"updated_at"`
+	Docs           []ClaudeDoc `json:"docs"`
+}
+
+type ClaudeDoc struct {
+	UUID      string `json:"uuid"`
+	Filename  string `json:"filename"`
+	Content   string `json:"content"`
+	CreatedAt string `json:"created_at"`
+}
+
 // 输出节点结构（与GPT格式保持一致）
 type OutputNode struct {
 	ID          string   `json:"id"`
@@ -55,15 +81,37 @@ type OutputFile struct {
 	Data       []OutputNode `json:"data"`
 }
 
+// 项目输出结构，知识文档以片段(fragment)形式保存
+type OutputProject struct {
+	UUID           string           `json:"uuid"`
+	Name           string           `json:"name"`
+	Description    string           `json:"description,omitempty"`
+	PromptTemplate string           `json:"prompt_template,omitempty"`
+	CreatedAt      string           `json:"created_at"`
+	UpdatedAt      string           `json:"updated_at"`
+	Conversations  []string         `json:"conversations"`
+	Fragments      []OutputFragment `json:"fragments"`
+}
+
+type OutputFragment struct {
+	UUID         string `json:"uuid"`
+	FragmentType string `json:"fragment_type"`
+	Filename     string `json:"filename,omitempty"`
+	Content      string `json:"content"`
+	CreatedAt    string `json:"created_at"`
+}
+
 func main() {
 	// 解析命令行参数
 	inputFile := flag.String("input", "", "输入的JSON文件路径")
 	outputDir := flag.String("output", "parsed/claude/conversation", "输出目录")
+	projectsFile := flag.String("projects", "", "projects.json 文件路径（默认查找输入文件同目录下的 projects.json）")
+	projectOutputDir := flag.String("project-output", "", "项目输出目录（默认与对话输出目录同级的 project 目录）")
 	flag.Parse()
 
 	if *inputFile == "" {
 		fmt.Println("错误: 必须指定输入文件")
-		fmt.Println("用法: claude_conversation_parse -input <file> [-output <dir>]")
+		fmt.Println("用法: claude_conversation_parse -input <file> [-output <dir>] [-projects <file>] [-project-output <dir>]")
 		os.Exit(1)
 	}
 
@@ -87,17 +135,136 @@ func main() {
 		os.Exit(1)
 	}
 
+	// 读取项目文件（可选）
+	projectsPath := *projectsFile
+	if projectsPath == "" {
+		defaultPath := filepath.Join(filepath.Dir(*inputFile), "projects.json")
+		if _, err := os.Stat(defaultPath); err == nil {
+			projectsPath = defaultPath
+		}
+	}
+	var projects []ClaudeProject
+	if projectsPath != "" {
+		projects, err = readProjects(projectsPath)
+		if err != nil {
+			fmt.Printf("读取项目文件失败: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
 	// 处理每个对话
 	successCount := 0
+	projectConversations := make(map[string][]string)
 	for i, conv := range conversations {
 		if err := processConversation(conv, *outputDir); err != nil {
 			fmt.Printf("处理第 %d 个对话失败 (ID: %s): %v\n", i+1, conv.UUID, err)
 		} else {
 			successCount++
+			if projectID := conversationProjectID(conv); projectID != "" {
+				projectConversations[projectID] = append(projectConversations[projectID], sanitizeFilename(conv.UUID))
+			}
 		}
 	}
 
 	fmt.Printf("处理完成: 成功 %d/%d\n", successCount, len(conversations))
+
+	if len(projects) == 0 {
+		return
+	}
+
+	// 输出项目记录
+	projectDir := *projectOutputDir
+	if projectDir == "" {
+		projectDir = filepath.Join(filepath.Dir(filepath.Clean(*outputDir)), "project")
+	}
+	if err := os.MkdirAll(projectDir, 0755); err != nil {
+		fmt.Printf("创建项目输出目录失败: %v\n", err)
+		os.Exit(1)
+	}
+
+	projectSuccess := 0
+	for _, project := range projects {
+		if err := processProject(project, projectConversations[project.UUID], projectDir); err != nil {
+			fmt.Printf("处理项目失败 (ID: %s): %v\n", project.UUID, err)
+		} else {
+			projectSuccess++
+		}
+	}
+
+	fmt.Printf("项目处理完成: 成功 %d/%d\n", projectSuccess, len(projects))
+}
+
+func readProjects(filename string) ([]ClaudeProject, error) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	var projects []ClaudeProject
+	if err := json.Unmarshal(data, &projects); err != nil {
+		return nil, fmt.Errorf("解析JSON失败: %v", err)
+	}
+	return projects, nil
+}
+
+// conversationProjectID 返回对话所属项目的UUID，兼容扁平字段和嵌套对象两种导出格式
+func conversationProjectID(conv ClaudeConversation) string {
+	if conv.ProjectUUID != "" {
+		return conv.ProjectUUID
+	}
+	if conv.Project != nil {
+		return conv.Project.UUID
+	}
+	return ""
+}
+
+func processProject(project ClaudeProject, conversationIDs []string, outputDir string) error {
+	if project.UUID == "" {
+		return fmt.Errorf("项目缺少uuid")
+	}
+
+	// 知识文档转换为片段
+	fragments := []OutputFragment{}
+	for _, doc := range project.Docs {
+		if doc.Content == "" {
+			continue
+		}
+		fragments = append(fragments, OutputFragment{
+			UUID:         doc.UUID,
+			FragmentType: "text",
+			Filename:     doc.Filename,
+			Content:      doc.Content,
+			CreatedAt:    doc.CreatedAt,
+		})
+	}
+
+	if conversationIDs == nil {
+		conversationIDs = []string{}
+	}
+
+	output := OutputProject{
+		UUID:           project.UUID,
+		Name:           project.Name,
+		Description:    project.Description,
+		PromptTemplate: project.PromptTemplate,
+		CreatedAt:      project.CreatedAt,
+		UpdatedAt:      project.UpdatedAt,
+		Conversations:  conversationIDs,
+		Fragments:      fragments,
+	}
+
+	jsonData, err := json.MarshalIndent(output, "", "  ")
+	if err != nil {
+		return fmt.Errorf("序列化JSON失败: %v", err)
+	}
+
+	outputFile := filepath.Join(outputDir, sanitizeFilename(project.UUID)+".json")
+	if err := ioutil.WriteFile(outputFile, jsonData, 0644); err != nil {
+		return fmt.Errorf("写入文件失败: %v", err)
+	}
+
+	fmt.Printf("已生成项目: %s (共 %d 个对话, %d 个文档)\n", outputFile, len(conversationIDs), len(fragments))
+	return nil
 }
 
 func processConversation(conv ClaudeConversation, outputDir string) error {
@@ -160,6 +327,7 @@ func processConversation(conv ClaudeConversation, outputDir string) error {
 	output := OutputFile{
 		RoundCount: roundCount,
 		TotalCount: totalCount,
+		ProjectID:  conversationProjectID(conv),
 		Data:       nodes,
 	}
 
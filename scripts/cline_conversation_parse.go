@@ -0,0 +1,782 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/fs"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Cline / Roo Code 任务目录结构（VS Code globalStorage/<extension>/tasks/<taskId>/）：
+//   - api_conversation_history.json：发送给模型的消息（Anthropic 格式内容块）
+//   - ui_messages.json：界面消息，其中 api_req_started 记录每次请求的 token 和费用
+//   - task_metadata.json：上下文文件和使用的模型
+const (
+	apiHistoryFile   = "api_conversation_history.json"
+	uiMessagesFile   = "ui_messages.json"
+	taskMetadataFile = "task_metadata.json"
+)
+
+type ClineMessage struct {
+	Role    string          `json:"role"`
+	Content json.RawMessage `json:"content"`
+	Ts      int64           `json:"ts"` // 新版本带有时间戳（毫秒）
+}
+
+type ClineUIMessage struct {
+	Ts   int64  `json:"ts"`
+	Type string `json:"type"` // say / ask
+	Say  string `json:"say"`
+	Text string `json:"text"`
+}
+
+// api_req_started 的 text 字段内容
+type ClineAPIRequest struct {
+	TokensIn    int     `json:"tokensIn"`
+	TokensOut   int     `json:"tokensOut"`
+	CacheWrites int     `json:"cacheWrites"`
+	CacheReads  int     `json:"cacheReads"`
+	Cost        float64 `json:"cost"`
+}
+
+type ClineTaskMetadata struct {
+	FilesInContext []struct {
+		Path        string `json:"path"`
+		RecordState string `json:"record_state"`
+	} `json:"files_in_context"`
+	ModelUsage []struct {
+		ModelID         string `json:"model_id"`
+		ModelProviderID string `json:"model_provider_id"`
+		Mode            string `json:"mode"`
+	} `json:"model_usage"`
+}
+
+// 旧版本 Cline 以 XML 标签在 assistant 文本中发起工具调用，例如：
+//
+//	<read_file>
+//	<path>src/main.go</path>
+//	</read_file>
+//
+// 工具结果以用户消息回传，文本以 "[read_file for 'src/main.go'] Result:" 开头
+var xmlToolNames = map[string]bool{
+	// Cline
+	"execute_command": true, "read_file": true, "write_to_file": true, "replace_in_file": true,
+	"search_files": true, "list_files": true, "list_code_definition_names": true, "browser_action": true,
+	"use_mcp_tool": true, "access_mcp_resource": true, "load_mcp_documentation": true, "web_fetch": true,
+	"ask_followup_question": true, "attempt_completion": true, "plan_mode_respond": true, "new_task": true, "condense": true,
+	// Roo Code
+	"apply_diff": true, "insert_content": true, "search_and_replace": true, "switch_mode": true,
+	"fetch_instructions": true, "codebase_search": true, "update_todo_list": true,
+}
+
+// 等待用户输入的工具，结果中是用户的回答或反馈，合并到调用后仍作为用户消息保留
+var userInputTools = map[string]bool{
+	"ask_followup_question": true,
+	"attempt_completion":    true,
+	"plan_mode_respond":     true,
+}
+
+// 保留原始换行的参数（文件内容、diff），其余参数去掉首尾空白
+var rawXMLParams = map[string]bool{"content": true, "diff": true}
+
+const toolErrorPrefix = "The tool execution failed"
+
+var (
+	xmlTagRe           = regexp.MustCompile(`<([a-z_]+)>`)
+	toolResultHeaderRe = regexp.MustCompile(`(?s)^\[([a-z_]+)(?: for [^\n]*?)?\] Result:(.*)$`)
+)
+
+// 任务中一次 API 请求的时间和用量
+type ClineRequest struct {
+	Ts      int64
+	Request ClineAPIRequest
+}
+
+// 输出节点结构（与GPT格式保持一致）
+type OutputNode struct {
+	ID          string                 `json:"id"`
+	ParentID    string                 `json:"parent_id"`
+	ChildID     string                 `json:"child_id"`
+	Role        string                 `json:"role"`
+	ContentType string                 `json:"content_type"`
+	Content     string                 `json:"content,omitempty"`
+	ToolData    map[string]interface{} `json:"tool_data,omitempty"`
+	Blocks      []ContentBlock         `json:"blocks,omitempty"` // 多个内容块时按原始顺序保留
+	Metadata    map[string]interface{} `json:"metadata,omitempty"`
+	CreateTime  *string                `json:"create_time"`
+}
+
+// 内容块结构（text、tool_use 等）
+type ContentBlock struct {
+	Type     string                 `json:"type"`
+	Text     string                 `json:"text,omitempty"`
+	ToolData map[string]interface{} `json:"tool_data,omitempty"`
+}
+
+// 输出文件结构
+type OutputFile struct {
+	RoundCount int                    `json:"round_count"` // 对话轮数（user消息数量）
+	TotalCount int                    `json:"total_count"` // 总消息数量
+	Metadata   map[string]interface{} `json:"metadata,omitempty"`
+	Data       []OutputNode           `json:"data"`
+}
+
+func main() {
+	// 解析命令行参数
+	taskDir := flag.String("task", "", "任务目录（包含 "+apiHistoryFile+"）")
+	inputDir := flag.String("dir", "", "tasks 目录，递归解析其中所有任务")
+	outputDir := flag.String("output", "parsed/cline/conversation", "输出目录")
+	flag.Parse()
+
+	if *taskDir == "" && *inputDir == "" {
+		fmt.Println("错误: 必须指定任务目录或 tasks 目录")
+		fmt.Println("用法: cline_conversation_parse -task <task_dir> [-output <dir>]")
+		fmt.Println("      cline_conversation_parse -dir <tasks_dir> [-output <dir>]")
+		os.Exit(1)
+	}
+	if *taskDir != "" && *inputDir != "" {
+		fmt.Println("错误: -task 和 -dir 不能同时指定")
+		os.Exit(1)
+	}
+
+	// 创建输出目录
+	if err := os.MkdirAll(*outputDir, 0755); err != nil {
+		fmt.Printf("创建输出目录失败: %v\n", err)
+		os.Exit(1)
+	}
+
+	tasks := []string{*taskDir}
+	if *inputDir != "" {
+		var err error
+		tasks, err = collectTaskDirs(*inputDir)
+		if err != nil {
+			fmt.Printf("遍历目录失败: %v\n", err)
+			os.Exit(1)
+		}
+		if len(tasks) == 0 {
+			fmt.Printf("警告: 目录中没有包含 %s 的任务\n", apiHistoryFile)
+			os.Exit(0)
+		}
+		fmt.Printf("共找到 %d 个任务\n", len(tasks))
+	}
+
+	success, failed := 0, 0
+	for _, task := range tasks {
+		outputFile, count, err := processTask(task, *outputDir)
+		if err != nil {
+			failed++
+			fmt.Printf("[失败] %s: %v\n", task, err)
+			continue
+		}
+		success++
+		fmt.Printf("[成功] %s -> %s (共 %d 条消息)\n", task, outputFile, count)
+	}
+
+	fmt.Printf("\n处理完成: 成功 %d, 失败 %d, 共 %d\n", success, failed, len(tasks))
+	if failed > 0 {
+		os.Exit(1)
+	}
+}
+
+// collectTaskDirs 递归查找包含 api_conversation_history.json 的任务目录（按路径排序）
+func collectTaskDirs(dir string) ([]string, error) {
+	var tasks []string
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.IsDir() && d.Name() == apiHistoryFile {
+			tasks = append(tasks, filepath.Dir(path))
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Strings(tasks)
+	return tasks, nil
+}
+
+// readJSONFile 读取 JSON 文件，文件不存在时返回 false
+func readJSONFile(path string, v interface{}) (bool, error) {
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		return false, fmt.Errorf("解析 %s 失败: %v", filepath.Base(path), err)
+	}
+	return true, nil
+}
+
+// extensionName 根据 globalStorage 下的扩展目录名判断来源扩展
+func extensionName(taskDir string) string {
+	absPath, err := filepath.Abs(taskDir)
+	if err != nil {
+		absPath = taskDir
+	}
+	lower := strings.ToLower(absPath)
+	switch {
+	case strings.Contains(lower, "roo-cline") || strings.Contains(lower, "roo-code"):
+		return "roo"
+	case strings.Contains(lower, "claude-dev"):
+		return "cline"
+	}
+	return ""
+}
+
+func processTask(taskDir string, outputDir string) (string, int, error) {
+	var messages []ClineMessage
+	if ok, err := readJSONFile(filepath.Join(taskDir, apiHistoryFile), &messages); err != nil {
+		return "", 0, err
+	} else if !ok {
+		return "", 0, fmt.Errorf("缺少 %s", apiHistoryFile)
+	}
+
+	// ui_messages 和 task_metadata 可能不存在（旧版本），缺失时忽略
+	var uiMessages []ClineUIMessage
+	if _, err := readJSONFile(filepath.Join(taskDir, uiMessagesFile), &uiMessages); err != nil {
+		return "", 0, err
+	}
+	var taskMetadata ClineTaskMetadata
+	hasTaskMetadata, err := readJSONFile(filepath.Join(taskDir, taskMetadataFile), &taskMetadata)
+	if err != nil {
+		return "", 0, err
+	}
+
+	taskID := filepath.Base(taskDir)
+	requests := extractRequests(uiMessages)
+
+	nodes := []OutputNode{}
+	toolIndex := make(map[string]map[string]interface{}) // tool_use id -> 调用的 tool_data
+	var pendingXML []map[string]interface{}              // 上一条 assistant 消息中以 XML 发起的工具调用
+	parentID := ""
+	requestIdx := 0
+
+	for i, msg := range messages {
+		items := extractBlocks(msg.Content)
+		switch msg.Role {
+		case "assistant":
+			items, pendingXML = splitXMLToolCalls(items)
+		case "user":
+			items = extractXMLToolResults(items)
+		}
+
+		// tool_result 合并到对应的调用：原生调用按 tool_use_id，XML 调用按工具名
+		// 找不到对应调用的结果在原位置保留为 tool_result 块
+		var kept []ContentBlock
+		merged := false
+		for _, item := range items {
+			if item.Type == "tool_result" {
+				var call map[string]interface{}
+				if toolUseID, _ := item.ToolData["tool_use_id"].(string); toolUseID != "" {
+					call = toolIndex[toolUseID]
+				} else {
+					call = pendingXMLCall(pendingXML, item.ToolData["name"])
+				}
+				if call != nil {
+					call["output"] = item.ToolData["output"]
+					call["is_error"] = item.ToolData["is_error"]
+					merged = true
+					continue
+				}
+			}
+			kept = append(kept, item)
+		}
+		// 工具结果消息中附带的环境信息不作为用户输入
+		if merged && onlyEnvironmentDetails(kept) {
+			kept = nil
+		}
+
+		contentType, content, toolData, blocks := summarizeBlocks(kept)
+		role := msg.Role
+		if len(kept) > 0 && onlyToolResults(kept) {
+			role = "tool"
+		}
+
+		// 每条 assistant 消息对应一次 API 请求，按时间戳关联 api_req_started 的用量
+		var metadata map[string]interface{}
+		ts := msg.Ts
+		idx := -1
+		if role == "assistant" {
+			idx = matchRequest(requests, requestIdx, msg.Ts)
+		}
+		if idx >= 0 {
+			request := requests[idx]
+			requestIdx = idx + 1
+			metadata = map[string]interface{}{
+				"tokens_in":    request.Request.TokensIn,
+				"tokens_out":   request.Request.TokensOut,
+				"cache_writes": request.Request.CacheWrites,
+				"cache_reads":  request.Request.CacheReads,
+				"cost":         request.Request.Cost,
+			}
+			if ts == 0 {
+				ts = request.Ts
+			}
+		}
+		// 首条用户消息即任务描述，时间取 ui_messages 的第一条
+		if i == 0 && ts == 0 && len(uiMessages) > 0 {
+			ts = uiMessages[0].Ts
+		}
+
+		// 只携带已合并的 tool_result 的消息不单独输出
+		if content == "" && toolData == nil && blocks == nil {
+			continue
+		}
+
+		node := OutputNode{
+			ID:          fmt.Sprintf("%s-%d", taskID, i),
+			ParentID:    parentID,
+			Role:        role,
+			ContentType: contentType,
+			Content:     content,
+			ToolData:    toolData,
+			Blocks:      blocks,
+			Metadata:    metadata,
+		}
+		if ts > 0 {
+			createTime := time.UnixMilli(ts).UTC().Format(time.RFC3339Nano)
+			node.CreateTime = &createTime
+		}
+
+		nodes = append(nodes, node)
+		parentID = node.ID
+		for _, toolUse := range nodeToolUses(node) {
+			if id, ok := toolUse["id"].(string); ok && id != "" {
+				toolIndex[id] = toolUse
+			}
+		}
+	}
+
+	if len(nodes) == 0 {
+		return "", 0, fmt.Errorf("没有有效的内容节点")
+	}
+	linkChildren(nodes)
+
+	// 任务级元数据：来源扩展、费用和 token 合计、使用的模型、上下文文件
+	metadata := map[string]interface{}{"task_id": taskID}
+	if extension := extensionName(taskDir); extension != "" {
+		metadata["extension"] = extension
+	}
+	if len(requests) > 0 {
+		total := ClineAPIRequest{}
+		for _, request := range requests {
+			total.TokensIn += request.Request.TokensIn
+			total.TokensOut += request.Request.TokensOut
+			total.CacheWrites += request.Request.CacheWrites
+			total.CacheReads += request.Request.CacheReads
+			total.Cost += request.Request.Cost
+		}
+		metadata["api_requests"] = len(requests)
+		metadata["tokens_in"] = total.TokensIn
+		metadata["tokens_out"] = total.TokensOut
+		metadata["cache_writes"] = total.CacheWrites
+		metadata["cache_reads"] = total.CacheReads
+		metadata["total_cost"] = total.Cost
+	}
+	if hasTaskMetadata {
+		var models, files []string
+		for _, usage := range taskMetadata.ModelUsage {
+			if usage.ModelID != "" && !containsString(models, usage.ModelID) {
+				models = append(models, usage.ModelID)
+			}
+		}
+		for _, file := range taskMetadata.FilesInContext {
+			if file.Path != "" && !containsString(files, file.Path) {
+				files = append(files, file.Path)
+			}
+		}
+		if len(models) > 0 {
+			metadata["models"] = models
+		}
+		if len(files) > 0 {
+			metadata["files_in_context"] = files
+		}
+	}
+
+	// 计算统计信息
+	roundCount := 0
+	for _, node := range nodes {
+		if node.Role == "user" {
+			roundCount++
+		}
+	}
+
+	// 构建输出文件结构
+	output := OutputFile{
+		RoundCount: roundCount,
+		TotalCount: len(nodes),
+		Metadata:   metadata,
+		Data:       nodes,
+	}
+
+	// 序列化为JSON
+	jsonData, err := json.MarshalIndent(output, "", "  ")
+	if err != nil {
+		return "", 0, fmt.Errorf("序列化JSON失败: %v", err)
+	}
+
+	// 写入文件
+	outputFile := filepath.Join(outputDir, sanitizeFilename(taskID)+".json")
+	if err := ioutil.WriteFile(outputFile, jsonData, 0644); err != nil {
+		return "", 0, fmt.Errorf("写入文件失败: %v", err)
+	}
+
+	return outputFile, len(nodes), nil
+}
+
+// matchRequest 返回 assistant 消息对应的 api_req_started 下标（从 from 开始查找，没有时返回 -1）
+// 消息有时间戳时取消息之前最近的一次请求，失败后重试的请求没有对应的消息，会被跳过；
+// 旧版本消息没有时间戳，只能按顺序取下一次请求
+func matchRequest(requests []ClineRequest, from int, ts int64) int {
+	if ts == 0 {
+		if from < len(requests) {
+			return from
+		}
+		return -1
+	}
+
+	match := -1
+	for i := from; i < len(requests) && requests[i].Ts <= ts; i++ {
+		match = i
+	}
+	return match
+}
+
+// extractRequests 提取 ui_messages 中按顺序排列的 api_req_started 记录
+func extractRequests(uiMessages []ClineUIMessage) []ClineRequest {
+	var requests []ClineRequest
+	for _, msg := range uiMessages {
+		if msg.Type != "say" || msg.Say != "api_req_started" {
+			continue
+		}
+		request := ClineRequest{Ts: msg.Ts}
+		json.Unmarshal([]byte(msg.Text), &request.Request)
+		requests = append(requests, request)
+	}
+	return requests
+}
+
+// toolResultText 提取 tool_result 的文本内容（字符串或内容块数组）
+func toolResultText(content interface{}) string {
+	switch v := content.(type) {
+	case string:
+		return v
+	case []interface{}:
+		var textParts []string
+		for _, item := range v {
+			if itemMap, ok := item.(map[string]interface{}); ok {
+				if text, ok := itemMap["text"].(string); ok && text != "" {
+					textParts = append(textParts, text)
+				} else if itemType, _ := itemMap["type"].(string); itemType == "image" {
+					textParts = append(textParts, "[图片]")
+				}
+			}
+		}
+		return strings.Join(textParts, "\n")
+	}
+	return ""
+}
+
+// extractBlocks 按原始顺序提取消息的内容块
+func extractBlocks(raw json.RawMessage) []ContentBlock {
+	// 尝试将content解析为字符串
+	var contentStr string
+	if err := json.Unmarshal(raw, &contentStr); err == nil {
+		if contentStr == "" {
+			return nil
+		}
+		return []ContentBlock{{Type: "text", Text: contentStr}}
+	}
+
+	// 尝试将content解析为数组
+	var contentArray []map[string]interface{}
+	if err := json.Unmarshal(raw, &contentArray); err != nil {
+		return nil
+	}
+
+	var blocks []ContentBlock
+	for _, item := range contentArray {
+		typeVal, _ := item["type"].(string)
+		switch typeVal {
+		case "tool_result":
+			isError, _ := item["is_error"].(bool)
+			blocks = append(blocks, ContentBlock{Type: typeVal, ToolData: map[string]interface{}{
+				"tool_use_id": item["tool_use_id"],
+				"output":      toolResultText(item["content"]),
+				"is_error":    isError,
+			}})
+		case "tool_use":
+			name, _ := item["name"].(string)
+			if name == "" {
+				continue
+			}
+			toolData := map[string]interface{}{"name": name, "input": item["input"]}
+			if id, ok := item["id"].(string); ok && id != "" {
+				toolData["id"] = id
+			}
+			blocks = append(blocks, ContentBlock{Type: typeVal, ToolData: toolData})
+		case "image":
+			blocks = append(blocks, ContentBlock{Type: "image", Text: "[图片]"})
+		default:
+			if text, ok := item["text"].(string); ok && text != "" {
+				if typeVal == "" {
+					typeVal = "text"
+				}
+				blocks = append(blocks, ContentBlock{Type: typeVal, Text: text})
+			}
+		}
+	}
+	return blocks
+}
+
+// splitXMLToolCalls 将 assistant 文本中的 XML 工具调用拆分为 tool_use 块，同时返回这些调用的 tool_data
+func splitXMLToolCalls(blocks []ContentBlock) ([]ContentBlock, []map[string]interface{}) {
+	var result []ContentBlock
+	var calls []map[string]interface{}
+	for _, block := range blocks {
+		if block.Type != "text" {
+			result = append(result, block)
+			continue
+		}
+
+		text, found := block.Text, false
+		for {
+			start, end, name, body := nextXMLToolCall(text)
+			if start < 0 {
+				break
+			}
+			found = true
+			if before := strings.TrimSpace(text[:start]); before != "" {
+				result = append(result, ContentBlock{Type: "text", Text: before})
+			}
+			toolData := map[string]interface{}{"name": name, "input": parseXMLParams(body)}
+			result = append(result, ContentBlock{Type: "tool_use", ToolData: toolData})
+			calls = append(calls, toolData)
+			text = text[end:]
+		}
+		if rest := strings.TrimSpace(text); rest != "" {
+			if !found {
+				rest = block.Text // 没有工具调用时保持原文
+			}
+			result = append(result, ContentBlock{Type: "text", Text: rest})
+		}
+	}
+	return result, calls
+}
+
+// nextXMLToolCall 查找文本中第一个完整的 XML 工具调用，返回起止位置、工具名和标签内容，没有时 start 为 -1
+func nextXMLToolCall(text string) (int, int, string, string) {
+	for _, match := range xmlTagRe.FindAllStringSubmatchIndex(text, -1) {
+		name := text[match[2]:match[3]]
+		if !xmlToolNames[name] {
+			continue
+		}
+		closing := "</" + name + ">"
+		if end := strings.Index(text[match[1]:], closing); end >= 0 {
+			bodyEnd := match[1] + end
+			return match[0], bodyEnd + len(closing), name, text[match[1]:bodyEnd]
+		}
+	}
+	return -1, -1, "", ""
+}
+
+// parseXMLParams 解析工具调用标签内的参数标签
+func parseXMLParams(body string) map[string]interface{} {
+	params := make(map[string]interface{})
+	for {
+		match := xmlTagRe.FindStringSubmatchIndex(body)
+		if match == nil {
+			break
+		}
+		name := body[match[2]:match[3]]
+		closing := "</" + name + ">"
+		rest := body[match[1]:]
+
+		// 文件内容和 diff 中可能包含同名标签，取最后一个结束标签
+		end := strings.Index(rest, closing)
+		if rawXMLParams[name] {
+			end = strings.LastIndex(rest, closing)
+		}
+		if end < 0 {
+			body = rest
+			continue
+		}
+
+		value := rest[:end]
+		if rawXMLParams[name] {
+			value = strings.Trim(value, "\n")
+		} else {
+			value = strings.TrimSpace(value)
+		}
+		params[name] = value
+		body = rest[end+len(closing):]
+	}
+	return params
+}
+
+// extractXMLToolResults 将用户消息中 "[工具名 ...] Result:" 开头的工具结果在原位置替换为 tool_result 块
+// 结果之后、下一个结果或 <environment_details> 之前的文本块都属于该结果
+func extractXMLToolResults(blocks []ContentBlock) []ContentBlock {
+	var kept []ContentBlock
+	var current map[string]interface{}
+	var output []string
+
+	flush := func() {
+		if current == nil {
+			return
+		}
+		text := strings.TrimSpace(strings.Join(output, "\n"))
+		current["output"] = text
+		current["is_error"] = strings.HasPrefix(text, toolErrorPrefix)
+		kept = append(kept, ContentBlock{Type: "tool_result", ToolData: current})
+		if name, _ := current["name"].(string); userInputTools[name] && text != "" {
+			kept = append(kept, ContentBlock{Type: "text", Text: text})
+		}
+		current, output = nil, nil
+	}
+
+	for _, block := range blocks {
+		if block.Type == "text" {
+			if match := toolResultHeaderRe.FindStringSubmatch(block.Text); match != nil {
+				flush()
+				current = map[string]interface{}{"name": match[1]}
+				output = append(output, match[2])
+				continue
+			}
+			if isEnvironmentDetails(block.Text) {
+				flush()
+			} else if current != nil {
+				output = append(output, block.Text)
+				continue
+			}
+		}
+		kept = append(kept, block)
+	}
+	flush()
+	return kept
+}
+
+func isEnvironmentDetails(text string) bool {
+	return strings.HasPrefix(strings.TrimSpace(text), "<environment_details>")
+}
+
+// onlyEnvironmentDetails 判断内容块是否只有环境信息
+func onlyEnvironmentDetails(blocks []ContentBlock) bool {
+	for _, block := range blocks {
+		if block.Type != "text" || !isEnvironmentDetails(block.Text) {
+			return false
+		}
+	}
+	return true
+}
+
+// onlyToolResults 判断内容块是否只有 tool_result
+func onlyToolResults(blocks []ContentBlock) bool {
+	for _, block := range blocks {
+		if block.Type != "tool_result" {
+			return false
+		}
+	}
+	return true
+}
+
+// pendingXMLCall 按工具名查找尚未得到结果的 XML 工具调用
+func pendingXMLCall(calls []map[string]interface{}, name interface{}) map[string]interface{} {
+	for _, call := range calls {
+		if call["name"] == name && call["output"] == nil {
+			return call
+		}
+	}
+	return nil
+}
+
+// summarizeBlocks 根据内容块生成节点的 content_type、content 和 tool_data
+// 单个内容块时保持原有的扁平结构；多个内容块时额外保留 blocks，类型不一致时 content_type 为 multipart
+func summarizeBlocks(blocks []ContentBlock) (string, string, map[string]interface{}, []ContentBlock) {
+	if len(blocks) == 0 {
+		return "text", "", nil, nil
+	}
+
+	contentType := blocks[0].Type
+	var textParts []string
+	for _, block := range blocks {
+		if block.Type != contentType {
+			contentType = "multipart"
+		}
+		if block.Text != "" {
+			textParts = append(textParts, block.Text)
+		}
+	}
+	content := strings.Join(textParts, "\n")
+
+	if len(blocks) == 1 {
+		return contentType, content, blocks[0].ToolData, nil
+	}
+	return contentType, content, nil, blocks
+}
+
+// nodeToolUses 返回节点中所有 tool_use 的 tool_data
+func nodeToolUses(node OutputNode) []map[string]interface{} {
+	var toolUses []map[string]interface{}
+	if node.ToolData != nil {
+		toolUses = append(toolUses, node.ToolData)
+	}
+	for _, block := range node.Blocks {
+		if block.ToolData != nil {
+			toolUses = append(toolUses, block.ToolData)
+		}
+	}
+	return toolUses
+}
+
+func containsString(list []string, value string) bool {
+	for _, item := range list {
+		if item == value {
+			return true
+		}
+	}
+	return false
+}
+
+// linkChildren 根据 parent 关系补全 child_id
+func linkChildren(nodes []OutputNode) {
+	firstChild := make(map[string]string)
+	for _, node := range nodes {
+		if node.ParentID == "" {
+			continue
+		}
+		if _, exists := firstChild[node.ParentID]; !exists {
+			firstChild[node.ParentID] = node.ID
+		}
+	}
+	for i := range nodes {
+		nodes[i].ChildID = firstChild[nodes[i].ID]
+	}
+}
+
+func sanitizeFilename(name string) string {
+	// 替换文件名中的非法字符
+	name = strings.ReplaceAll(name, "/", "_")
+	name = strings.ReplaceAll(name, "\\", "_")
+	name = strings.ReplaceAll(name, ":", "_")
+	name = strings.ReplaceAll(name, "*", "_")
+	name = strings.ReplaceAll(name, "?", "_")
+	name = strings.ReplaceAll(name, "\"", "_")
+	name = strings.ReplaceAll(name, "<", "_")
+	name = strings.ReplaceAll(name, ">", "_")
+	name = strings.ReplaceAll(name, "|", "_")
+	return name
+}
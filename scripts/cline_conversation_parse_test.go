@@ -0,0 +1,167 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// 运行方式（scripts 目录下各工具都是独立的 main 包，需要指定文件）:
+//
+//	go test -v cline_conversation_parse.go cline_conversation_parse_test.go
+
+// writeTask 写入任务目录中的 JSON 文件
+func writeTask(t *testing.T, dir string, files map[string]string) {
+	t.Helper()
+	for name, body := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(body), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// runTask 解析任务目录并读取输出文件
+func runTask(t *testing.T, taskDir string) OutputFile {
+	t.Helper()
+	outputFile, _, err := processTask(taskDir, t.TempDir())
+	if err != nil {
+		t.Fatalf("processTask 失败: %v", err)
+	}
+	data, err := os.ReadFile(outputFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var output OutputFile
+	if err := json.Unmarshal(data, &output); err != nil {
+		t.Fatal(err)
+	}
+	return output
+}
+
+// nodeShape 节点的角色、类型和内容块数量
+type nodeShape struct {
+	role, contentType string
+	blocks            int
+}
+
+func TestXMLToolCalls(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "task-1")
+	if err := os.Mkdir(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	writeTask(t, dir, map[string]string{apiHistoryFile: `[
+		{"role":"user","content":[{"type":"text","text":"<task>\nfix main\n</task>"},{"type":"text","text":"<environment_details>\ncwd\n</environment_details>"}]},
+		{"role":"assistant","content":[{"type":"text","text":"先看看文件\n\n<read_file>\n<path>main.go</path>\n</read_file>"}]},
+		{"role":"user","content":[{"type":"text","text":"[read_file for 'main.go'] Result:"},{"type":"text","text":"package main"},{"type":"text","text":"<environment_details>\ncwd\n</environment_details>"}]},
+		{"role":"assistant","content":"<ask_followup_question>\n<question>改哪里？</question>\n</ask_followup_question>"},
+		{"role":"user","content":[{"type":"text","text":"[ask_followup_question for '改哪里？'] Result:\n<answer>\n改 main\n</answer>"}]}
+	]`})
+
+	output := runTask(t, dir)
+
+	shapes := []nodeShape{
+		{role: "user", contentType: "text", blocks: 2},
+		{role: "assistant", contentType: "multipart", blocks: 2},
+		{role: "assistant", contentType: "tool_use"},
+		{role: "user", contentType: "text"},
+	}
+	if len(output.Data) != len(shapes) {
+		t.Fatalf("节点数应为 %d，实际 %d", len(shapes), len(output.Data))
+	}
+	for i, want := range shapes {
+		node := output.Data[i]
+		if node.Role != want.role || node.ContentType != want.contentType || len(node.Blocks) != want.blocks {
+			t.Errorf("节点 %d: 期望 %s/%s/%d 个块，实际 %s/%s/%d 个块", i, want.role, want.contentType, want.blocks, node.Role, node.ContentType, len(node.Blocks))
+		}
+	}
+
+	// 读文件的结果合并到调用中，结果消息不计入对话轮数
+	readFile := output.Data[1].Blocks[1].ToolData
+	input, _ := readFile["input"].(map[string]interface{})
+	if readFile["name"] != "read_file" || input["path"] != "main.go" || readFile["output"] != "package main" || readFile["is_error"] != false {
+		t.Errorf("read_file 调用解析错误: %v", readFile)
+	}
+	if output.Data[1].Blocks[0].Text != "先看看文件" {
+		t.Errorf("工具调用前的文本应保留，实际 %q", output.Data[1].Blocks[0].Text)
+	}
+	if output.RoundCount != 2 {
+		t.Errorf("对话轮数应为 2（任务描述和用户回答），实际 %d", output.RoundCount)
+	}
+
+	// 等待用户输入的工具：回答既是调用结果，也作为用户消息保留
+	question := output.Data[2].ToolData
+	answer := "<answer>\n改 main\n</answer>"
+	if question["output"] != answer || output.Data[3].Content != answer {
+		t.Errorf("用户回答应合并到调用并保留为用户消息: %v / %q", question["output"], output.Data[3].Content)
+	}
+}
+
+func TestNativeToolCalls(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "task-2")
+	if err := os.Mkdir(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	writeTask(t, dir, map[string]string{apiHistoryFile: `[
+		{"role":"user","content":[{"type":"text","text":"<task>\nfix main\n</task>"},{"type":"text","text":"<environment_details>\ncwd\n</environment_details>"}]},
+		{"role":"assistant","content":[{"type":"tool_use","id":"t1","name":"read_file","input":{"path":"main.go"}}]},
+		{"role":"user","content":[{"type":"tool_result","tool_use_id":"t1","content":"package main"},{"type":"text","text":"<environment_details>\ncwd\n</environment_details>"}]},
+		{"role":"assistant","content":[{"type":"text","text":"done"}]},
+		{"role":"user","content":[{"type":"tool_result","tool_use_id":"missing","content":"orphan"},{"type":"text","text":"继续"}]}
+	]`})
+
+	output := runTask(t, dir)
+
+	shapes := []nodeShape{
+		{role: "user", contentType: "text", blocks: 2},
+		{role: "assistant", contentType: "tool_use"},
+		{role: "assistant", contentType: "text"},
+		{role: "user", contentType: "multipart", blocks: 2},
+	}
+	if len(output.Data) != len(shapes) {
+		t.Fatalf("节点数应为 %d，实际 %d", len(shapes), len(output.Data))
+	}
+	for i, want := range shapes {
+		node := output.Data[i]
+		if node.Role != want.role || node.ContentType != want.contentType || len(node.Blocks) != want.blocks {
+			t.Errorf("节点 %d: 期望 %s/%s/%d 个块，实际 %s/%s/%d 个块", i, want.role, want.contentType, want.blocks, node.Role, node.ContentType, len(node.Blocks))
+		}
+	}
+
+	// 结果合并到调用后，只剩环境信息的消息不输出，也不计入对话轮数
+	if output.Data[1].ToolData["output"] != "package main" {
+		t.Errorf("tool_result 应合并到调用，实际 %v", output.Data[1].ToolData)
+	}
+	if output.RoundCount != 2 {
+		t.Errorf("对话轮数应为 2，实际 %d", output.RoundCount)
+	}
+
+	// 找不到调用的结果保留在原位置
+	if blocks := output.Data[3].Blocks; blocks[0].Type != "tool_result" || blocks[1].Text != "继续" {
+		t.Errorf("内容块应保持原始顺序，实际 %+v", blocks)
+	}
+}
+
+func TestMatchRequestByTimestamp(t *testing.T) {
+	requests := []ClineRequest{{Ts: 1000}, {Ts: 1500}, {Ts: 3000}}
+
+	tests := []struct {
+		name string
+		from int
+		ts   int64
+		want int
+	}{
+		{"跳过失败重试的请求", 0, 2000, 1},
+		{"下一条消息", 2, 4000, 2},
+		{"消息早于所有请求", 0, 500, -1},
+		{"请求已用完", 3, 5000, -1},
+		{"没有时间戳按顺序", 1, 0, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := matchRequest(requests, tt.from, tt.ts); got != tt.want {
+				t.Errorf("matchRequest(%d, %d) = %d，期望 %d", tt.from, tt.ts, got, tt.want)
+			}
+		})
+	}
+}
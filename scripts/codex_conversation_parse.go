@@ -2,28 +2,70 @@ package main
 
 import (
 	"bufio"
+	"crypto/sha1"
+	"encoding/hex"
 	"encoding/json"
 	"flag"
 	"fmt"
+	"io/fs"
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"runtime"
+	"sort"
 	"strings"
+	"sync"
 )
 
 // Codex 数据结构定义
 type CodexMessage struct {
-	Type      string         `json:"type"`
-	Timestamp string         `json:"timestamp"`
-	Payload   *CodexPayload  `json:"payload"`
+	Type      string        `json:"type"`
+	Timestamp string        `json:"timestamp"`
+	Payload   *CodexPayload `json:"payload"`
+
+	// 以下字段不来自 JSON，读取时填充，用于生成稳定的节点ID
+	Line int    `json:"-"` // 记录在原始文件中的行号（从1开始，空行也计数）
+	Hash string `json:"-"` // 原始记录内容的哈希
 }
 
 type CodexPayload struct {
-	Type    string          `json:"type"`
-	Role    string          `json:"role"`
-	Content json.RawMessage `json:"content"`
-	Summary json.RawMessage `json:"summary"`
-	ID      string          `json:"id"` // session_meta 中的 id
+	Type      string          `json:"type"`
+	Role      string          `json:"role"`
+	Content   json.RawMessage `json:"content"`
+	Summary   json.RawMessage `json:"summary"`
+	ID        string          `json:"id"` // session_meta 中的 id
+	Name      string          `json:"name"`
+	Arguments string          `json:"arguments"` // function_call 的参数（JSON字符串）
+	Input     string          `json:"input"`     // custom_tool_call 的输入
+	CallID    string          `json:"call_id"`
+	Output    json.RawMessage `json:"output"`
+	Status    string          `json:"status"`
+	Action    json.RawMessage `json:"action"` // local_shell_call 的执行动作
+
+	// session_meta / turn_context 字段
+	Cwd          string    `json:"cwd"`
+	Originator   string    `json:"originator"`
+	CLIVersion   string    `json:"cli_version"`
+	Instructions string    `json:"instructions"`
+	Git          *CodexGit `json:"git"`
+	Model        string    `json:"model"`
+}
+
+type CodexGit struct {
+	CommitHash    string `json:"commit_hash"`
+	Branch        string `json:"branch"`
+	RepositoryURL string `json:"repository_url"`
+}
+
+// 会话元数据（来自 session_meta 和第一条 turn_context）
+type SessionMeta struct {
+	ID           string
+	Cwd          string
+	Originator   string
+	CLIVersion   string
+	Instructions string
+	Git          *CodexGit
+	Model        string
 }
 
 type ContentItem struct {
@@ -33,72 +75,252 @@ type ContentItem struct {
 
 // 输出节点结构（与GPT格式保持一致）
 type OutputNode struct {
-	ID          string   `json:"id"`
-	ParentID    string   `json:"parent_id"`
-	ChildID     string   `json:"child_id"`
-	Role        string   `json:"role"`
-	ContentType string   `json:"content_type"`
-	Content     string   `json:"content,omitempty"`
-	Images      []string `json:"images,omitempty"`
-	CreateTime  *string  `json:"create_time"`
+	ID          string                 `json:"id"`
+	ParentID    string                 `json:"parent_id"`
+	ChildID     string                 `json:"child_id"`
+	Role        string                 `json:"role"`
+	ContentType string                 `json:"content_type"`
+	Content     string                 `json:"content,omitempty"`
+	Images      []string               `json:"images,omitempty"`
+	ToolData    map[string]interface{} `json:"tool_data,omitempty"`
+	CreateTime  *string                `json:"create_time"`
 }
 
 // 输出文件结构
 type OutputFile struct {
-	RoundCount int          `json:"round_count"` // 对话轮数（user/human消息数量）
-	TotalCount int          `json:"total_count"` // 总消息数量
-	ProjectID  string       `json:"project_id,omitempty"`
-	Data       []OutputNode `json:"data"`
+	RoundCount int                    `json:"round_count"` // 对话轮数（user/human消息数量）
+	TotalCount int                    `json:"total_count"` // 总消息数量
+	ProjectID  string                 `json:"project_id,omitempty"`
+	Metadata   map[string]interface{} `json:"metadata,omitempty"`
+	Data       []OutputNode           `json:"data"`
+}
+
+// 单个会话文件的处理结果
+type FileResult struct {
+	InputFile  string
+	OutputFile string
+	Count      int
+	Skipped    bool // 文件中没有有效消息
+	Err        error
+}
+
+// Codex 注入的上下文消息前缀（不是用户真正输入的内容）
+var injectedContextPrefixes = []string{
+	"<environment_context>",
+	"<user_instructions>",
+	"# AGENTS.md instructions for",
 }
 
 func main() {
 	// 解析命令行参数
 	inputFile := flag.String("input", "", "输入的JSONL文件路径")
+	inputDir := flag.String("dir", "", "会话目录（如 ~/.codex/sessions），递归解析其中所有 .jsonl 文件")
 	outputDir := flag.String("output", "parsed/codex/conversation", "输出目录")
+	workers := flag.Int("workers", runtime.NumCPU(), "目录模式下的并发解析数")
+	dropContext := flag.Bool("drop-context", false, "丢弃 Codex 注入的上下文消息（默认保留并标记为 injected_context）")
 	flag.Parse()
 
-	if *inputFile == "" {
-		fmt.Println("错误: 必须指定输入文件")
-		fmt.Println("用法: codex_conversation_parse -input <file> [-output <dir>]")
+	if *inputFile == "" && *inputDir == "" {
+		fmt.Println("错误: 必须指定输入文件或目录")
+		fmt.Println("用法: codex_conversation_parse -input <file> [-output <dir>] [-drop-context]")
+		fmt.Println("      codex_conversation_parse -dir <sessions_dir> [-output <dir>] [-workers <n>] [-drop-context]")
 		os.Exit(1)
 	}
-
-	// 读取并解析JSONL文件
-	messages, sessionID, err := readJSONL(*inputFile)
-	if err != nil {
-		fmt.Printf("读取文件失败: %v\n", err)
+	if *inputFile != "" && *inputDir != "" {
+		fmt.Println("错误: -input 和 -dir 不能同时指定")
 		os.Exit(1)
 	}
 
-	if len(messages) == 0 {
-		fmt.Println("警告: 文件中没有有效的消息")
-		os.Exit(0)
-	}
-
 	// 创建输出目录
 	if err := os.MkdirAll(*outputDir, 0755); err != nil {
 		fmt.Printf("创建输出目录失败: %v\n", err)
 		os.Exit(1)
 	}
 
-	// 处理对话
-	if err := processConversation(messages, sessionID, *inputFile, *outputDir); err != nil {
-		fmt.Printf("处理对话失败: %v\n", err)
+	// 目录模式
+	if *inputDir != "" {
+		files, err := collectSessionFiles(*inputDir)
+		if err != nil {
+			fmt.Printf("遍历目录失败: %v\n", err)
+			os.Exit(1)
+		}
+		if len(files) == 0 {
+			fmt.Println("警告: 目录中没有 .jsonl 文件")
+			os.Exit(0)
+		}
+
+		// 并发写入前为每个文件分配不重复的输出文件名
+		outputNames := planOutputNames(files)
+		parse := func(file string) FileResult {
+			return parseFile(file, *outputDir, outputNames[file], *dropContext)
+		}
+
+		fmt.Printf("共找到 %d 个会话文件，并发数 %d\n", len(files), *workers)
+		results := runBatch(files, *workers, parse)
+		if failed := printResults(results); failed > 0 {
+			os.Exit(1)
+		}
+		return
+	}
+
+	// 单文件模式
+	result := parseFile(*inputFile, *outputDir, "", *dropContext)
+	if result.Err != nil {
+		fmt.Printf("处理对话失败: %v\n", result.Err)
 		os.Exit(1)
 	}
+	if result.Skipped {
+		fmt.Println("警告: 文件中没有有效的消息")
+		os.Exit(0)
+	}
 
+	fmt.Printf("已生成: %s (共 %d 条消息)\n", result.OutputFile, result.Count)
 	fmt.Println("处理完成")
 }
 
-func readJSONL(filename string) ([]CodexMessage, string, error) {
+// parseFile 解析单个会话文件并写入输出目录，outputName 为空时按会话ID命名输出文件
+func parseFile(inputFile string, outputDir string, outputName string, dropContext bool) FileResult {
+	result := FileResult{InputFile: inputFile}
+
+	messages, meta, err := readJSONL(inputFile)
+	if err != nil {
+		result.Err = fmt.Errorf("读取文件失败: %v", err)
+		return result
+	}
+
+	if len(messages) == 0 {
+		result.Skipped = true
+		return result
+	}
+
+	result.OutputFile, result.Count, result.Err = processConversation(messages, meta, inputFile, outputName, outputDir, dropContext)
+	return result
+}
+
+// collectSessionFiles 递归收集目录下的所有 .jsonl 文件（按路径排序）
+func collectSessionFiles(dir string) ([]string, error) {
+	var files []string
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.IsDir() && strings.HasSuffix(d.Name(), ".jsonl") {
+			files = append(files, path)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Strings(files)
+	return files, nil
+}
+
+// planOutputNames 按文件顺序为会话ID相同的文件分配不同的输出文件名：
+// 第一个文件使用会话ID，之后的依次加 _2、_3 后缀。文件已排序，因此结果与并发顺序无关
+func planOutputNames(files []string) map[string]string {
+	names := make(map[string]string, len(files))
+	used := make(map[string]int)
+	for _, file := range files {
+		sessionID := peekSessionID(file)
+		if sessionID == "" {
+			sessionID = sessionIDFromFileName(file)
+		}
+		sessionID = sanitizeFilename(sessionID)
+		if sessionID == "" {
+			continue
+		}
+		used[sessionID]++
+		if used[sessionID] == 1 {
+			names[file] = sessionID
+		} else {
+			names[file] = fmt.Sprintf("%s_%d", sessionID, used[sessionID])
+		}
+	}
+	return names
+}
+
+// peekSessionID 读取 session_meta 记录中的会话ID，不解析完整内容
+func peekSessionID(filename string) string {
+	file, err := os.Open(filename)
+	if err != nil {
+		return ""
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	const maxCapacity = 2 * 1024 * 1024 // 2MB
+	scanner.Buffer(make([]byte, maxCapacity), maxCapacity)
+	for scanner.Scan() {
+		var msg CodexMessage
+		if json.Unmarshal(scanner.Bytes(), &msg) == nil && msg.Type == "session_meta" && msg.Payload != nil && msg.Payload.ID != "" {
+			return msg.Payload.ID
+		}
+	}
+	return ""
+}
+
+// runBatch 以有限并发解析所有文件，结果顺序与输入顺序一致
+func runBatch(files []string, workers int, parse func(string) FileResult) []FileResult {
+	if workers < 1 {
+		workers = 1
+	}
+
+	results := make([]FileResult, len(files))
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				results[i] = parse(files[i])
+			}
+		}()
+	}
+
+	for i := range files {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+
+	return results
+}
+
+// printResults 输出每个文件的处理结果和汇总，返回失败数量
+func printResults(results []FileResult) int {
+	success, skipped, failed := 0, 0, 0
+	for _, result := range results {
+		switch {
+		case result.Err != nil:
+			failed++
+			fmt.Printf("[失败] %s: %v\n", result.InputFile, result.Err)
+		case result.Skipped:
+			skipped++
+			fmt.Printf("[跳过] %s: 没有有效的消息\n", result.InputFile)
+		default:
+			success++
+			fmt.Printf("[成功] %s -> %s (共 %d 条消息)\n", result.InputFile, result.OutputFile, result.Count)
+		}
+	}
+
+	fmt.Printf("\n处理完成: 成功 %d, 跳过 %d, 失败 %d, 共 %d\n", success, skipped, failed, len(results))
+	return failed
+}
+
+func readJSONL(filename string) ([]CodexMessage, SessionMeta, error) {
+	var meta SessionMeta
+
 	file, err := os.Open(filename)
 	if err != nil {
-		return nil, "", err
+		return nil, meta, err
 	}
 	defer file.Close()
 
 	var messages []CodexMessage
-	var sessionID string
 	scanner := bufio.NewScanner(file)
 
 	// 增加缓冲区大小以处理长行
@@ -106,7 +328,9 @@ func readJSONL(filename string) ([]CodexMessage, string, error) {
 	buf := make([]byte, maxCapacity)
 	scanner.Buffer(buf, maxCapacity)
 
+	lineNum := 0
 	for scanner.Scan() {
+		lineNum++
 		line := scanner.Text()
 		if line == "" {
 			continue
@@ -118,43 +342,154 @@ func readJSONL(filename string) ([]CodexMessage, string, error) {
 			continue
 		}
 
-		// 提取 session ID
-		if msg.Type == "session_meta" && msg.Payload != nil && msg.Payload.ID != "" {
-			sessionID = msg.Payload.ID
+		// 提取会话元数据
+		if msg.Type == "session_meta" && msg.Payload != nil {
+			if msg.Payload.ID != "" {
+				meta.ID = msg.Payload.ID
+			}
+			meta.Cwd = msg.Payload.Cwd
+			meta.Originator = msg.Payload.Originator
+			meta.CLIVersion = msg.Payload.CLIVersion
+			meta.Instructions = msg.Payload.Instructions
+			meta.Git = msg.Payload.Git
 		}
 
-		// 只处理 response_item 类型且 payload 类型为 message 的记录
-		if msg.Type == "response_item" && msg.Payload != nil && msg.Payload.Type == "message" {
+		// 模型记录在 turn_context 中，取第一条
+		if msg.Type == "turn_context" && msg.Payload != nil && meta.Model == "" {
+			meta.Model = msg.Payload.Model
+		}
+
+		// 只处理 response_item 类型中的消息、工具调用及其输出、推理记录
+		if msg.Type == "response_item" && msg.Payload != nil && isSupportedItem(msg.Payload.Type) {
+			msg.Line = lineNum
+			msg.Hash = recordHash(line)
 			messages = append(messages, msg)
 		}
 	}
 
 	if err := scanner.Err(); err != nil {
-		return nil, "", err
+		return nil, meta, err
 	}
 
-	return messages, sessionID, nil
+	return messages, meta, nil
 }
 
-func processConversation(messages []CodexMessage, sessionID string, inputFile string, outputDir string) error {
+// recordHash 计算原始记录的内容哈希（取 sha1 前12位）
+// 基于原始行而不是解析后的内容，解析逻辑变化时哈希不变
+func recordHash(line string) string {
+	sum := sha1.Sum([]byte(line))
+	return hex.EncodeToString(sum[:])[:12]
+}
+
+// nodeIDFor 生成稳定的节点ID：会话ID + 原始行号 + 内容哈希
+// 不依赖解析后的下标，跳过空消息或调整解析逻辑都不会改变已有节点的ID
+func nodeIDFor(sessionID string, msg CodexMessage) string {
+	return fmt.Sprintf("%s-L%d-%s", sessionID, msg.Line, msg.Hash)
+}
+
+// isSupportedItem 判断 response_item 的 payload 类型是否需要解析
+func isSupportedItem(itemType string) bool {
+	switch itemType {
+	case "message", "reasoning",
+		"function_call", "function_call_output",
+		"custom_tool_call", "custom_tool_call_output",
+		"local_shell_call":
+		return true
+	}
+	return false
+}
+
+// isToolOutput 判断记录是否为工具调用的输出
+func isToolOutput(itemType string) bool {
+	return strings.HasSuffix(itemType, "_output")
+}
+
+// isInjectedContext 判断用户消息是否为 Codex 自动注入的上下文
+func isInjectedContext(content string) bool {
+	trimmed := strings.TrimSpace(content)
+	for _, prefix := range injectedContextPrefixes {
+		if strings.HasPrefix(trimmed, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// buildMetadata 将会话元数据转换为输出文件的 metadata 字段
+func buildMetadata(meta SessionMeta) map[string]interface{} {
+	metadata := make(map[string]interface{})
+	setIfNotEmpty := func(key, value string) {
+		if value != "" {
+			metadata[key] = value
+		}
+	}
+
+	setIfNotEmpty("session_id", meta.ID)
+	setIfNotEmpty("cwd", meta.Cwd)
+	setIfNotEmpty("originator", meta.Originator)
+	setIfNotEmpty("cli_version", meta.CLIVersion)
+	setIfNotEmpty("model", meta.Model)
+	setIfNotEmpty("instructions", meta.Instructions)
+	if meta.Git != nil {
+		setIfNotEmpty("git_repository", meta.Git.RepositoryURL)
+		setIfNotEmpty("git_branch", meta.Git.Branch)
+		setIfNotEmpty("git_commit", meta.Git.CommitHash)
+	}
+
+	if len(metadata) == 0 {
+		return nil
+	}
+	return metadata
+}
+
+func processConversation(messages []CodexMessage, meta SessionMeta, inputFile string, outputName string, outputDir string, dropContext bool) (string, int, error) {
 	if len(messages) == 0 {
-		return fmt.Errorf("没有有效的消息节点")
+		return "", 0, fmt.Errorf("没有有效的消息节点")
+	}
+
+	// 会话ID同时用于输出文件名和节点ID
+	// 优先使用 session_meta 中的 id，缺失时从输入文件名提取（只保留最后的UUID部分）
+	sessionID := meta.ID
+	if sessionID == "" {
+		sessionID = sessionIDFromFileName(inputFile)
+	}
+	if sessionID == "" {
+		sessionID = messages[0].Timestamp
 	}
+	sessionID = sanitizeFilename(sessionID)
 
 	// 转换为输出格式
 	nodes := []OutputNode{}
+	callIndex := make(map[string]int) // call_id -> 调用节点在 nodes 中的下标
 	var prevID string
 
-	for idx, msg := range messages {
-		content := extractContent(msg.Payload)
+	for _, msg := range messages {
+		payload := msg.Payload
+
+		// 工具输出按 call_id 合并到对应的调用节点
+		if isToolOutput(payload.Type) {
+			if nodeIdx, ok := callIndex[payload.CallID]; ok && payload.CallID != "" {
+				attachToolOutput(nodes[nodeIdx].ToolData, payload.Output)
+				continue
+			}
+		}
 
-		// 如果内容为空，跳过
-		if content == "" {
+		role, contentType, content, toolData := extractItem(payload)
+
+		// 如果内容为空且没有tool_data，跳过
+		if content == "" && toolData == nil {
 			continue
 		}
 
-		// 生成节点ID（使用索引+时间戳）
-		nodeID := fmt.Sprintf("node_%d_%s", idx, msg.Timestamp)
+		// 标记或丢弃注入的上下文消息
+		if role == "user" && isInjectedContext(content) {
+			if dropContext {
+				continue
+			}
+			contentType = "injected_context"
+		}
+
+		nodeID := nodeIDFor(sessionID, msg)
 
 		parentID := prevID
 		childID := ""
@@ -163,16 +498,20 @@ func processConversation(messages []CodexMessage, sessionID string, inputFile st
 			ID:          nodeID,
 			ParentID:    parentID,
 			ChildID:     childID,
-			Role:        msg.Payload.Role,
-			ContentType: "text",
+			Role:        role,
+			ContentType: contentType,
 			Content:     content,
+			ToolData:    toolData,
 			CreateTime:  &msg.Timestamp,
 		}
 
 		nodes = append(nodes, node)
+		if toolData != nil && payload.CallID != "" && !isToolOutput(payload.Type) {
+			callIndex[payload.CallID] = len(nodes) - 1
+		}
 
 		// 更新上一个节点的child_id
-		if idx > 0 && len(nodes) >= 2 {
+		if len(nodes) >= 2 {
 			nodes[len(nodes)-2].ChildID = nodeID
 		}
 
@@ -180,38 +519,19 @@ func processConversation(messages []CodexMessage, sessionID string, inputFile st
 	}
 
 	if len(nodes) == 0 {
-		return fmt.Errorf("没有有效的内容节点")
+		return "", 0, fmt.Errorf("没有有效的内容节点")
 	}
 
-	// 生成输出文件名
-	// 从输入文件名提取session ID（只保留最后的UUID部分）
-	inputFileName := filepath.Base(inputFile)
-	if inputFileName != "" {
-		// 去掉 .jsonl 后缀
-		baseName := strings.TrimSuffix(inputFileName, ".jsonl")
-		// 文件名格式：rollout-2025-10-14T01-04-12-0199de87-9743-7533-afcd-751a16622fca
-		// 提取最后的UUID部分（最后5段，用-连接）
-		parts := strings.Split(baseName, "-")
-		if len(parts) >= 5 {
-			// 取最后5段作为 UUID
-			sessionID = strings.Join(parts[len(parts)-5:], "-")
-		} else {
-			sessionID = baseName
-		}
+	if outputName == "" {
+		outputName = sessionID
 	}
-
-	if sessionID == "" {
-		sessionID = messages[0].Timestamp
-	}
-	sessionID = sanitizeFilename(sessionID)
-
-	outputFile := filepath.Join(outputDir, sessionID+".json")
+	outputFile := filepath.Join(outputDir, sanitizeFilename(outputName)+".json")
 
 	// 计算统计信息
 	totalCount := len(nodes)
 	roundCount := 0
 	for _, node := range nodes {
-		if node.Role == "user" || node.Role == "human" {
+		if (node.Role == "user" || node.Role == "human") && node.ContentType != "injected_context" {
 			roundCount++
 		}
 	}
@@ -220,22 +540,151 @@ func processConversation(messages []CodexMessage, sessionID string, inputFile st
 	output := OutputFile{
 		RoundCount: roundCount,
 		TotalCount: totalCount,
+		Metadata:   buildMetadata(meta),
 		Data:       nodes,
 	}
 
 	// 序列化为JSON
 	jsonData, err := json.MarshalIndent(output, "", "  ")
 	if err != nil {
-		return fmt.Errorf("序列化JSON失败: %v", err)
+		return "", 0, fmt.Errorf("序列化JSON失败: %v", err)
 	}
 
 	// 写入文件
 	if err := ioutil.WriteFile(outputFile, jsonData, 0644); err != nil {
-		return fmt.Errorf("写入文件失败: %v", err)
+		return "", 0, fmt.Errorf("写入文件失败: %v", err)
 	}
 
-	fmt.Printf("已生成: %s (共 %d 条消息)\n", outputFile, len(nodes))
-	return nil
+	return outputFile, len(nodes), nil
+}
+
+// sessionIDFromFileName 从输入文件名提取会话ID（只保留最后的UUID部分）
+func sessionIDFromFileName(inputFile string) string {
+	inputFileName := filepath.Base(inputFile)
+	if inputFileName == "" {
+		return ""
+	}
+	// 去掉 .jsonl 后缀
+	baseName := strings.TrimSuffix(inputFileName, ".jsonl")
+	// 文件名格式：rollout-2025-10-14T01-04-12-0199de87-9743-7533-afcd-751a16622fca
+	// 提取最后的UUID部分（最后5段，用-连接）
+	parts := strings.Split(baseName, "-")
+	if len(parts) >= 5 {
+		// 取最后5段作为 UUID
+		return strings.Join(parts[len(parts)-5:], "-")
+	}
+	return baseName
+}
+
+// extractItem 按记录类型提取角色、内容类型、文本内容和工具数据
+func extractItem(payload *CodexPayload) (string, string, string, map[string]interface{}) {
+	switch payload.Type {
+	case "message":
+		return payload.Role, "text", extractContent(payload), nil
+
+	case "reasoning":
+		return "assistant", "reasoning", extractSummary(payload.Summary), nil
+
+	case "function_call":
+		toolData := map[string]interface{}{
+			"name":    payload.Name,
+			"call_id": payload.CallID,
+			"input":   decodeJSONString(payload.Arguments),
+		}
+		return "assistant", payload.Type, "", toolData
+
+	case "custom_tool_call":
+		toolData := map[string]interface{}{
+			"name":    payload.Name,
+			"call_id": payload.CallID,
+			"input":   payload.Input,
+		}
+		if payload.Status != "" {
+			toolData["status"] = payload.Status
+		}
+		return "assistant", payload.Type, "", toolData
+
+	case "local_shell_call":
+		toolData := map[string]interface{}{
+			"name":    "local_shell",
+			"call_id": payload.CallID,
+		}
+		var action interface{}
+		if len(payload.Action) > 0 && json.Unmarshal(payload.Action, &action) == nil {
+			toolData["input"] = action
+		}
+		if payload.Status != "" {
+			toolData["status"] = payload.Status
+		}
+		return "assistant", payload.Type, "", toolData
+
+	default:
+		// 找不到对应调用的工具输出，单独作为 tool 节点保留
+		toolData := map[string]interface{}{
+			"call_id": payload.CallID,
+		}
+		attachToolOutput(toolData, payload.Output)
+		return "tool", payload.Type, "", toolData
+	}
+}
+
+// attachToolOutput 将工具输出写入 tool_data
+// function_call_output 的 output 常为 {"output": "...", "metadata": {...}} 形式的JSON字符串
+func attachToolOutput(toolData map[string]interface{}, raw json.RawMessage) {
+	if len(raw) == 0 {
+		return
+	}
+
+	var output interface{}
+	if err := json.Unmarshal(raw, &output); err != nil {
+		return
+	}
+	if str, ok := output.(string); ok {
+		output = decodeJSONString(str)
+	}
+
+	if obj, ok := output.(map[string]interface{}); ok {
+		if inner, exists := obj["output"]; exists {
+			toolData["output"] = inner
+			if metadata, ok := obj["metadata"].(map[string]interface{}); ok {
+				toolData["metadata"] = metadata
+			}
+			return
+		}
+	}
+	toolData["output"] = output
+}
+
+// decodeJSONString 尝试将JSON字符串解析为对象或数组，失败时原样返回
+func decodeJSONString(str string) interface{} {
+	trimmed := strings.TrimSpace(str)
+	if strings.HasPrefix(trimmed, "{") || strings.HasPrefix(trimmed, "[") {
+		var value interface{}
+		if err := json.Unmarshal([]byte(trimmed), &value); err == nil {
+			return value
+		}
+	}
+	return str
+}
+
+// extractSummary 提取 reasoning 记录中的摘要文本
+func extractSummary(raw json.RawMessage) string {
+	if len(raw) == 0 {
+		return ""
+	}
+
+	var summary []ContentItem
+	if err := json.Unmarshal(raw, &summary); err != nil {
+		return ""
+	}
+
+	var textParts []string
+	for _, item := range summary {
+		if item.Text != "" {
+			textParts = append(textParts, item.Text)
+		}
+	}
+	return strings.Join(textParts, "\n")
 }
 
 func extractContent(payload *CodexPayload) string {
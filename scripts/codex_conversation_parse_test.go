@@ -0,0 +1,69 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// 运行方式（scripts 目录下各工具都是独立的 main 包，需要指定文件）:
+//
+//	go test -v codex_conversation_parse.go codex_conversation_parse_test.go
+
+// parseLines 将记录写入临时会话文件，解析后返回输出的节点
+func parseLines(t *testing.T, lines ...string) []OutputNode {
+	t.Helper()
+	dir := t.TempDir()
+	inputFile := filepath.Join(dir, "rollout.jsonl")
+	if err := os.WriteFile(inputFile, []byte(strings.Join(lines, "\n")+"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	messages, meta, err := readJSONL(inputFile)
+	if err != nil {
+		t.Fatalf("readJSONL 失败: %v", err)
+	}
+	outputFile, _, err := processConversation(messages, meta, inputFile, "", dir, false)
+	if err != nil {
+		t.Fatalf("processConversation 失败: %v", err)
+	}
+	data, err := os.ReadFile(outputFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var output OutputFile
+	if err := json.Unmarshal(data, &output); err != nil {
+		t.Fatal(err)
+	}
+	return output.Data
+}
+
+func TestNodeIDStableWhenRecordSkipped(t *testing.T) {
+	meta := `{"type":"session_meta","timestamp":"2025-10-14T01:04:12Z","payload":{"id":"s1","cwd":"/w"}}`
+	user := `{"type":"response_item","timestamp":"2025-10-14T01:04:13Z","payload":{"type":"message","role":"user","content":[{"type":"input_text","text":"hi"}]}}`
+	empty := `{"type":"response_item","timestamp":"2025-10-14T01:04:14Z","payload":{"type":"message","role":"assistant","content":[]}}`
+	filled := `{"type":"response_item","timestamp":"2025-10-14T01:04:14Z","payload":{"type":"message","role":"assistant","content":[{"type":"output_text","text":"..."}]}}`
+	reply := `{"type":"response_item","timestamp":"2025-10-14T01:04:15Z","payload":{"type":"message","role":"assistant","content":[{"type":"output_text","text":"hello"}]}}`
+
+	skipped := parseLines(t, meta, "", user, empty, reply)
+	kept := parseLines(t, meta, "", user, filled, reply)
+
+	if len(skipped) != 2 || len(kept) != 3 {
+		t.Fatalf("节点数应为 2 和 3，实际 %d 和 %d", len(skipped), len(kept))
+	}
+
+	// ID 由原始行号和行内容哈希决定（空行也计入行号），与跳过的空记录无关
+	wantUser := "s1-L3-" + recordHash(user)
+	wantReply := "s1-L5-" + recordHash(reply)
+	if skipped[0].ID != wantUser || skipped[1].ID != wantReply {
+		t.Errorf("节点ID应为 %s、%s，实际 %s、%s", wantUser, wantReply, skipped[0].ID, skipped[1].ID)
+	}
+	if kept[0].ID != skipped[0].ID || kept[2].ID != skipped[1].ID {
+		t.Errorf("跳过空记录不应改变其他节点的ID: %s、%s / %s、%s", kept[0].ID, kept[2].ID, skipped[0].ID, skipped[1].ID)
+	}
+	if skipped[1].ParentID != wantUser {
+		t.Errorf("跳过空记录后回复的父节点应为 %s，实际 %s", wantUser, skipped[1].ParentID)
+	}
+}
@@ -0,0 +1,547 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"html"
+	"io/ioutil"
+	"mime"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// 解析后的对话文件（parsed/<source>/conversation/<id>.json），兼容各来源的输出格式
+type ConversationFile struct {
+	RoundCount int                    `json:"round_count"`
+	TotalCount int                    `json:"total_count"`
+	ProjectID  string                 `json:"project_id"`
+	Metadata   map[string]interface{} `json:"metadata"`
+	Data       []ConversationNode     `json:"data"`
+}
+
+type ConversationNode struct {
+	ID          string                 `json:"id"`
+	ParentID    string                 `json:"parent_id"`
+	ChildID     string                 `json:"child_id"`
+	Role        string                 `json:"role"`
+	ContentType string                 `json:"content_type"`
+	Content     string                 `json:"content"`
+	Images      []string               `json:"images"`
+	ToolData    map[string]interface{} `json:"tool_data"`
+	Blocks      []ContentBlock         `json:"blocks"`
+	Metadata    map[string]interface{} `json:"metadata"`
+	CreateTime  interface{}            `json:"create_time"` // GPT 为 Unix 秒，其他来源为 RFC3339 字符串
+}
+
+type ContentBlock struct {
+	Type     string                 `json:"type"`
+	Text     string                 `json:"text"`
+	ToolData map[string]interface{} `json:"tool_data"`
+}
+
+// 导出选项
+type ExportOptions struct {
+	Title     string
+	Source    string
+	ImagesDir string // 图片所在目录，用于解析 GPT 的 file-service:// 引用
+}
+
+var roleTitles = map[string]string{
+	"user":      "用户",
+	"human":     "用户",
+	"assistant": "助手",
+	"system":    "系统",
+	"tool":      "工具",
+}
+
+func main() {
+	// 解析命令行参数
+	inputFile := flag.String("input", "", "解析后的对话JSON文件路径")
+	format := flag.String("format", "markdown", "导出格式: markdown 或 html")
+	outputFile := flag.String("output", "", "输出文件路径（默认与输入同名，扩展名为 .md/.html）")
+	leafID := flag.String("leaf", "", "只导出从根节点到该节点的分支（默认导出全部节点）")
+	imagesDir := flag.String("images", "", "图片目录，html 格式下找到的图片会内嵌到文件中")
+	source := flag.String("source", "", "对话来源（默认取输入路径中 parsed/<source> 的目录名）")
+	flag.Parse()
+
+	if *inputFile == "" {
+		fmt.Println("错误: 必须指定输入文件")
+		fmt.Println("用法: conversation_export -input <file> [-format markdown|html] [-output <file>] [-leaf <node_id>] [-images <dir>]")
+		os.Exit(1)
+	}
+
+	if *format != "markdown" && *format != "html" {
+		fmt.Printf("错误: 不支持的导出格式: %s\n", *format)
+		os.Exit(1)
+	}
+
+	data, err := ioutil.ReadFile(*inputFile)
+	if err != nil {
+		fmt.Printf("读取文件失败: %v\n", err)
+		os.Exit(1)
+	}
+
+	var conv ConversationFile
+	if err := json.Unmarshal(data, &conv); err != nil {
+		fmt.Printf("解析JSON失败: %v\n", err)
+		os.Exit(1)
+	}
+
+	nodes := conv.Data
+	if *leafID != "" {
+		nodes, err = selectBranch(conv.Data, *leafID)
+		if err != nil {
+			fmt.Printf("选择分支失败: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
+	opts := ExportOptions{
+		Title:     strings.TrimSuffix(filepath.Base(*inputFile), ".json"),
+		Source:    *source,
+		ImagesDir: *imagesDir,
+	}
+	if title, ok := conv.Metadata["title"].(string); ok && title != "" {
+		opts.Title = title
+	}
+	if opts.Source == "" {
+		opts.Source = sourceFromPath(*inputFile)
+	}
+
+	var content string
+	ext := ".md"
+	if *format == "html" {
+		content = renderHTML(conv, nodes, opts)
+		ext = ".html"
+	} else {
+		content = renderMarkdown(conv, nodes, opts)
+	}
+
+	if *outputFile == "" {
+		*outputFile = strings.TrimSuffix(*inputFile, ".json") + ext
+	}
+	if err := ioutil.WriteFile(*outputFile, []byte(content), 0644); err != nil {
+		fmt.Printf("写入文件失败: %v\n", err)
+		os.Exit(1)
+	}
+
+	fmt.Printf("已导出: %s (共 %d 条消息)\n", *outputFile, len(nodes))
+}
+
+// sourceFromPath 从 parsed/<source>/conversation/<id>.json 路径中取出来源
+func sourceFromPath(path string) string {
+	dir := filepath.Dir(path)
+	if filepath.Base(dir) == "conversation" {
+		return filepath.Base(filepath.Dir(dir))
+	}
+	return ""
+}
+
+// selectBranch 沿 parent_id 从指定节点回溯到根节点，返回从根到该节点的分支
+func selectBranch(nodes []ConversationNode, leafID string) ([]ConversationNode, error) {
+	nodeMap := make(map[string]ConversationNode, len(nodes))
+	for _, node := range nodes {
+		nodeMap[node.ID] = node
+	}
+
+	if _, ok := nodeMap[leafID]; !ok {
+		return nil, fmt.Errorf("未找到节点: %s", leafID)
+	}
+
+	var branch []ConversationNode
+	visited := make(map[string]bool)
+	for id := leafID; id != ""; {
+		node, ok := nodeMap[id]
+		if !ok || visited[id] {
+			break
+		}
+		visited[id] = true
+		branch = append(branch, node)
+		id = node.ParentID
+	}
+
+	// 反转为从根到叶的顺序
+	for i, j := 0, len(branch)-1; i < j; i, j = i+1, j-1 {
+		branch[i], branch[j] = branch[j], branch[i]
+	}
+	return branch, nil
+}
+
+// headerFields 返回头部展示的元数据（按键名排序，保证输出稳定）
+func headerFields(conv ConversationFile, nodes []ConversationNode, opts ExportOptions) [][2]string {
+	fields := [][2]string{}
+	if opts.Source != "" {
+		fields = append(fields, [2]string{"来源", opts.Source})
+	}
+	if conv.ProjectID != "" {
+		fields = append(fields, [2]string{"项目", conv.ProjectID})
+	}
+	fields = append(fields, [2]string{"对话轮数", fmt.Sprintf("%d", conv.RoundCount)})
+	fields = append(fields, [2]string{"消息数量", fmt.Sprintf("%d", len(nodes))})
+	if len(nodes) > 0 {
+		if start := formatTime(nodes[0].CreateTime); start != "" {
+			fields = append(fields, [2]string{"开始时间", start})
+		}
+	}
+
+	keys := make([]string, 0, len(conv.Metadata))
+	for key := range conv.Metadata {
+		if key != "title" {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		fields = append(fields, [2]string{key, metadataValue(conv.Metadata[key])})
+	}
+	return fields
+}
+
+func metadataValue(value interface{}) string {
+	if str, ok := value.(string); ok {
+		return str
+	}
+	data, _ := json.Marshal(value)
+	return string(data)
+}
+
+// formatTime 统一格式化 create_time（Unix 秒或 RFC3339 字符串）
+func formatTime(value interface{}) string {
+	switch v := value.(type) {
+	case float64:
+		sec := int64(v)
+		return time.Unix(sec, int64((v-float64(sec))*1e9)).Local().Format("2006-01-02 15:04:05")
+	case string:
+		if t, err := time.Parse(time.RFC3339Nano, v); err == nil {
+			return t.Local().Format("2006-01-02 15:04:05")
+		}
+		return v
+	}
+	return ""
+}
+
+func roleTitle(role string) string {
+	if title, ok := roleTitles[role]; ok {
+		return title
+	}
+	return role
+}
+
+// nodeBlocks 按原始顺序返回节点的内容块；没有 blocks 时由扁平的 content / tool_data 组成一个块
+func nodeBlocks(node ConversationNode) []ContentBlock {
+	if len(node.Blocks) > 0 {
+		return node.Blocks
+	}
+	return []ContentBlock{{Type: node.ContentType, Text: node.Content, ToolData: node.ToolData}}
+}
+
+// toolSummary 生成工具调用的折叠标题
+func toolSummary(toolData map[string]interface{}) string {
+	name, _ := toolData["name"].(string)
+	if name == "" {
+		name = "工具结果"
+	}
+	summary := "工具调用: " + name
+	if isError, _ := toolData["is_error"].(bool); isError {
+		summary += "（失败）"
+	}
+	return summary
+}
+
+// toolInput 返回工具调用的输入，以及是否为序列化后的 JSON（字符串输入如 Codex 的命令原样返回）
+func toolInput(toolData map[string]interface{}) (string, bool) {
+	input, ok := toolData["input"]
+	if !ok {
+		input, ok = toolData["edits"]
+	}
+	if !ok || input == nil {
+		return "", false
+	}
+	if str, ok := input.(string); ok {
+		return str, false
+	}
+	data, _ := json.MarshalIndent(input, "", "  ")
+	return string(data), true
+}
+
+func toolOutput(toolData map[string]interface{}) string {
+	output, _ := toolData["output"].(string)
+	return output
+}
+
+// codeFence 返回不与内容冲突的代码块围栏
+func codeFence(content string) string {
+	fence := "```"
+	for strings.Contains(content, fence) {
+		fence += "`"
+	}
+	return fence
+}
+
+// resolveImage 将图片引用解析为可用的地址
+// http(s) 和 data: 地址直接使用；GPT 的 file-service:// 等引用在图片目录中按文件 id 查找
+func resolveImage(ref string, imagesDir string) (string, bool) {
+	if strings.HasPrefix(ref, "http://") || strings.HasPrefix(ref, "https://") || strings.HasPrefix(ref, "data:") {
+		return ref, true
+	}
+	if imagesDir == "" {
+		return "", false
+	}
+
+	id := ref
+	if idx := strings.Index(id, "://"); idx >= 0 {
+		id = id[idx+3:]
+	}
+	matches, _ := filepath.Glob(filepath.Join(imagesDir, id+"*"))
+	if len(matches) == 0 {
+		return "", false
+	}
+	return matches[0], true
+}
+
+// embedImage 将本地图片转换为 data URI，便于生成独立的 HTML 文件
+func embedImage(path string) (string, bool) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", false
+	}
+	mimeType := mime.TypeByExtension(filepath.Ext(path))
+	if mimeType == "" {
+		mimeType = "image/png"
+	}
+	return "data:" + mimeType + ";base64," + base64.StdEncoding.EncodeToString(data), true
+}
+
+func renderMarkdown(conv ConversationFile, nodes []ConversationNode, opts ExportOptions) string {
+	var sb strings.Builder
+
+	// 元数据头部
+	sb.WriteString("# " + opts.Title + "\n\n")
+	sb.WriteString("| 字段 | 值 |\n|---|---|\n")
+	for _, field := range headerFields(conv, nodes, opts) {
+		value := strings.ReplaceAll(field[1], "|", "\\|")
+		sb.WriteString(fmt.Sprintf("| %s | %s |\n", field[0], value))
+	}
+	sb.WriteString("\n")
+
+	for _, node := range nodes {
+		sb.WriteString("## " + roleTitle(node.Role))
+		if createTime := formatTime(node.CreateTime); createTime != "" {
+			sb.WriteString(" · " + createTime)
+		}
+		sb.WriteString("\n\n")
+
+		// 文本和工具调用按内容块的原始顺序输出
+		for _, block := range nodeBlocks(node) {
+			if block.Text != "" {
+				if block.Type == "code" {
+					fence := codeFence(block.Text)
+					sb.WriteString(fence + "\n" + block.Text + "\n" + fence + "\n\n")
+				} else {
+					sb.WriteString(block.Text + "\n\n")
+				}
+			}
+			// 工具调用使用 details 折叠
+			if block.ToolData != nil {
+				writeMarkdownTool(&sb, block.ToolData)
+			}
+		}
+
+		for _, ref := range node.Images {
+			if src, ok := resolveImage(ref, opts.ImagesDir); ok {
+				sb.WriteString(fmt.Sprintf("![图片](%s)\n\n", src))
+			} else {
+				sb.WriteString(fmt.Sprintf("`[图片: %s]`\n\n", ref))
+			}
+		}
+	}
+
+	return sb.String()
+}
+
+// writeMarkdownTool 以 details 折叠输出工具调用的输入和输出
+func writeMarkdownTool(sb *strings.Builder, toolData map[string]interface{}) {
+	sb.WriteString("<details>\n<summary>" + html.EscapeString(toolSummary(toolData)) + "</summary>\n\n")
+	if input, isJSON := toolInput(toolData); input != "" {
+		fence, lang := codeFence(input), ""
+		if isJSON {
+			lang = "json"
+		}
+		sb.WriteString("**输入**\n\n" + fence + lang + "\n" + input + "\n" + fence + "\n\n")
+	}
+	if output := toolOutput(toolData); output != "" {
+		fence := codeFence(output)
+		sb.WriteString("**输出**\n\n" + fence + "\n" + output + "\n" + fence + "\n\n")
+	}
+	sb.WriteString("</details>\n\n")
+}
+
+const htmlStyle = `body{max-width:900px;margin:2em auto;padding:0 1em;font-family:-apple-system,"PingFang SC","Microsoft YaHei",sans-serif;line-height:1.6;color:#1f2937}
+table.meta{border-collapse:collapse;margin-bottom:2em;font-size:14px}
+table.meta td{border:1px solid #e5e7eb;padding:4px 10px;vertical-align:top;word-break:break-all}
+.message{border-left:4px solid #d1d5db;padding:0.5em 1em;margin:1em 0}
+.message.user,.message.human{border-color:#3b82f6;background:#eff6ff}
+.message.assistant{border-color:#10b981}
+.message.system,.message.tool{border-color:#9ca3af;background:#f9fafb}
+.message h2{font-size:15px;margin:0 0 0.5em}
+.message h2 .time{font-weight:normal;color:#6b7280;margin-left:0.5em}
+pre{background:#111827;color:#f9fafb;padding:0.8em;overflow-x:auto;border-radius:4px}
+code{font-family:Menlo,Consolas,monospace;font-size:13px}
+details{margin:0.5em 0;border:1px solid #e5e7eb;border-radius:4px;padding:0.3em 0.8em}
+summary{cursor:pointer;color:#4b5563}
+img{max-width:100%}`
+
+func renderHTML(conv ConversationFile, nodes []ConversationNode, opts ExportOptions) string {
+	var sb strings.Builder
+	title := html.EscapeString(opts.Title)
+
+	sb.WriteString("<!DOCTYPE html>\n<html lang=\"zh-CN\">\n<head>\n<meta charset=\"UTF-8\">\n")
+	sb.WriteString("<title>" + title + "</title>\n<style>\n" + htmlStyle + "\n</style>\n</head>\n<body>\n")
+	sb.WriteString("<h1>" + title + "</h1>\n")
+
+	// 元数据头部
+	sb.WriteString("<table class=\"meta\">\n")
+	for _, field := range headerFields(conv, nodes, opts) {
+		sb.WriteString("<tr><td>" + html.EscapeString(field[0]) + "</td><td>" + html.EscapeString(field[1]) + "</td></tr>\n")
+	}
+	sb.WriteString("</table>\n")
+
+	for _, node := range nodes {
+		sb.WriteString(fmt.Sprintf("<div class=\"message %s\" id=\"%s\">\n", html.EscapeString(node.Role), html.EscapeString(node.ID)))
+		sb.WriteString("<h2>" + html.EscapeString(roleTitle(node.Role)))
+		if createTime := formatTime(node.CreateTime); createTime != "" {
+			sb.WriteString("<span class=\"time\">" + html.EscapeString(createTime) + "</span>")
+		}
+		sb.WriteString("</h2>\n")
+
+		// 文本和工具调用按内容块的原始顺序输出
+		for _, block := range nodeBlocks(node) {
+			if block.Text != "" {
+				if block.Type == "code" {
+					sb.WriteString("<pre><code>" + html.EscapeString(block.Text) + "</code></pre>\n")
+				} else {
+					sb.WriteString(markdownToHTML(block.Text))
+				}
+			}
+			// 工具调用使用 details 折叠
+			if block.ToolData != nil {
+				writeHTMLTool(&sb, block.ToolData)
+			}
+		}
+
+		for _, ref := range node.Images {
+			src, ok := resolveImage(ref, opts.ImagesDir)
+			if ok && !strings.HasPrefix(src, "http") && !strings.HasPrefix(src, "data:") {
+				src, ok = embedImage(src)
+			}
+			if ok {
+				sb.WriteString("<p><img src=\"" + html.EscapeString(src) + "\" alt=\"图片\"></p>\n")
+			} else {
+				sb.WriteString("<p><code>[图片: " + html.EscapeString(ref) + "]</code></p>\n")
+			}
+		}
+
+		sb.WriteString("</div>\n")
+	}
+
+	sb.WriteString("</body>\n</html>\n")
+	return sb.String()
+}
+
+// writeHTMLTool 以 details 折叠输出工具调用的输入和输出
+func writeHTMLTool(sb *strings.Builder, toolData map[string]interface{}) {
+	sb.WriteString("<details>\n<summary>" + html.EscapeString(toolSummary(toolData)) + "</summary>\n")
+	if input, _ := toolInput(toolData); input != "" {
+		sb.WriteString("<p><strong>输入</strong></p>\n<pre><code>" + html.EscapeString(input) + "</code></pre>\n")
+	}
+	if output := toolOutput(toolData); output != "" {
+		sb.WriteString("<p><strong>输出</strong></p>\n<pre><code>" + html.EscapeString(output) + "</code></pre>\n")
+	}
+	sb.WriteString("</details>\n")
+}
+
+// markdownToHTML 将消息中的 Markdown 转换为 HTML
+// 只处理代码块、行内代码和段落，其余内容按纯文本转义输出
+func markdownToHTML(content string) string {
+	var sb strings.Builder
+	var paragraph []string
+	var code []string
+	fence, lang := "", ""
+
+	flushParagraph := func() {
+		if len(paragraph) == 0 {
+			return
+		}
+		escaped := make([]string, len(paragraph))
+		for i, line := range paragraph {
+			escaped[i] = inlineCode(html.EscapeString(line))
+		}
+		sb.WriteString("<p>" + strings.Join(escaped, "<br>\n") + "</p>\n")
+		paragraph = nil
+	}
+
+	for _, line := range strings.Split(content, "\n") {
+		trimmed := strings.TrimSpace(line)
+
+		if fence != "" {
+			if strings.HasPrefix(trimmed, fence) && strings.Trim(trimmed, "`") == "" {
+				class := ""
+				if lang != "" {
+					class = " class=\"language-" + html.EscapeString(lang) + "\""
+				}
+				sb.WriteString("<pre><code" + class + ">" + html.EscapeString(strings.Join(code, "\n")) + "</code></pre>\n")
+				fence, lang, code = "", "", nil
+				continue
+			}
+			code = append(code, line)
+			continue
+		}
+
+		if strings.HasPrefix(trimmed, "```") {
+			flushParagraph()
+			fence = trimmed[:len(trimmed)-len(strings.TrimLeft(trimmed, "`"))]
+			lang = strings.TrimSpace(strings.TrimLeft(trimmed, "`"))
+			continue
+		}
+
+		if trimmed == "" {
+			flushParagraph()
+			continue
+		}
+		paragraph = append(paragraph, line)
+	}
+
+	// 未闭合的代码块按代码输出
+	if fence != "" {
+		sb.WriteString("<pre><code>" + html.EscapeString(strings.Join(code, "\n")) + "</code></pre>\n")
+	}
+	flushParagraph()
+	return sb.String()
+}
+
+// inlineCode 将已转义文本中成对的 `code` 转换为 <code> 标签
+func inlineCode(escaped string) string {
+	parts := strings.Split(escaped, "`")
+	if len(parts) < 3 {
+		return escaped
+	}
+
+	var sb strings.Builder
+	for i, part := range parts {
+		switch {
+		case i == len(parts)-1 && i%2 == 1:
+			// 落单的反引号原样保留
+			sb.WriteString("`" + part)
+		case i%2 == 1:
+			sb.WriteString("<code>" + part + "</code>")
+		default:
+			sb.WriteString(part)
+		}
+	}
+	return sb.String()
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+// 运行方式（scripts 目录下各工具都是独立的 main 包，需要指定文件）:
+//
+//	go test -v conversation_export.go conversation_export_test.go
+
+func TestSelectBranch(t *testing.T) {
+	nodes := []ConversationNode{
+		{ID: "a"},
+		{ID: "b", ParentID: "a"},
+		{ID: "c", ParentID: "b"},
+		{ID: "d", ParentID: "b"}, // 另一个分支
+	}
+
+	branch, err := selectBranch(nodes, "d")
+	if err != nil {
+		t.Fatalf("selectBranch 失败: %v", err)
+	}
+	var ids []string
+	for _, node := range branch {
+		ids = append(ids, node.ID)
+	}
+	if strings.Join(ids, ",") != "a,b,d" {
+		t.Errorf("分支应为 a,b,d，实际 %v", ids)
+	}
+
+	if _, err := selectBranch(nodes, "missing"); err == nil {
+		t.Error("节点不存在时应返回错误")
+	}
+}
+
+func TestRenderMarkdownBlockOrder(t *testing.T) {
+	nodes := []ConversationNode{
+		{ID: "a", Role: "assistant", ContentType: "multipart", Content: "先看看\n完成", Blocks: []ContentBlock{
+			{Type: "text", Text: "先看看"},
+			{Type: "tool_use", ToolData: map[string]interface{}{"name": "shell", "input": "ls -a", "output": "a.go"}},
+			{Type: "text", Text: "完成"},
+			{Type: "tool_use", ToolData: map[string]interface{}{"name": "read", "input": map[string]interface{}{"path": "a.go"}}},
+		}},
+	}
+
+	output := renderMarkdown(ConversationFile{}, nodes, ExportOptions{Title: "t"})
+
+	// 文本和工具调用按内容块顺序交替出现
+	order := []string{"先看看", "工具调用: shell", "完成", "工具调用: read"}
+	last := -1
+	for _, text := range order {
+		idx := strings.Index(output, text)
+		if idx <= last {
+			t.Fatalf("%q 的位置不符合内容块顺序:\n%s", text, output)
+		}
+		last = idx
+	}
+
+	// 字符串输入原样输出，只有序列化后的输入标记为 json
+	if !strings.Contains(output, "```\nls -a\n```") {
+		t.Errorf("字符串输入不应标记为 json:\n%s", output)
+	}
+	if !strings.Contains(output, "```json\n{\n  \"path\": \"a.go\"\n}\n```") {
+		t.Errorf("对象输入应标记为 json:\n%s", output)
+	}
+}
+
+func TestMarkdownToHTML(t *testing.T) {
+	tests := []struct {
+		name     string
+		content  string
+		expected string
+	}{
+		{"段落和换行", "a\nb\n\nc", "<p>a<br>\nb</p>\n<p>c</p>\n"},
+		{"行内代码和转义", "用 `x < 1` 判断", "<p>用 <code>x &lt; 1</code> 判断</p>\n"},
+		{"代码块带语言", "```go\nfmt.Println(\"<\")\n```", "<pre><code class=\"language-go\">fmt.Println(&#34;&lt;&#34;)</code></pre>\n"},
+		{"未闭合的代码块", "```\ncode", "<pre><code>code</code></pre>\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := markdownToHTML(tt.content); got != tt.expected {
+				t.Errorf("markdownToHTML(%q) = %q，期望 %q", tt.content, got, tt.expected)
+			}
+		})
+	}
+}
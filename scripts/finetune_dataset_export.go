@@ -0,0 +1,664 @@
+package main
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// 解析后的对话文件（parsed/<source>/conversation/<id>.json），兼容各来源的输出格式
+type ConversationFile struct {
+	Metadata map[string]interface{} `json:"metadata"`
+	Data     []ConversationNode     `json:"data"`
+}
+
+type ConversationNode struct {
+	ID          string                 `json:"id"`
+	ParentID    string                 `json:"parent_id"`
+	Role        string                 `json:"role"`
+	ContentType string                 `json:"content_type"`
+	Content     string                 `json:"content"`
+	ToolData    map[string]interface{} `json:"tool_data"`
+	Blocks      []ContentBlock         `json:"blocks"`
+	CreateTime  interface{}            `json:"create_time"` // GPT 为 Unix 秒，其他来源为 RFC3339 字符串
+}
+
+type ContentBlock struct {
+	Type     string                 `json:"type"`
+	Text     string                 `json:"text"`
+	ToolData map[string]interface{} `json:"tool_data"`
+}
+
+// 标签和收藏信息（来自数据库导出），格式：
+//
+//	{"conversations": {"<uuid>": {"tags": ["监控"], "favorite": true}}}
+type ConversationMeta struct {
+	Conversations map[string]struct {
+		Tags     []string `json:"tags"`
+		Favorite bool     `json:"favorite"`
+	} `json:"conversations"`
+}
+
+// 中间消息格式，再按目标格式输出
+type ChatMessage struct {
+	Role      string // system / user / assistant
+	Text      string
+	ToolCalls []ToolCall
+}
+
+type ToolCall struct {
+	ID      string
+	Name    string
+	Input   interface{}
+	Output  string
+	IsError bool
+}
+
+// 导出选项
+type ExportOptions struct {
+	Format      string
+	Roles       map[string]bool
+	DropTools   bool
+	MaxMessages int
+	System      string
+}
+
+// 筛选条件
+type FilterOptions struct {
+	Sources       map[string]bool
+	Tags          map[string]bool
+	FavoritesOnly bool
+	Since         time.Time
+	Until         time.Time
+}
+
+// 不属于真实对话内容的节点类型
+var skippedContentTypes = map[string]bool{
+	"injected_context": true,
+	"compact_boundary": true,
+	"compact_summary":  true,
+	"aider_output":     true,
+	"reasoning":        true, // Codex 的推理摘要
+}
+
+// 模型的思考过程，不作为训练文本
+var thinkingContentTypes = map[string]bool{
+	"thinking":          true,
+	"redacted_thinking": true,
+	"reasoning":         true,
+}
+
+func main() {
+	// 解析命令行参数
+	parsedDir := flag.String("parsed", "parsed", "解析结果根目录（包含 <source>/conversation/*.json）")
+	outputFile := flag.String("output", "dataset.jsonl", "输出的JSONL文件路径")
+	format := flag.String("format", "openai", "输出格式: openai 或 anthropic")
+	sources := flag.String("sources", "", "只导出指定来源，逗号分隔（默认全部）")
+	metaFile := flag.String("meta", "", "标签和收藏信息JSON文件，按标签或收藏筛选时必须指定")
+	tags := flag.String("tags", "", "只导出带有任一指定标签的对话，逗号分隔")
+	favoritesOnly := flag.Bool("favorites", false, "只导出已收藏的对话")
+	since := flag.String("since", "", "只导出该日期及之后开始的对话（YYYY-MM-DD）")
+	until := flag.String("until", "", "只导出该日期之前开始的对话（YYYY-MM-DD）")
+	roles := flag.String("roles", "system,user,assistant", "保留的角色，逗号分隔")
+	dropTools := flag.Bool("drop-tools", false, "丢弃工具调用和工具结果，只保留文本")
+	maxMessages := flag.Int("max-messages", 0, "单条样本的最大消息数，超过时按用户轮次拆分（0 表示不拆分）")
+	dedupe := flag.Bool("dedupe", false, "首条用户提示相同的样本只保留一条")
+	system := flag.String("system", "", "对话中没有系统提示时使用的默认系统提示")
+	flag.Parse()
+
+	if *format != "openai" && *format != "anthropic" {
+		fmt.Printf("错误: 不支持的输出格式: %s\n", *format)
+		os.Exit(1)
+	}
+
+	filter := FilterOptions{
+		Sources:       splitSet(*sources),
+		Tags:          splitSet(*tags),
+		FavoritesOnly: *favoritesOnly,
+	}
+	var err error
+	if filter.Since, err = parseDate(*since); err != nil {
+		fmt.Printf("错误: 无效的 since 日期: %v\n", err)
+		os.Exit(1)
+	}
+	if filter.Until, err = parseDate(*until); err != nil {
+		fmt.Printf("错误: 无效的 until 日期: %v\n", err)
+		os.Exit(1)
+	}
+
+	var meta ConversationMeta
+	if len(filter.Tags) > 0 || filter.FavoritesOnly {
+		if *metaFile == "" {
+			fmt.Println("错误: 按标签或收藏筛选时必须通过 -meta 指定标签和收藏信息")
+			os.Exit(1)
+		}
+		data, err := ioutil.ReadFile(*metaFile)
+		if err != nil {
+			fmt.Printf("读取标签和收藏信息失败: %v\n", err)
+			os.Exit(1)
+		}
+		if err := json.Unmarshal(data, &meta); err != nil {
+			fmt.Printf("解析标签和收藏信息失败: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
+	opts := ExportOptions{
+		Format:      *format,
+		Roles:       splitSet(*roles),
+		DropTools:   *dropTools,
+		MaxMessages: *maxMessages,
+		System:      *system,
+	}
+
+	files, err := collectConversationFiles(*parsedDir, filter.Sources)
+	if err != nil {
+		fmt.Printf("遍历目录失败: %v\n", err)
+		os.Exit(1)
+	}
+	fmt.Printf("共找到 %d 个对话文件\n", len(files))
+
+	out, err := os.Create(*outputFile)
+	if err != nil {
+		fmt.Printf("创建输出文件失败: %v\n", err)
+		os.Exit(1)
+	}
+	writer := bufio.NewWriter(out)
+	// 训练数据中的代码保持原样，不转义 <、>、&
+	encoder := json.NewEncoder(writer)
+	encoder.SetEscapeHTML(false)
+
+	seenPrompts := make(map[string]bool)
+	exported, filtered, duplicated, failed, examples := 0, 0, 0, 0, 0
+	for _, file := range files {
+		conv, err := readConversation(file)
+		if err != nil {
+			failed++
+			fmt.Printf("[失败] %s: %v\n", file, err)
+			continue
+		}
+
+		convID := strings.TrimSuffix(filepath.Base(file), ".json")
+		if !matchFilter(conv, convID, filter, meta) {
+			filtered++
+			continue
+		}
+
+		messages := buildMessages(conv, opts)
+		exportedConv := false
+		for _, chunk := range splitMessages(messages, opts.MaxMessages) {
+			if !hasRole(chunk, "user") || !hasRole(chunk, "assistant") {
+				continue
+			}
+
+			if *dedupe {
+				key := promptKey(chunk)
+				if seenPrompts[key] {
+					duplicated++
+					continue
+				}
+				seenPrompts[key] = true
+			}
+
+			var example interface{}
+			if opts.Format == "anthropic" {
+				example = toAnthropic(chunk)
+			} else {
+				example = toOpenAI(chunk)
+			}
+			if err := encoder.Encode(example); err != nil {
+				failed++
+				fmt.Printf("[失败] %s: 序列化JSON失败: %v\n", file, err)
+				break
+			}
+			examples++
+			exportedConv = true
+		}
+		if exportedConv {
+			exported++
+		}
+	}
+
+	// 写入失败时输出文件不完整，不能当作成功
+	if err := writer.Flush(); err != nil {
+		out.Close()
+		fmt.Printf("写入输出文件失败: %v\n", err)
+		os.Exit(1)
+	}
+	if err := out.Close(); err != nil {
+		fmt.Printf("写入输出文件失败: %v\n", err)
+		os.Exit(1)
+	}
+
+	fmt.Printf("\n导出完成: %d 个对话生成 %d 条样本, 筛除 %d, 重复 %d, 失败 %d\n", exported, examples, filtered, duplicated, failed)
+	fmt.Printf("输出文件: %s\n", *outputFile)
+	if failed > 0 {
+		os.Exit(1)
+	}
+}
+
+func splitSet(value string) map[string]bool {
+	set := make(map[string]bool)
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			set[item] = true
+		}
+	}
+	return set
+}
+
+func parseDate(value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	return time.ParseInLocation("2006-01-02", value, time.Local)
+}
+
+// collectConversationFiles 收集 parsed/<source>/conversation/*.json（按路径排序）
+func collectConversationFiles(parsedDir string, sources map[string]bool) ([]string, error) {
+	sourceDirs, err := ioutil.ReadDir(parsedDir)
+	if err != nil {
+		return nil, err
+	}
+
+	var files []string
+	for _, sourceDir := range sourceDirs {
+		if !sourceDir.IsDir() || (len(sources) > 0 && !sources[sourceDir.Name()]) {
+			continue
+		}
+		matches, err := filepath.Glob(filepath.Join(parsedDir, sourceDir.Name(), "conversation", "*.json"))
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, matches...)
+	}
+
+	sort.Strings(files)
+	return files, nil
+}
+
+func readConversation(filename string) (*ConversationFile, error) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	var conv ConversationFile
+	if err := json.Unmarshal(data, &conv); err != nil {
+		return nil, fmt.Errorf("解析JSON失败: %v", err)
+	}
+	return &conv, nil
+}
+
+// parseTime 解析 create_time（Unix 秒或 RFC3339 字符串）
+func parseTime(value interface{}) (time.Time, bool) {
+	switch v := value.(type) {
+	case float64:
+		sec := int64(v)
+		return time.Unix(sec, int64((v-float64(sec))*1e9)), true
+	case string:
+		if t, err := time.Parse(time.RFC3339Nano, v); err == nil {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// matchFilter 按标签、收藏和开始日期筛选对话（来源在收集文件时已筛选）
+func matchFilter(conv *ConversationFile, convID string, filter FilterOptions, meta ConversationMeta) bool {
+	info := meta.Conversations[convID]
+	if filter.FavoritesOnly && !info.Favorite {
+		return false
+	}
+	if len(filter.Tags) > 0 {
+		matched := false
+		for _, tag := range info.Tags {
+			if filter.Tags[tag] {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return false
+		}
+	}
+
+	if filter.Since.IsZero() && filter.Until.IsZero() {
+		return true
+	}
+	var start time.Time
+	for _, node := range conv.Data {
+		if t, ok := parseTime(node.CreateTime); ok {
+			start = t
+			break
+		}
+	}
+	if start.IsZero() {
+		return false
+	}
+	if !filter.Since.IsZero() && start.Before(filter.Since) {
+		return false
+	}
+	if !filter.Until.IsZero() && !start.Before(filter.Until) {
+		return false
+	}
+	return true
+}
+
+// mainBranch 返回从根节点到最后一个节点的分支；parent 链不完整时按文件顺序返回全部节点
+func mainBranch(nodes []ConversationNode) []ConversationNode {
+	if len(nodes) == 0 {
+		return nodes
+	}
+
+	nodeMap := make(map[string]ConversationNode, len(nodes))
+	for _, node := range nodes {
+		nodeMap[node.ID] = node
+	}
+
+	var branch []ConversationNode
+	visited := make(map[string]bool)
+	for id := nodes[len(nodes)-1].ID; id != ""; {
+		node, ok := nodeMap[id]
+		if !ok {
+			return nodes
+		}
+		if visited[id] {
+			break
+		}
+		visited[id] = true
+		branch = append(branch, node)
+		id = node.ParentID
+	}
+
+	for i, j := 0, len(branch)-1; i < j; i, j = i+1, j-1 {
+		branch[i], branch[j] = branch[j], branch[i]
+	}
+	return branch
+}
+
+// buildMessages 将对话节点转换为中间消息：过滤角色、提取文本和工具调用，并合并相邻的同角色消息
+func buildMessages(conv *ConversationFile, opts ExportOptions) []ChatMessage {
+	var messages []ChatMessage
+	for _, node := range mainBranch(conv.Data) {
+		role := node.Role
+		if role == "human" {
+			role = "user"
+		}
+		// tool 节点是找不到对应调用的工具结果，无法组成完整的调用对
+		if role == "tool" || !opts.Roles[role] || skippedContentTypes[node.ContentType] {
+			continue
+		}
+
+		msg := ChatMessage{Role: role, Text: nodeText(node)}
+		if !opts.DropTools && role == "assistant" {
+			msg.ToolCalls = nodeToolCalls(node)
+		}
+		if msg.Text == "" && len(msg.ToolCalls) == 0 {
+			continue
+		}
+
+		// 合并相邻的同角色消息（如流式拆分的回复、连续的系统提示）
+		if last := len(messages) - 1; last >= 0 && messages[last].Role == role && len(messages[last].ToolCalls) == 0 {
+			if messages[last].Text != "" && msg.Text != "" {
+				messages[last].Text += "\n\n"
+			}
+			messages[last].Text += msg.Text
+			messages[last].ToolCalls = msg.ToolCalls
+			continue
+		}
+		messages = append(messages, msg)
+	}
+
+	if opts.System != "" && opts.Roles["system"] && !hasRole(messages, "system") {
+		messages = append([]ChatMessage{{Role: "system", Text: opts.System}}, messages...)
+	}
+	return messages
+}
+
+// nodeText 提取节点的文本内容；有内容块时只取 text 块，跳过 thinking 等思考过程
+func nodeText(node ConversationNode) string {
+	if len(node.Blocks) == 0 {
+		if thinkingContentTypes[node.ContentType] {
+			return ""
+		}
+		return strings.TrimSpace(node.Content)
+	}
+
+	var textParts []string
+	for _, block := range node.Blocks {
+		if thinkingContentTypes[block.Type] {
+			continue
+		}
+		if block.Type == "text" && block.Text != "" {
+			textParts = append(textParts, block.Text)
+		}
+	}
+	return strings.TrimSpace(strings.Join(textParts, "\n"))
+}
+
+// nodeToolCalls 提取节点中的工具调用
+func nodeToolCalls(node ConversationNode) []ToolCall {
+	var toolDatas []map[string]interface{}
+	if node.ToolData != nil {
+		toolDatas = append(toolDatas, node.ToolData)
+	}
+	for _, block := range node.Blocks {
+		if block.ToolData != nil {
+			toolDatas = append(toolDatas, block.ToolData)
+		}
+	}
+
+	var calls []ToolCall
+	for _, toolData := range toolDatas {
+		name, _ := toolData["name"].(string)
+		if name == "" {
+			continue
+		}
+		call := ToolCall{Name: name, Input: toolData["input"]}
+		if edits, ok := toolData["edits"]; ok && call.Input == nil {
+			// aider 的编辑块没有 input 字段
+			call.Input = map[string]interface{}{"edits": edits}
+		}
+		call.ID, _ = toolData["id"].(string)
+		call.Output, _ = toolData["output"].(string)
+		call.IsError, _ = toolData["is_error"].(bool)
+		if call.ID == "" {
+			call.ID = fmt.Sprintf("call_%s_%d", node.ID, len(calls))
+		}
+		if call.Input == nil {
+			call.Input = map[string]interface{}{}
+		}
+		calls = append(calls, call)
+	}
+	return calls
+}
+
+func hasRole(messages []ChatMessage, role string) bool {
+	for _, msg := range messages {
+		if msg.Role == role {
+			return true
+		}
+	}
+	return false
+}
+
+// splitMessages 按用户轮次拆分过长的会话，每段都带上开头的系统提示
+func splitMessages(messages []ChatMessage, maxMessages int) [][]ChatMessage {
+	if maxMessages <= 0 || len(messages) <= maxMessages {
+		return [][]ChatMessage{messages}
+	}
+
+	var system []ChatMessage
+	for len(messages) > 0 && messages[0].Role == "system" {
+		system = append(system, messages[0])
+		messages = messages[1:]
+	}
+
+	// 以用户消息为起点划分轮次
+	var turns [][]ChatMessage
+	for _, msg := range messages {
+		if msg.Role == "user" || len(turns) == 0 {
+			turns = append(turns, nil)
+		}
+		turns[len(turns)-1] = append(turns[len(turns)-1], msg)
+	}
+
+	var chunks [][]ChatMessage
+	var current []ChatMessage
+	for _, turn := range turns {
+		if len(current) > 0 && len(system)+len(current)+len(turn) > maxMessages {
+			chunks = append(chunks, append(append([]ChatMessage{}, system...), current...))
+			current = nil
+		}
+		current = append(current, turn...)
+	}
+	if len(current) > 0 {
+		chunks = append(chunks, append(append([]ChatMessage{}, system...), current...))
+	}
+	return chunks
+}
+
+// promptKey 以首条用户提示（忽略大小写和空白差异）作为去重依据
+func promptKey(messages []ChatMessage) string {
+	for _, msg := range messages {
+		if msg.Role == "user" {
+			normalized := strings.Join(strings.Fields(strings.ToLower(msg.Text)), " ")
+			hash := sha1.Sum([]byte(normalized))
+			return hex.EncodeToString(hash[:])
+		}
+	}
+	return ""
+}
+
+func toolInputJSON(input interface{}) string {
+	if str, ok := input.(string); ok {
+		return str
+	}
+	data, _ := json.Marshal(input)
+	return string(data)
+}
+
+// toOpenAI 转换为 OpenAI 微调格式：{"messages": [...]}
+// 工具调用放在 assistant 消息的 tool_calls 中，结果作为 role=tool 的消息紧随其后
+func toOpenAI(messages []ChatMessage) map[string]interface{} {
+	var out []map[string]interface{}
+	for _, msg := range messages {
+		item := map[string]interface{}{"role": msg.Role, "content": msg.Text}
+		if len(msg.ToolCalls) == 0 {
+			out = append(out, item)
+			continue
+		}
+
+		var toolCalls []map[string]interface{}
+		for _, call := range msg.ToolCalls {
+			toolCalls = append(toolCalls, map[string]interface{}{
+				"id":   call.ID,
+				"type": "function",
+				"function": map[string]interface{}{
+					"name":      call.Name,
+					"arguments": toolInputJSON(call.Input),
+				},
+			})
+		}
+		item["tool_calls"] = toolCalls
+		if msg.Text == "" {
+			item["content"] = nil
+		}
+		out = append(out, item)
+
+		for _, call := range msg.ToolCalls {
+			out = append(out, map[string]interface{}{
+				"role":         "tool",
+				"tool_call_id": call.ID,
+				"content":      call.Output,
+			})
+		}
+	}
+	return map[string]interface{}{"messages": out}
+}
+
+// toAnthropic 转换为 Anthropic Messages 格式：{"system": "...", "messages": [...]}
+// 系统提示放在顶层；工具结果作为下一条 user 消息的 tool_result 块，并保证 user/assistant 交替
+func toAnthropic(messages []ChatMessage) map[string]interface{} {
+	var systemParts []string
+	var out []map[string]interface{}
+
+	appendBlocks := func(role string, blocks []map[string]interface{}) {
+		if last := len(out) - 1; last >= 0 && out[last]["role"] == role {
+			out[last]["content"] = append(out[last]["content"].([]map[string]interface{}), blocks...)
+			return
+		}
+		out = append(out, map[string]interface{}{"role": role, "content": blocks})
+	}
+
+	for _, msg := range messages {
+		if msg.Role == "system" {
+			systemParts = append(systemParts, msg.Text)
+			continue
+		}
+
+		var blocks []map[string]interface{}
+		if msg.Text != "" {
+			blocks = append(blocks, map[string]interface{}{"type": "text", "text": msg.Text})
+		}
+		for _, call := range msg.ToolCalls {
+			input := call.Input
+			if str, ok := input.(string); ok {
+				// tool_use 的 input 必须是对象
+				var obj map[string]interface{}
+				if err := json.Unmarshal([]byte(str), &obj); err == nil {
+					input = obj
+				} else {
+					input = map[string]interface{}{"input": str}
+				}
+			}
+			blocks = append(blocks, map[string]interface{}{
+				"type":  "tool_use",
+				"id":    call.ID,
+				"name":  call.Name,
+				"input": input,
+			})
+		}
+		appendBlocks(msg.Role, blocks)
+
+		if len(msg.ToolCalls) > 0 {
+			var results []map[string]interface{}
+			for _, call := range msg.ToolCalls {
+				result := map[string]interface{}{
+					"type":        "tool_result",
+					"tool_use_id": call.ID,
+					"content":     call.Output,
+				}
+				if call.IsError {
+					result["is_error"] = true
+				}
+				results = append(results, result)
+			}
+			appendBlocks("user", results)
+		}
+	}
+
+	// 消息必须以 user 开头；以工具结果结尾的样本没有对应的回复，去掉末尾的 user 消息
+	for len(out) > 0 && out[0]["role"] != "user" {
+		out = out[1:]
+	}
+	for len(out) > 0 && out[len(out)-1]["role"] == "user" {
+		out = out[:len(out)-1]
+	}
+
+	example := map[string]interface{}{"messages": out}
+	if len(systemParts) > 0 {
+		example["system"] = strings.Join(systemParts, "\n\n")
+	}
+	return example
+}
@@ -0,0 +1,220 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+// 运行方式（scripts 目录下各工具都是独立的 main 包，需要指定文件）:
+//
+//	go test -v finetune_dataset_export.go finetune_dataset_export_test.go
+
+// chain 按顺序串起节点的 parent 关系
+func chain(nodes ...ConversationNode) *ConversationFile {
+	for i := range nodes {
+		if nodes[i].ID == "" {
+			nodes[i].ID = string(rune('a' + i))
+		}
+		if i > 0 {
+			nodes[i].ParentID = nodes[i-1].ID
+		}
+	}
+	return &ConversationFile{Data: nodes}
+}
+
+func TestBuildMessagesFiltering(t *testing.T) {
+	allRoles := map[string]bool{"system": true, "user": true, "assistant": true}
+
+	tests := []struct {
+		name  string
+		conv  *ConversationFile
+		roles map[string]bool
+		want  []ChatMessage
+	}{
+		{
+			name: "跳过推理摘要和思考节点",
+			conv: chain(
+				ConversationNode{Role: "user", ContentType: "text", Content: "hi"},
+				ConversationNode{Role: "assistant", ContentType: "reasoning", Content: "想一想"},
+				ConversationNode{Role: "assistant", ContentType: "thinking", Content: "再想想"},
+				ConversationNode{Role: "assistant", ContentType: "text", Content: "hello"},
+			),
+			roles: allRoles,
+			want:  []ChatMessage{{Role: "user", Text: "hi"}, {Role: "assistant", Text: "hello"}},
+		},
+		{
+			name: "内容块中只取文本",
+			conv: chain(
+				ConversationNode{Role: "human", ContentType: "text", Content: "hi"},
+				ConversationNode{Role: "assistant", ContentType: "multipart", Blocks: []ContentBlock{
+					{Type: "thinking", Text: "想一想"},
+					{Type: "reasoning", Text: "推理"},
+					{Type: "text", Text: "hello"},
+				}},
+			),
+			roles: allRoles,
+			want:  []ChatMessage{{Role: "user", Text: "hi"}, {Role: "assistant", Text: "hello"}},
+		},
+		{
+			name: "跳过注入的上下文和 tool 节点，合并相邻的同角色消息",
+			conv: chain(
+				ConversationNode{Role: "user", ContentType: "injected_context", Content: "CLAUDE.md"},
+				ConversationNode{Role: "user", ContentType: "text", Content: "hi"},
+				ConversationNode{Role: "tool", ContentType: "tool_result", Content: "orphan"},
+				ConversationNode{Role: "user", ContentType: "text", Content: "again"},
+			),
+			roles: allRoles,
+			want:  []ChatMessage{{Role: "user", Text: "hi\n\nagain"}},
+		},
+		{
+			name: "只导出指定角色",
+			conv: chain(
+				ConversationNode{Role: "system", ContentType: "text", Content: "rules"},
+				ConversationNode{Role: "user", ContentType: "text", Content: "hi"},
+				ConversationNode{Role: "assistant", ContentType: "text", Content: "hello"},
+			),
+			roles: map[string]bool{"user": true, "assistant": true},
+			want:  []ChatMessage{{Role: "user", Text: "hi"}, {Role: "assistant", Text: "hello"}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := buildMessages(tt.conv, ExportOptions{Roles: tt.roles, DropTools: true})
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("buildMessages() = %+v，期望 %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestToOpenAI(t *testing.T) {
+	messages := []ChatMessage{
+		{Role: "system", Text: "rules"},
+		{Role: "user", Text: "ls"},
+		{Role: "assistant", ToolCalls: []ToolCall{{ID: "c1", Name: "shell", Input: "ls -a", Output: "a.go"}}},
+		{Role: "assistant", Text: "有 a.go"},
+	}
+
+	got := toOpenAI(messages)
+	want := map[string]interface{}{"messages": []map[string]interface{}{
+		{"role": "system", "content": "rules"},
+		{"role": "user", "content": "ls"},
+		{
+			"role":    "assistant",
+			"content": nil, // 只有工具调用时 content 为 null
+			"tool_calls": []map[string]interface{}{{
+				"id":       "c1",
+				"type":     "function",
+				"function": map[string]interface{}{"name": "shell", "arguments": "ls -a"},
+			}},
+		},
+		{"role": "tool", "tool_call_id": "c1", "content": "a.go"},
+		{"role": "assistant", "content": "有 a.go"},
+	}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("toOpenAI() = %+v，期望 %+v", got, want)
+	}
+}
+
+func TestToAnthropic(t *testing.T) {
+	tests := []struct {
+		name     string
+		messages []ChatMessage
+		want     map[string]interface{}
+	}{
+		{
+			name: "系统提示放在顶层，工具结果作为下一条 user 消息，字符串输入包装为对象",
+			messages: []ChatMessage{
+				{Role: "system", Text: "rules"},
+				{Role: "user", Text: "ls"},
+				{Role: "assistant", Text: "好", ToolCalls: []ToolCall{
+					{ID: "c1", Name: "shell", Input: "ls -a", Output: "a.go"},
+					{ID: "c2", Name: "read", Input: `{"path":"a.go"}`, Output: "no", IsError: true},
+				}},
+				{Role: "assistant", Text: "完成"},
+			},
+			want: map[string]interface{}{
+				"system": "rules",
+				"messages": []map[string]interface{}{
+					{"role": "user", "content": []map[string]interface{}{{"type": "text", "text": "ls"}}},
+					{"role": "assistant", "content": []map[string]interface{}{
+						{"type": "text", "text": "好"},
+						{"type": "tool_use", "id": "c1", "name": "shell", "input": map[string]interface{}{"input": "ls -a"}},
+						{"type": "tool_use", "id": "c2", "name": "read", "input": map[string]interface{}{"path": "a.go"}},
+					}},
+					{"role": "user", "content": []map[string]interface{}{
+						{"type": "tool_result", "tool_use_id": "c1", "content": "a.go"},
+						{"type": "tool_result", "tool_use_id": "c2", "content": "no", "is_error": true},
+					}},
+					{"role": "assistant", "content": []map[string]interface{}{{"type": "text", "text": "完成"}}},
+				},
+			},
+		},
+		{
+			name: "去掉开头的 assistant 和末尾的 user，相邻同角色消息合并",
+			messages: []ChatMessage{
+				{Role: "assistant", Text: "你好"},
+				{Role: "user", Text: "a"},
+				{Role: "user", Text: "b"},
+				{Role: "assistant", Text: "c"},
+				{Role: "user", Text: "没有回复"},
+			},
+			want: map[string]interface{}{
+				"messages": []map[string]interface{}{
+					{"role": "user", "content": []map[string]interface{}{{"type": "text", "text": "a"}, {"type": "text", "text": "b"}}},
+					{"role": "assistant", "content": []map[string]interface{}{{"type": "text", "text": "c"}}},
+				},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := toAnthropic(tt.messages); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("toAnthropic() = %+v，期望 %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSplitMessages(t *testing.T) {
+	system := ChatMessage{Role: "system", Text: "s"}
+	user := func(text string) ChatMessage { return ChatMessage{Role: "user", Text: text} }
+	reply := func(text string) ChatMessage { return ChatMessage{Role: "assistant", Text: text} }
+	messages := []ChatMessage{system, user("1"), reply("1"), user("2"), reply("2"), reply("2b"), user("3"), reply("3")}
+
+	tests := []struct {
+		name        string
+		maxMessages int
+		want        [][]ChatMessage
+	}{
+		{"不限制时不拆分", 0, [][]ChatMessage{messages}},
+		{"未超过上限时不拆分", 8, [][]ChatMessage{messages}},
+		{
+			"按用户轮次拆分，每段带系统提示",
+			5,
+			[][]ChatMessage{
+				{system, user("1"), reply("1")},
+				{system, user("2"), reply("2"), reply("2b")},
+				{system, user("3"), reply("3")},
+			},
+		},
+		{
+			"单个轮次超过上限时单独成段",
+			2,
+			[][]ChatMessage{
+				{system, user("1"), reply("1")},
+				{system, user("2"), reply("2"), reply("2b")},
+				{system, user("3"), reply("3")},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := splitMessages(messages, tt.maxMessages); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("splitMessages(%d) = %+v，期望 %+v", tt.maxMessages, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,778 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/fs"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Gemini CLI 数据结构定义
+// 支持三种文件：
+//   - /chat save 保存的 checkpoint-<tag>.json：Content 数组
+//   - 开启 --checkpointing 后的文件修改检查点：{history, clientHistory, toolCall, commitHash, filePath}
+//   - 会话记录 chats/session-*.json：{sessionId, projectHash, startTime, lastUpdated, messages}
+type GeminiContent struct {
+	Role  string       `json:"role"` // user / model
+	Parts []GeminiPart `json:"parts"`
+}
+
+type GeminiPart struct {
+	Text             string                  `json:"text"`
+	Thought          bool                    `json:"thought"`
+	FunctionCall     *GeminiFunctionCall     `json:"functionCall"`
+	FunctionResponse *GeminiFunctionResponse `json:"functionResponse"`
+}
+
+type GeminiFunctionCall struct {
+	ID   string                 `json:"id"`
+	Name string                 `json:"name"`
+	Args map[string]interface{} `json:"args"`
+}
+
+type GeminiFunctionResponse struct {
+	ID       string                 `json:"id"`
+	Name     string                 `json:"name"`
+	Response map[string]interface{} `json:"response"`
+}
+
+type GeminiCheckpoint struct {
+	History       json.RawMessage     `json:"history"`
+	ClientHistory []GeminiContent     `json:"clientHistory"`
+	ToolCall      *GeminiFunctionCall `json:"toolCall"`
+	CommitHash    string              `json:"commitHash"`
+	FilePath      string              `json:"filePath"`
+}
+
+type GeminiSession struct {
+	SessionID   string                 `json:"sessionId"`
+	ProjectHash string                 `json:"projectHash"`
+	StartTime   string                 `json:"startTime"`
+	LastUpdated string                 `json:"lastUpdated"`
+	Messages    []GeminiSessionMessage `json:"messages"`
+}
+
+type GeminiSessionMessage struct {
+	ID        string           `json:"id"`
+	Timestamp string           `json:"timestamp"`
+	Type      string           `json:"type"`    // user / gemini / info / error / warning
+	Content   json.RawMessage  `json:"content"` // 字符串或 parts 数组
+	ToolCalls []GeminiToolCall `json:"toolCalls"`
+	Thoughts  []GeminiThought  `json:"thoughts"`
+	Tokens    map[string]int   `json:"tokens"`
+	Model     string           `json:"model"`
+}
+
+type GeminiToolCall struct {
+	ID        string                 `json:"id"`
+	Name      string                 `json:"name"`
+	Args      map[string]interface{} `json:"args"`
+	Result    []GeminiPart           `json:"result"`
+	Status    string                 `json:"status"`
+	Timestamp string                 `json:"timestamp"`
+}
+
+type GeminiThought struct {
+	Subject     string `json:"subject"`
+	Description string `json:"description"`
+}
+
+// 解析后的对话：统一为带 id 的消息列表
+type GeminiConversation struct {
+	SessionID string
+	Metadata  map[string]interface{}
+	Messages  []GeminiMessage
+}
+
+type GeminiMessage struct {
+	ID        string
+	Role      string
+	Parts     []GeminiPart
+	Thoughts  []GeminiThought
+	ToolCalls []GeminiToolCall // 会话记录中调用与结果已经配对
+	Timestamp string
+	Metadata  map[string]interface{}
+}
+
+// 输出节点结构（与GPT格式保持一致）
+type OutputNode struct {
+	ID          string                 `json:"id"`
+	ParentID    string                 `json:"parent_id"`
+	ChildID     string                 `json:"child_id"`
+	Role        string                 `json:"role"`
+	ContentType string                 `json:"content_type"`
+	Content     string                 `json:"content,omitempty"`
+	ToolData    map[string]interface{} `json:"tool_data,omitempty"`
+	Blocks      []ContentBlock         `json:"blocks,omitempty"` // 多个内容块时按原始顺序保留
+	Metadata    map[string]interface{} `json:"metadata,omitempty"`
+	CreateTime  *string                `json:"create_time"`
+}
+
+// 内容块结构（text、thinking、tool_use）
+type ContentBlock struct {
+	Type     string                 `json:"type"`
+	Text     string                 `json:"text,omitempty"`
+	ToolData map[string]interface{} `json:"tool_data,omitempty"`
+}
+
+// 输出文件结构
+type OutputFile struct {
+	RoundCount int                    `json:"round_count"` // 对话轮数（user消息数量）
+	TotalCount int                    `json:"total_count"` // 总消息数量
+	Metadata   map[string]interface{} `json:"metadata,omitempty"`
+	Data       []OutputNode           `json:"data"`
+}
+
+// 单个文件的处理结果
+type FileResult struct {
+	InputFile  string
+	OutputFile string
+	Count      int
+	Skipped    bool // 不是 Gemini 对话文件或没有有效消息
+	Err        error
+}
+
+func main() {
+	// 解析命令行参数
+	inputFile := flag.String("input", "", "输入的JSON文件路径")
+	inputDir := flag.String("dir", "", "Gemini 数据目录（如 ~/.gemini/tmp），递归解析其中所有 .json 文件")
+	outputDir := flag.String("output", "parsed/gemini/conversation", "输出目录")
+	workers := flag.Int("workers", runtime.NumCPU(), "目录模式下的并发解析数")
+	flag.Parse()
+
+	if *inputFile == "" && *inputDir == "" {
+		fmt.Println("错误: 必须指定输入文件或目录")
+		fmt.Println("用法: gemini_conversation_parse -input <file> [-output <dir>]")
+		fmt.Println("      gemini_conversation_parse -dir <gemini_tmp_dir> [-output <dir>] [-workers <n>]")
+		os.Exit(1)
+	}
+	if *inputFile != "" && *inputDir != "" {
+		fmt.Println("错误: -input 和 -dir 不能同时指定")
+		os.Exit(1)
+	}
+
+	// 创建输出目录
+	if err := os.MkdirAll(*outputDir, 0755); err != nil {
+		fmt.Printf("创建输出目录失败: %v\n", err)
+		os.Exit(1)
+	}
+
+	parse := func(file string) FileResult {
+		return parseFile(file, *outputDir)
+	}
+
+	// 目录模式
+	if *inputDir != "" {
+		files, err := collectSessionFiles(*inputDir)
+		if err != nil {
+			fmt.Printf("遍历目录失败: %v\n", err)
+			os.Exit(1)
+		}
+		if len(files) == 0 {
+			fmt.Println("警告: 目录中没有 .json 文件")
+			os.Exit(0)
+		}
+
+		fmt.Printf("共找到 %d 个文件，并发数 %d\n", len(files), *workers)
+		results := runBatch(files, *workers, parse)
+		if failed := printResults(results); failed > 0 {
+			os.Exit(1)
+		}
+		return
+	}
+
+	// 单文件模式
+	result := parse(*inputFile)
+	if result.Err != nil {
+		fmt.Printf("处理对话失败: %v\n", result.Err)
+		os.Exit(1)
+	}
+	if result.Skipped {
+		fmt.Println("警告: 文件不是 Gemini 对话或没有有效的消息")
+		os.Exit(0)
+	}
+
+	fmt.Printf("已生成: %s (共 %d 条消息)\n", result.OutputFile, result.Count)
+	fmt.Println("处理完成")
+}
+
+// parseFile 解析单个文件并写入输出目录
+func parseFile(inputFile string, outputDir string) FileResult {
+	result := FileResult{InputFile: inputFile}
+
+	conv, err := readConversation(inputFile)
+	if err != nil {
+		result.Err = fmt.Errorf("读取文件失败: %v", err)
+		return result
+	}
+
+	if conv == nil || len(conv.Messages) == 0 {
+		result.Skipped = true
+		return result
+	}
+
+	result.OutputFile, result.Count, result.Err = processConversation(conv, outputDir)
+	return result
+}
+
+// collectSessionFiles 递归收集目录下的 .json 文件（按路径排序）
+func collectSessionFiles(dir string) ([]string, error) {
+	var files []string
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.IsDir() && strings.HasSuffix(d.Name(), ".json") {
+			files = append(files, path)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Strings(files)
+	return files, nil
+}
+
+// runBatch 使用固定数量的 worker 并发解析，结果按输入顺序返回
+func runBatch(files []string, workers int, parse func(string) FileResult) []FileResult {
+	if workers < 1 {
+		workers = 1
+	}
+
+	results := make([]FileResult, len(files))
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				results[i] = parse(files[i])
+			}
+		}()
+	}
+
+	for i := range files {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+
+	return results
+}
+
+// printResults 输出每个文件的处理结果和汇总，返回失败数量
+func printResults(results []FileResult) int {
+	success, skipped, failed := 0, 0, 0
+	for _, result := range results {
+		switch {
+		case result.Err != nil:
+			failed++
+			fmt.Printf("[失败] %s: %v\n", result.InputFile, result.Err)
+		case result.Skipped:
+			skipped++
+			fmt.Printf("[跳过] %s: 不是 Gemini 对话或没有有效的消息\n", result.InputFile)
+		default:
+			success++
+			fmt.Printf("[成功] %s -> %s (共 %d 条消息)\n", result.InputFile, result.OutputFile, result.Count)
+		}
+	}
+
+	fmt.Printf("\n处理完成: 成功 %d, 跳过 %d, 失败 %d, 共 %d\n", success, skipped, failed, len(results))
+	return failed
+}
+
+// readConversation 识别文件格式并统一转换为 GeminiConversation
+// 无法识别的 JSON（如 logs.json、settings.json）返回 nil
+func readConversation(filename string) (*GeminiConversation, error) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	// checkpoint-<tag>.json 只在项目目录内唯一，位于 ~/.gemini/tmp 下时加上项目目录名（项目哈希）避免不同项目的同名检查点互相覆盖
+	defaultID := strings.TrimSuffix(filepath.Base(filename), ".json")
+	if projectDir := projectDirFromPath(filename); projectDir != "" {
+		defaultID = projectDir + "-" + defaultID
+	}
+	trimmed := strings.TrimSpace(string(data))
+
+	// /chat save：Content 数组
+	if strings.HasPrefix(trimmed, "[") {
+		var contents []GeminiContent
+		if err := json.Unmarshal(data, &contents); err != nil {
+			return nil, nil
+		}
+		if !isContentHistory(contents) {
+			return nil, nil
+		}
+		return &GeminiConversation{
+			SessionID: defaultID,
+			Messages:  contentMessages(defaultID, contents),
+		}, nil
+	}
+
+	var probe map[string]json.RawMessage
+	if err := json.Unmarshal(data, &probe); err != nil {
+		return nil, fmt.Errorf("解析JSON失败: %v", err)
+	}
+
+	// 会话记录
+	if _, ok := probe["messages"]; ok {
+		var session GeminiSession
+		if err := json.Unmarshal(data, &session); err != nil {
+			return nil, fmt.Errorf("解析会话记录失败: %v", err)
+		}
+		return sessionConversation(session, defaultID), nil
+	}
+
+	// 文件修改检查点
+	_, hasHistory := probe["history"]
+	_, hasClientHistory := probe["clientHistory"]
+	if hasHistory || hasClientHistory {
+		var checkpoint GeminiCheckpoint
+		if err := json.Unmarshal(data, &checkpoint); err != nil {
+			return nil, fmt.Errorf("解析检查点失败: %v", err)
+		}
+		return checkpointConversation(checkpoint, defaultID), nil
+	}
+
+	return nil, nil
+}
+
+// projectDirFromPath 返回文件所属的项目目录名（~/.gemini/tmp/<project_hash>），不在该目录下时返回空
+// 检查点位于项目目录或其 checkpoints 子目录，会话记录位于 chats 子目录；
+// 其他位置的文件（如下载的 chat.json）不加前缀，移动文件不会改变节点ID
+func projectDirFromPath(filename string) string {
+	absPath, err := filepath.Abs(filename)
+	if err != nil {
+		return ""
+	}
+	dir := filepath.Dir(absPath)
+	if name := filepath.Base(dir); name == "checkpoints" || name == "chats" {
+		dir = filepath.Dir(dir)
+	}
+	tmpDir := filepath.Dir(dir)
+	if filepath.Base(tmpDir) != "tmp" || filepath.Base(filepath.Dir(tmpDir)) != ".gemini" {
+		return ""
+	}
+	return filepath.Base(dir)
+}
+
+// isContentHistory 判断是否为 Gemini API 的 Content 数组
+func isContentHistory(contents []GeminiContent) bool {
+	if len(contents) == 0 {
+		return false
+	}
+	for _, content := range contents {
+		if content.Role == "" {
+			return false
+		}
+	}
+	return true
+}
+
+// contentMessages 将 Content 数组转换为消息列表
+// Content 没有 id 和时间，按位置生成确定性 id，保证重复解析结果一致
+func contentMessages(sessionID string, contents []GeminiContent) []GeminiMessage {
+	messages := make([]GeminiMessage, 0, len(contents))
+	for i, content := range contents {
+		messages = append(messages, GeminiMessage{
+			ID:    fmt.Sprintf("%s-%d", sessionID, i),
+			Role:  content.Role,
+			Parts: content.Parts,
+		})
+	}
+	return messages
+}
+
+func checkpointConversation(checkpoint GeminiCheckpoint, defaultID string) *GeminiConversation {
+	// clientHistory 是发送给模型的 Content 数组；旧版本只有 history
+	contents := checkpoint.ClientHistory
+	if len(contents) == 0 {
+		var history []GeminiContent
+		if err := json.Unmarshal(checkpoint.History, &history); err == nil && isContentHistory(history) {
+			contents = history
+		}
+	}
+	if len(contents) == 0 {
+		return nil
+	}
+
+	metadata := make(map[string]interface{})
+	if checkpoint.ToolCall != nil {
+		metadata["tool_call"] = map[string]interface{}{
+			"name": checkpoint.ToolCall.Name,
+			"args": checkpoint.ToolCall.Args,
+		}
+	}
+	if checkpoint.CommitHash != "" {
+		metadata["git_commit"] = checkpoint.CommitHash
+	}
+	if checkpoint.FilePath != "" {
+		metadata["file_path"] = checkpoint.FilePath
+	}
+
+	return &GeminiConversation{
+		SessionID: defaultID,
+		Metadata:  metadata,
+		Messages:  contentMessages(defaultID, contents),
+	}
+}
+
+func sessionConversation(session GeminiSession, defaultID string) *GeminiConversation {
+	conv := &GeminiConversation{
+		SessionID: session.SessionID,
+		Metadata:  make(map[string]interface{}),
+	}
+	if conv.SessionID == "" {
+		conv.SessionID = defaultID
+	}
+
+	conv.Metadata["session_id"] = conv.SessionID
+	if session.ProjectHash != "" {
+		conv.Metadata["project_hash"] = session.ProjectHash
+	}
+	if session.StartTime != "" {
+		conv.Metadata["start_time"] = session.StartTime
+	}
+	if session.LastUpdated != "" {
+		conv.Metadata["last_updated"] = session.LastUpdated
+	}
+
+	totalTokens := make(map[string]int)
+	var models []string
+	for i, msg := range session.Messages {
+		message := GeminiMessage{
+			ID:        msg.ID,
+			Role:      msg.Type,
+			Thoughts:  msg.Thoughts,
+			ToolCalls: msg.ToolCalls,
+			Timestamp: msg.Timestamp,
+		}
+		if message.ID == "" {
+			message.ID = fmt.Sprintf("%s-%d", conv.SessionID, i)
+		}
+
+		// content 可能是字符串或 parts 数组
+		var text string
+		if err := json.Unmarshal(msg.Content, &text); err == nil {
+			if text != "" {
+				message.Parts = []GeminiPart{{Text: text}}
+			}
+		} else {
+			json.Unmarshal(msg.Content, &message.Parts)
+		}
+
+		if msg.Model != "" || len(msg.Tokens) > 0 {
+			message.Metadata = make(map[string]interface{})
+			if msg.Model != "" {
+				message.Metadata["model"] = msg.Model
+				if !containsString(models, msg.Model) {
+					models = append(models, msg.Model)
+				}
+			}
+			if len(msg.Tokens) > 0 {
+				message.Metadata["tokens"] = msg.Tokens
+				for key, value := range msg.Tokens {
+					totalTokens[key] += value
+				}
+			}
+		}
+
+		conv.Messages = append(conv.Messages, message)
+	}
+
+	if len(models) > 0 {
+		conv.Metadata["models"] = models
+	}
+	if len(totalTokens) > 0 {
+		conv.Metadata["tokens"] = totalTokens
+	}
+	return conv
+}
+
+// onlyToolResults 判断内容块是否只有 tool_result
+func onlyToolResults(blocks []ContentBlock) bool {
+	for _, block := range blocks {
+		if block.Type != "tool_result" {
+			return false
+		}
+	}
+	return len(blocks) > 0
+}
+
+func containsString(list []string, value string) bool {
+	for _, item := range list {
+		if item == value {
+			return true
+		}
+	}
+	return false
+}
+
+func processConversation(conv *GeminiConversation, outputDir string) (string, int, error) {
+	nodes := []OutputNode{}
+	pendingCalls := []map[string]interface{}{} // 尚未收到结果的 functionCall
+	parentID := ""
+
+	for _, msg := range conv.Messages {
+		var blocks []ContentBlock
+
+		for _, thought := range msg.Thoughts {
+			text := thought.Description
+			if thought.Subject != "" {
+				text = thought.Subject + ": " + text
+			}
+			blocks = append(blocks, ContentBlock{Type: "thinking", Text: text})
+		}
+
+		for _, part := range msg.Parts {
+			switch {
+			case part.FunctionCall != nil:
+				toolData := map[string]interface{}{
+					"name":  part.FunctionCall.Name,
+					"input": part.FunctionCall.Args,
+				}
+				if part.FunctionCall.ID != "" {
+					toolData["id"] = part.FunctionCall.ID
+				}
+				pendingCalls = append(pendingCalls, toolData)
+				blocks = append(blocks, ContentBlock{Type: "tool_use", ToolData: toolData})
+			case part.FunctionResponse != nil:
+				// functionResponse 合并到对应的 functionCall，找不到时在原位置保留为 tool_result 块
+				output, isError := functionResponseText(part.FunctionResponse)
+				if idx := matchCall(pendingCalls, part.FunctionResponse); idx >= 0 {
+					pendingCalls[idx]["output"] = output
+					pendingCalls[idx]["is_error"] = isError
+					pendingCalls = append(pendingCalls[:idx], pendingCalls[idx+1:]...)
+				} else {
+					blocks = append(blocks, ContentBlock{Type: "tool_result", ToolData: map[string]interface{}{
+						"tool_use_id": part.FunctionResponse.ID,
+						"name":        part.FunctionResponse.Name,
+						"output":      output,
+						"is_error":    isError,
+					}})
+				}
+			case part.Thought:
+				blocks = append(blocks, ContentBlock{Type: "thinking", Text: part.Text})
+			case part.Text != "":
+				blocks = append(blocks, ContentBlock{Type: "text", Text: part.Text})
+			}
+		}
+
+		// 会话记录中的 toolCalls 已带有执行结果
+		for _, call := range msg.ToolCalls {
+			toolData := map[string]interface{}{
+				"id":    call.ID,
+				"name":  call.Name,
+				"input": call.Args,
+			}
+			if len(call.Result) > 0 || call.Status != "" {
+				var outputs []string
+				isError := call.Status == "error" || call.Status == "cancelled"
+				for _, part := range call.Result {
+					if part.FunctionResponse != nil {
+						output, partError := functionResponseText(part.FunctionResponse)
+						outputs = append(outputs, output)
+						isError = isError || partError
+					} else if part.Text != "" {
+						outputs = append(outputs, part.Text)
+					}
+				}
+				toolData["output"] = strings.Join(outputs, "\n")
+				toolData["is_error"] = isError
+				toolData["status"] = call.Status
+			}
+			blocks = append(blocks, ContentBlock{Type: "tool_use", ToolData: toolData})
+		}
+
+		role := normalizeRole(msg.Role)
+
+		// 只有找不到对应调用的 functionResponse 时作为 tool 节点
+		if onlyToolResults(blocks) {
+			role = "tool"
+		}
+		contentType, content, toolData, blocks := summarizeBlocks(blocks)
+
+		// 只携带已合并的 functionResponse 的消息不单独输出
+		if content == "" && toolData == nil && blocks == nil {
+			continue
+		}
+
+		// info / error 等提示消息保留原始类型
+		if role == "system" && contentType == "text" {
+			contentType = msg.Role
+		}
+
+		node := OutputNode{
+			ID:          msg.ID,
+			ParentID:    parentID,
+			Role:        role,
+			ContentType: contentType,
+			Content:     content,
+			ToolData:    toolData,
+			Blocks:      blocks,
+			Metadata:    msg.Metadata,
+		}
+		if msg.Timestamp != "" {
+			timestamp := msg.Timestamp
+			node.CreateTime = &timestamp
+		}
+
+		nodes = append(nodes, node)
+		parentID = msg.ID
+	}
+
+	if len(nodes) == 0 {
+		return "", 0, fmt.Errorf("没有有效的内容节点")
+	}
+	linkChildren(nodes)
+
+	// 生成输出文件名
+	sessionID := sanitizeFilename(conv.SessionID)
+	outputFile := filepath.Join(outputDir, sessionID+".json")
+
+	// 计算统计信息
+	roundCount := 0
+	for _, node := range nodes {
+		if node.Role == "user" {
+			roundCount++
+		}
+	}
+
+	// 构建输出文件结构
+	output := OutputFile{
+		RoundCount: roundCount,
+		TotalCount: len(nodes),
+		Metadata:   conv.Metadata,
+		Data:       nodes,
+	}
+
+	// 序列化为JSON
+	jsonData, err := json.MarshalIndent(output, "", "  ")
+	if err != nil {
+		return "", 0, fmt.Errorf("序列化JSON失败: %v", err)
+	}
+
+	// 写入文件
+	if err := ioutil.WriteFile(outputFile, jsonData, 0644); err != nil {
+		return "", 0, fmt.Errorf("写入文件失败: %v", err)
+	}
+
+	return outputFile, len(nodes), nil
+}
+
+// normalizeRole 统一角色名：model/gemini -> assistant，提示类消息 -> system
+func normalizeRole(role string) string {
+	switch role {
+	case "user":
+		return "user"
+	case "model", "gemini":
+		return "assistant"
+	default:
+		return "system"
+	}
+}
+
+// matchCall 优先按 id 匹配 functionCall，没有 id 时取最早的同名未完成调用
+func matchCall(pendingCalls []map[string]interface{}, response *GeminiFunctionResponse) int {
+	if response.ID != "" {
+		for i, call := range pendingCalls {
+			if call["id"] == response.ID {
+				return i
+			}
+		}
+	}
+	for i, call := range pendingCalls {
+		if call["name"] == response.Name {
+			return i
+		}
+	}
+	return -1
+}
+
+// functionResponseText 提取 functionResponse 的输出文本和是否出错
+// 工具结果通常为 {"output": "..."}，失败时为 {"error": "..."}
+func functionResponseText(response *GeminiFunctionResponse) (string, bool) {
+	if errVal, ok := response.Response["error"]; ok && errVal != nil {
+		if str, ok := errVal.(string); ok {
+			return str, true
+		}
+		data, _ := json.Marshal(errVal)
+		return string(data), true
+	}
+	if output, ok := response.Response["output"].(string); ok {
+		return output, false
+	}
+	if len(response.Response) == 0 {
+		return "", false
+	}
+	data, _ := json.Marshal(response.Response)
+	return string(data), false
+}
+
+// summarizeBlocks 根据内容块生成节点的 content_type、content 和 tool_data
+// 单个内容块时保持原有的扁平结构；多个内容块时额外保留 blocks，类型不一致时 content_type 为 multipart
+func summarizeBlocks(blocks []ContentBlock) (string, string, map[string]interface{}, []ContentBlock) {
+	if len(blocks) == 0 {
+		return "text", "", nil, nil
+	}
+
+	contentType := blocks[0].Type
+	var textParts []string
+	for _, block := range blocks {
+		if block.Type != contentType {
+			contentType = "multipart"
+		}
+		if block.Text != "" {
+			textParts = append(textParts, block.Text)
+		}
+	}
+	content := strings.Join(textParts, "\n")
+
+	if len(blocks) == 1 {
+		return contentType, content, blocks[0].ToolData, nil
+	}
+	return contentType, content, nil, blocks
+}
+
+// linkChildren 根据 parent 关系补全 child_id
+func linkChildren(nodes []OutputNode) {
+	firstChild := make(map[string]string)
+	for _, node := range nodes {
+		if node.ParentID == "" {
+			continue
+		}
+		if _, exists := firstChild[node.ParentID]; !exists {
+			firstChild[node.ParentID] = node.ID
+		}
+	}
+	for i := range nodes {
+		nodes[i].ChildID = firstChild[nodes[i].ID]
+	}
+}
+
+func sanitizeFilename(name string) string {
+	// 替换文件名中的非法字符
+	name = strings.ReplaceAll(name, "/", "_")
+	name = strings.ReplaceAll(name, "\\", "_")
+	name = strings.ReplaceAll(name, ":", "_")
+	name = strings.ReplaceAll(name, "*", "_")
+	name = strings.ReplaceAll(name, "?", "_")
+	name = strings.ReplaceAll(name, "\"", "_")
+	name = strings.ReplaceAll(name, "<", "_")
+	name = strings.ReplaceAll(name, ">", "_")
+	name = strings.ReplaceAll(name, "|", "_")
+	return name
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// 运行方式（scripts 目录下各工具都是独立的 main 包，需要指定文件）:
+//
+//	go test -v gemini_conversation_parse.go gemini_conversation_parse_test.go
+
+func TestCheckpointSessionIDIncludesProject(t *testing.T) {
+	root := t.TempDir()
+	content := `[{"role":"user","parts":[{"text":"你好"}]},{"role":"model","parts":[{"text":"你好！"}]}]`
+
+	tests := []struct {
+		path     string
+		expected string
+	}{
+		{filepath.Join(".gemini", "tmp", "hash-a", "checkpoint-work.json"), "hash-a-checkpoint-work"},
+		{filepath.Join(".gemini", "tmp", "hash-b", "checkpoint-work.json"), "hash-b-checkpoint-work"},
+		{filepath.Join(".gemini", "tmp", "hash-c", "checkpoints", "edit.json"), "hash-c-edit"},
+		// 不在 ~/.gemini/tmp 下的文件只用文件名，移动位置不改变ID
+		{filepath.Join("Downloads", "chat.json"), "chat"},
+		{filepath.Join("backup", "hash-d", "checkpoint-work.json"), "checkpoint-work"},
+	}
+	for _, tt := range tests {
+		path := filepath.Join(root, tt.path)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		conv, err := readConversation(path)
+		if err != nil || conv == nil {
+			t.Fatalf("readConversation(%s) 失败: %v", tt.path, err)
+		}
+		if conv.SessionID != tt.expected {
+			t.Errorf("%s 的会话ID为 %q，期望 %q", tt.path, conv.SessionID, tt.expected)
+		}
+	}
+}
+
+func TestUnmatchedResponseKeepsOrder(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "chat.json")
+	content := `[{"role":"user","parts":[{"functionResponse":{"id":"x1","name":"read_file","response":{"output":"one"}}},{"text":"继续"}]},{"role":"model","parts":[{"text":"好的"}]}]`
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	conv, err := readConversation(path)
+	if err != nil || conv == nil {
+		t.Fatalf("readConversation 失败: %v", err)
+	}
+	outputFile, _, err := processConversation(conv, dir)
+	if err != nil {
+		t.Fatalf("processConversation 失败: %v", err)
+	}
+	data, err := os.ReadFile(outputFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var output OutputFile
+	if err := json.Unmarshal(data, &output); err != nil {
+		t.Fatal(err)
+	}
+
+	node := output.Data[0]
+	if node.Role != "user" || len(node.Blocks) != 2 || node.Blocks[0].Type != "tool_result" || node.Blocks[1].Text != "继续" {
+		t.Errorf("找不到调用的结果应保留在原位置，实际 %s / %+v", node.Role, node.Blocks)
+	}
+}
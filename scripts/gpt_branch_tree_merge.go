@@ -1,24 +1,36 @@
 package main
 
 import (
+	"crypto/sha1"
+	"encoding/hex"
 	"encoding/json"
 	"flag"
 	"fmt"
 	"io/ioutil"
 	"os"
+	"path/filepath"
+	"sort"
 	"strings"
 )
 
-// 对话节点结构（来自gpt_conversation_parse.go输出）
+// 对话节点结构（来自gpt_conversation_parse.go输出，按内容合并时也兼容Codex、Claude Code等来源）
 type ConversationNode struct {
-	ID          string   `json:"id"`
-	ParentID    string   `json:"parent_id"`
-	ChildID     string   `json:"child_id"`
-	Role        string   `json:"role"`
-	ContentType string   `json:"content_type"`
-	Content     string   `json:"content"`
-	Images      []string `json:"images,omitempty"`
-	CreateTime  *float64 `json:"create_time"`
+	ID          string                 `json:"id"`
+	ParentID    string                 `json:"parent_id"`
+	ChildID     string                 `json:"child_id"`
+	Role        string                 `json:"role"`
+	ContentType string                 `json:"content_type"`
+	Content     string                 `json:"content"`
+	Images      []string               `json:"images,omitempty"`
+	ToolData    map[string]interface{} `json:"tool_data,omitempty"`
+	Blocks      []ContentBlock         `json:"blocks,omitempty"`
+	CreateTime  interface{}            `json:"create_time"` // GPT 为 Unix 秒，其他来源为 RFC3339 字符串
+}
+
+type ContentBlock struct {
+	Type     string                 `json:"type"`
+	Text     string                 `json:"text"`
+	ToolData map[string]interface{} `json:"tool_data,omitempty"`
 }
 
 // 对话文件结构
@@ -32,6 +44,14 @@ type TreeNode struct {
 	Parent        string   `json:"parent"`
 	Children      []string `json:"children"`
 	Conversations []string `json:"conversations,omitempty"`
+	Hash          string   `json:"hash,omitempty"`    // 按内容合并时的前缀内容哈希
+	Aliases       []string `json:"aliases,omitempty"` // 按内容合并时，其他对话中内容相同的原始节点ID
+
+	// 嵌入的消息内容（-embed），查看时无需再读取对话文件
+	Role        string      `json:"role,omitempty"`
+	ContentType string      `json:"content_type,omitempty"`
+	Content     string      `json:"content,omitempty"`
+	CreateTime  interface{} `json:"create_time,omitempty"`
 }
 
 // 树结构
@@ -39,17 +59,81 @@ type Tree struct {
 	Root          string              `json:"root"`
 	Conversations []string            `json:"conversations"`
 	Nodes         map[string]TreeNode `json:"nodes"`
+	Strategy      string              `json:"strategy,omitempty"` // 合并策略，按内容合并时为 content
+	Embed         string              `json:"embed,omitempty"`    // 节点嵌入内容的方式: snippet 或 full
+}
+
+// 按内容对齐的结果，节点使用内容相同的节点中最先出现的原始ID作为树中的ID
+type ContentAlignment struct {
+	Hashes  map[string]string   // 树节点ID -> 前缀内容哈希
+	Aliases map[string][]string // 树节点ID -> 其他对话中内容相同的原始节点ID
+}
+
+// 自动发现模式输出的索引（tree_index.json），记录每棵树包含的对话和每个对话所属的树
+type TreeIndex struct {
+	Trees         map[string]TreeIndexEntry `json:"trees"`
+	Conversations map[string]string         `json:"conversations"`
+	Failed        []TreeIndexFailure        `json:"failed,omitempty"` // 无法生成树的分组
+}
+
+type TreeIndexEntry struct {
+	Conversations []string `json:"conversations"`
+	NodeCount     int      `json:"node_count"`
+}
+
+type TreeIndexFailure struct {
+	Conversations []string `json:"conversations"`
+	Error         string   `json:"error"`
 }
 
+const treeIndexFile = "tree_index.json"
+
 func main() {
 	// 解析命令行参数
 	inputFiles := flag.String("input", "", "输入的JSON文件路径，多个文件用逗号分隔")
 	outputDir := flag.String("output", "parsed/gpt/tree", "输出目录")
+	discover := flag.Bool("discover", false, "自动发现模式：扫描对话目录，将共享消息的对话分组并生成全部树")
+	conversationDir := flag.String("dir", "parsed/gpt/conversation", "对话目录（自动发现模式扫描，rebuild 操作按对话ID读取）")
+	strategy := flag.String("strategy", "id", "合并策略: id（按消息ID，适用于GPT）或 content（按消息及其前缀的内容哈希，适用于Codex、Claude Code）")
+	action := flag.String("action", "add", "树操作: add（合并对话）、remove（移除对话）、rebuild（按成员对话重新生成）")
+	removeIDs := flag.String("ids", "", "remove 操作要移除的对话ID，多个用逗号分隔")
+	render := flag.String("render", "", "可视化导出格式: dot、mermaid 或 ascii（输入为树文件，节点内容从 -dir 中的对话文件读取）")
+	snippetLen := flag.Int("snippet", 40, "可视化时节点内容摘要的最大字符数")
+	diffIDs := flag.String("diff", "", "比较树中两个对话的分叉，格式: <对话ID>,<对话ID>（输入为树文件，内容从 -dir 读取）")
+	diffWidth := flag.Int("width", 120, "分支差异并排显示的总宽度")
+	showStats := flag.Bool("stats", false, "输出树的统计信息：深度、分叉、分支因子分布、各分支长度及分叉后轮数（输入为树文件，内容从 -dir 读取）")
+	jsonOutput := flag.Bool("json", false, "统计信息以JSON格式输出")
+	embed := flag.String("embed", "", "在树节点中嵌入角色、内容类型、内容和时间: snippet（摘要，长度由 -snippet 指定）或 full（全文）")
 	flag.Parse()
 
-	if *inputFiles == "" {
+	if *strategy != "id" && *strategy != "content" {
+		fmt.Printf("错误: 不支持的合并策略: %s\n", *strategy)
+		os.Exit(1)
+	}
+	if *action != "add" && *action != "remove" && *action != "rebuild" {
+		fmt.Printf("错误: 不支持的树操作: %s\n", *action)
+		os.Exit(1)
+	}
+	if *embed != "" && *embed != "snippet" && *embed != "full" {
+		fmt.Printf("错误: 不支持的内容嵌入方式: %s\n", *embed)
+		os.Exit(1)
+	}
+	if *render != "" && *render != "dot" && *render != "mermaid" && *render != "ascii" {
+		fmt.Printf("错误: 不支持的可视化格式: %s\n", *render)
+		os.Exit(1)
+	}
+
+	if *inputFiles == "" && !*discover {
 		fmt.Println("错误: 必须指定输入文件")
 		fmt.Println("用法: gpt_branch_tree_merge -input <file1,file2,...> [-output <dir>]")
+		fmt.Println("      gpt_branch_tree_merge -discover [-dir parsed/gpt/conversation] [-output <dir>]")
+		fmt.Println("      gpt_branch_tree_merge -embed snippet|full -input <file1,file2,...> [-snippet 40] [-output <dir>]")
+		fmt.Println("      gpt_branch_tree_merge -strategy content -input <file1,file2,...> [-output <dir>]")
+		fmt.Println("      gpt_branch_tree_merge -action remove -input <tree.json> -ids <conv1,conv2> [-output <dir>]")
+		fmt.Println("      gpt_branch_tree_merge -action rebuild -input <tree.json> [-dir parsed/gpt/conversation] [-output <dir>]")
+		fmt.Println("      gpt_branch_tree_merge -render dot|mermaid|ascii -input <tree.json> [-dir parsed/gpt/conversation] [-output <dir>]")
+		fmt.Println("      gpt_branch_tree_merge -diff <conv1,conv2> -input <tree.json> [-dir parsed/gpt/conversation]")
+		fmt.Println("      gpt_branch_tree_merge -stats [-json] -input <tree.json> [-dir parsed/gpt/conversation]")
 		os.Exit(1)
 	}
 
@@ -59,13 +143,21 @@ func main() {
 		os.Exit(1)
 	}
 
+	if *discover {
+		if err := discoverTrees(*conversationDir, *outputDir, *strategy, *embed, *snippetLen); err != nil {
+			fmt.Printf("自动发现失败: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
 	// 分割文件列表
 	fileList := strings.Split(*inputFiles, ",")
 	for i := range fileList {
 		fileList[i] = strings.TrimSpace(fileList[i])
 	}
 
-	if len(fileList) < 2 {
+	if *action == "add" && *render == "" && *diffIDs == "" && !*showStats && len(fileList) < 2 {
 		fmt.Println("错误: 至少需要两个输入文件进行合并")
 		os.Exit(1)
 	}
@@ -88,7 +180,9 @@ func main() {
 			fmt.Printf("解析树结构失败: %v\n", err)
 			os.Exit(1)
 		}
-		fmt.Printf("检测到已有树结构，包含 %d 个对话\n", len(tree.Conversations))
+		if !*jsonOutput {
+			fmt.Printf("检测到已有树结构，包含 %d 个对话\n", len(tree.Conversations))
+		}
 
 		// 读取后续对话文件
 		for i := 1; i < len(fileList); i++ {
@@ -100,6 +194,12 @@ func main() {
 			conversations = append(conversations, conv)
 			conversationIDs = append(conversationIDs, convID)
 		}
+	} else if *render != "" || *diffIDs != "" || *showStats {
+		fmt.Println("错误: 可视化导出、分支差异和统计信息的输入文件必须是树结构")
+		os.Exit(1)
+	} else if *action != "add" {
+		fmt.Printf("错误: %s 操作的第一个输入文件必须是树结构\n", *action)
+		os.Exit(1)
 	} else {
 		// 所有文件都是对话文件
 		fmt.Printf("检测到 %d 个对话文件，开始合并\n", len(fileList))
@@ -114,6 +214,112 @@ func main() {
 		}
 	}
 
+	if *render != "" {
+		outputPath, err := renderTreeFile(tree, *render, *conversationDir, *outputDir, *snippetLen)
+		if err != nil {
+			fmt.Printf("可视化导出失败: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Printf("结果已保存到: %s\n", outputPath)
+		return
+	}
+
+	if *diffIDs != "" {
+		ids := strings.Split(*diffIDs, ",")
+		if len(ids) != 2 {
+			fmt.Println("错误: -diff 需要两个对话ID，用逗号分隔")
+			os.Exit(1)
+		}
+		contents := loadNodeContents(tree, *conversationDir)
+		report, err := diffConversations(tree, strings.TrimSpace(ids[0]), strings.TrimSpace(ids[1]), contents, *diffWidth)
+		if err != nil {
+			fmt.Printf("分支差异失败: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Print(report)
+		return
+	}
+
+	if *showStats {
+		stats := computeTreeStats(tree, loadNodeContents(tree, *conversationDir))
+		if *jsonOutput {
+			data, err := json.MarshalIndent(stats, "", "  ")
+			if err != nil {
+				fmt.Printf("序列化统计信息失败: %v\n", err)
+				os.Exit(1)
+			}
+			fmt.Println(string(data))
+		} else {
+			fmt.Print(formatTreeStats(stats, true))
+		}
+		return
+	}
+
+	// 已有树按内容合并时，后续合并沿用同一策略
+	oldRoot := ""
+	embedInherited := false
+	if tree != nil {
+		oldRoot = tree.Root
+		if tree.Strategy == "content" {
+			*strategy = "content"
+		}
+		// 已嵌入内容的树，后续合并沿用同一嵌入方式，只为新节点嵌入内容
+		if *embed == "" && tree.Embed != "" {
+			*embed = tree.Embed
+			embedInherited = true
+		}
+	}
+
+	if *action == "remove" {
+		if *removeIDs == "" {
+			fmt.Println("错误: remove 操作必须通过 -ids 指定要移除的对话")
+			os.Exit(1)
+		}
+		ids := strings.Split(*removeIDs, ",")
+		for i := range ids {
+			ids[i] = strings.TrimSpace(ids[i])
+		}
+		pruned, err := removeConversationsFromTree(tree, ids)
+		if err != nil {
+			fmt.Printf("移除失败: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Printf("\n移除成功！剩余 %d 个对话，剪除 %d 个节点\n", len(tree.Conversations), pruned)
+		saveTree(tree, oldRoot, *outputDir)
+		return
+	}
+
+	if *action == "rebuild" {
+		// 重新读取树中所有对话（-input 中额外给出的对话文件优先），对话文件已不存在的从树中移除
+		given := make(map[string]bool, len(conversationIDs))
+		for _, convID := range conversationIDs {
+			given[convID] = true
+		}
+		for _, convID := range tree.Conversations {
+			if given[convID] {
+				continue
+			}
+			conv, _, err := readConversationFile(filepath.Join(*conversationDir, convID+".json"))
+			if err != nil {
+				fmt.Printf("[移除] 对话 %s 读取失败: %v\n", convID, err)
+				continue
+			}
+			conversations = append(conversations, conv)
+			conversationIDs = append(conversationIDs, convID)
+		}
+		if len(conversations) == 0 {
+			fmt.Println("错误: 没有可用于重建的对话文件")
+			os.Exit(1)
+		}
+		tree = nil
+	}
+
+	// 按内容合并时先将内容相同的节点对齐到同一ID
+	var alignment *ContentAlignment
+	if *strategy == "content" {
+		alignment = alignByContent(tree, conversations)
+	}
+
 	// 执行合并
 	var result *Tree
 	if tree != nil {
@@ -128,31 +334,241 @@ func main() {
 		fmt.Printf("合并失败: %v\n", err)
 		os.Exit(1)
 	}
+	if alignment != nil {
+		alignment.annotate(result)
+	}
+	if *embed != "" {
+		contents := conversationContents(conversations)
+		// 显式指定嵌入方式时刷新全部节点，不在本次输入中的节点从对话目录读取
+		missing := false
+		for nodeID := range result.Nodes {
+			if _, exists := contents[nodeID]; !exists {
+				missing = true
+				break
+			}
+		}
+		if missing && !embedInherited {
+			for nodeID, node := range loadNodeContents(result, *conversationDir) {
+				if _, exists := contents[nodeID]; !exists {
+					contents[nodeID] = node
+				}
+			}
+		}
+		embedContents(result, contents, *embed, *snippetLen, !embedInherited)
+	}
 
 	// 计算统计信息
-	stats := calculateStatistics(result, conversations)
+	stats := computeTreeStats(result, nil)
 	fmt.Printf("\n合并成功！\n")
-	fmt.Printf("共同节点数量: %d\n", stats.CommonNodeCount)
-	fmt.Printf("最长树枝长度: %d\n", stats.MaxDepth)
-	fmt.Printf("共同节点占比: %.2f%%\n", stats.Percentage)
+	fmt.Print(formatTreeStats(stats, false))
 
-	// 生成输出文件名（使用root id）
-	outputFileName := result.Root + ".json"
-	outputPath := *outputDir + "/" + outputFileName
+	saveTree(result, oldRoot, *outputDir)
+}
 
-	// 写入输出文件
-	outputData, err := json.MarshalIndent(result, "", "  ")
+// 保存树文件；根节点变化时删除输出目录中以旧根节点命名的树文件
+func saveTree(tree *Tree, oldRoot string, outputDir string) {
+	outputPath, err := writeTree(tree, outputDir)
 	if err != nil {
-		fmt.Printf("序列化JSON失败: %v\n", err)
+		fmt.Printf("%v\n", err)
 		os.Exit(1)
 	}
+	if oldRoot != "" && oldRoot != tree.Root {
+		oldPath := filepath.Join(outputDir, oldRoot+".json")
+		if err := os.Remove(oldPath); err == nil {
+			fmt.Printf("根节点已变化，删除旧树文件: %s\n", oldPath)
+		} else if !os.IsNotExist(err) {
+			fmt.Printf("删除旧树文件失败: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
+	fmt.Printf("结果已保存到: %s\n", outputPath)
+}
+
+// 写入树文件（使用root id作为文件名）
+func writeTree(tree *Tree, outputDir string) (string, error) {
+	outputPath := filepath.Join(outputDir, tree.Root+".json")
+
+	outputData, err := json.MarshalIndent(tree, "", "  ")
+	if err != nil {
+		return "", fmt.Errorf("序列化JSON失败: %v", err)
+	}
 
 	if err := ioutil.WriteFile(outputPath, outputData, 0644); err != nil {
-		fmt.Printf("写入文件失败: %v\n", err)
-		os.Exit(1)
+		return "", fmt.Errorf("写入文件失败: %v", err)
 	}
+	return outputPath, nil
+}
 
-	fmt.Printf("结果已保存到: %s\n", outputPath)
+// 自动发现：按消息ID对目录中的所有对话做并查集分组，每组（至少两个对话）重新生成一棵树，并输出对话到树的索引
+// 旧索引中不再出现在新索引里的树文件（例如加入了更早分叉的对话导致根节点变化）会被删除，避免同一对话出现在多棵树中
+func discoverTrees(conversationDir string, outputDir string, strategy string, embed string, snippetLen int) error {
+	files, err := filepath.Glob(filepath.Join(conversationDir, "*.json"))
+	if err != nil {
+		return err
+	}
+	sort.Strings(files)
+	fmt.Printf("共找到 %d 个对话文件\n", len(files))
+
+	var conversations [][]ConversationNode
+	var conversationIDs []string
+	for _, file := range files {
+		conv, convID, err := readConversationFile(file)
+		if err != nil {
+			fmt.Printf("[跳过] %s: %v\n", file, err)
+			continue
+		}
+		conversations = append(conversations, conv)
+		conversationIDs = append(conversationIDs, convID)
+	}
+
+	var alignment *ContentAlignment
+	if strategy == "content" {
+		alignment = alignByContent(nil, conversations)
+	}
+
+	// 读取旧索引，用于清理不再使用的树文件
+	oldIndex := readTreeIndex(outputDir)
+
+	index := TreeIndex{
+		Trees:         make(map[string]TreeIndexEntry),
+		Conversations: make(map[string]string),
+	}
+	treeCount, standalone := 0, 0
+	fail := func(groupIDs []string, err error) {
+		fmt.Printf("[失败] %s: %v\n", strings.Join(groupIDs, ","), err)
+		index.Failed = append(index.Failed, TreeIndexFailure{Conversations: groupIDs, Error: err.Error()})
+	}
+	for _, members := range groupConversations(conversations) {
+		if len(members) < 2 {
+			standalone++
+			continue
+		}
+
+		groupConvs := make([][]ConversationNode, 0, len(members))
+		groupIDs := make([]string, 0, len(members))
+		for _, i := range members {
+			groupConvs = append(groupConvs, conversations[i])
+			groupIDs = append(groupIDs, conversationIDs[i])
+		}
+
+		tree, err := createTreeFromConversations(groupConvs, groupIDs)
+		if err != nil {
+			fail(groupIDs, err)
+			continue
+		}
+		if alignment != nil {
+			alignment.annotate(tree)
+		}
+		if embed != "" {
+			embedContents(tree, conversationContents(groupConvs), embed, snippetLen, true)
+		}
+		outputPath, err := writeTree(tree, outputDir)
+		if err != nil {
+			fail(groupIDs, err)
+			continue
+		}
+		treeCount++
+		fmt.Printf("[树] %s: %d 个对话, %d 个节点\n", outputPath, len(groupIDs), len(tree.Nodes))
+
+		index.Trees[tree.Root] = TreeIndexEntry{Conversations: groupIDs, NodeCount: len(tree.Nodes)}
+		for _, convID := range groupIDs {
+			index.Conversations[convID] = tree.Root
+		}
+	}
+
+	// 删除旧索引中不再使用的树文件
+	oldRoots := make([]string, 0, len(oldIndex.Trees))
+	for oldRoot := range oldIndex.Trees {
+		oldRoots = append(oldRoots, oldRoot)
+	}
+	sort.Strings(oldRoots)
+	var removeErrors []string
+	for _, oldRoot := range oldRoots {
+		if _, stillUsed := index.Trees[oldRoot]; stillUsed {
+			continue
+		}
+		oldPath := filepath.Join(outputDir, oldRoot+".json")
+		if err := os.Remove(oldPath); err == nil {
+			fmt.Printf("删除旧树文件: %s\n", oldPath)
+		} else if !os.IsNotExist(err) {
+			removeErrors = append(removeErrors, err.Error())
+		}
+	}
+
+	indexData, err := json.MarshalIndent(index, "", "  ")
+	if err != nil {
+		return fmt.Errorf("序列化索引失败: %v", err)
+	}
+	indexPath := filepath.Join(outputDir, treeIndexFile)
+	if err := ioutil.WriteFile(indexPath, indexData, 0644); err != nil {
+		return fmt.Errorf("写入索引失败: %v", err)
+	}
+
+	fmt.Printf("\n发现完成: %d 棵树, %d 个独立对话, 失败 %d\n", treeCount, standalone, len(index.Failed))
+	fmt.Printf("索引已保存到: %s\n", indexPath)
+	if len(removeErrors) > 0 {
+		return fmt.Errorf("删除旧树文件失败: %s", strings.Join(removeErrors, "; "))
+	}
+	return nil
+}
+
+// groupConversations 并查集：出现相同消息ID的对话属于同一组，组和组内成员都按文件顺序排列
+func groupConversations(conversations [][]ConversationNode) [][]int {
+	parent := make([]int, len(conversations))
+	for i := range parent {
+		parent[i] = i
+	}
+	var find func(int) int
+	find = func(x int) int {
+		if parent[x] != x {
+			parent[x] = find(parent[x])
+		}
+		return parent[x]
+	}
+	nodeOwner := make(map[string]int)
+	for i, conv := range conversations {
+		for _, node := range conv {
+			if owner, exists := nodeOwner[node.ID]; exists {
+				if a, b := find(owner), find(i); a != b {
+					// 以文件顺序靠前的对话为代表，保证分组顺序稳定
+					if a < b {
+						parent[b] = a
+					} else {
+						parent[a] = b
+					}
+				}
+			} else {
+				nodeOwner[node.ID] = i
+			}
+		}
+	}
+
+	groups := make(map[int][]int)
+	var roots []int
+	for i := range conversations {
+		root := find(i)
+		if _, exists := groups[root]; !exists {
+			roots = append(roots, root)
+		}
+		groups[root] = append(groups[root], i)
+	}
+
+	result := make([][]int, 0, len(roots))
+	for _, root := range roots {
+		result = append(result, groups[root])
+	}
+	return result
+}
+
+// 读取已有的树索引，不存在或无法解析时返回空索引
+func readTreeIndex(outputDir string) TreeIndex {
+	var index TreeIndex
+	data, err := ioutil.ReadFile(filepath.Join(outputDir, treeIndexFile))
+	if err == nil {
+		json.Unmarshal(data, &index)
+	}
+	return index
 }
 
 // 判断是否为树结构
@@ -188,6 +604,7 @@ func readConversationFile(filepath string) ([]ConversationNode, string, error) {
 }
 
 // 从多个对话创建新树
+// 节点按在对话中首次出现的顺序登记，children 也按该顺序排列，保证相同输入生成相同的树
 func createTreeFromConversations(conversations [][]ConversationNode, conversationIDs []string) (*Tree, error) {
 	if len(conversations) == 0 {
 		return nil, fmt.Errorf("没有对话数据")
@@ -207,6 +624,7 @@ func createTreeFromConversations(conversations [][]ConversationNode, conversatio
 
 	// 构建节点映射
 	nodeConvMap := make(map[string][]string) // 记录每个节点属于哪些对话
+	var order []string                       // 节点首次出现的顺序
 
 	for i, conv := range conversations {
 		convID := conversationIDs[i]
@@ -216,30 +634,14 @@ func createTreeFromConversations(conversations [][]ConversationNode, conversatio
 					Parent:   node.ParentID,
 					Children: []string{},
 				}
+				order = append(order, node.ID)
 			}
 			nodeConvMap[node.ID] = append(nodeConvMap[node.ID], convID)
 		}
 	}
 
-	// 构建children关系
-	for nodeID, node := range tree.Nodes {
-		for otherID, otherNode := range tree.Nodes {
-			if otherNode.Parent == nodeID {
-				// 检查是否已存在
-				found := false
-				for _, childID := range node.Children {
-					if childID == otherID {
-						found = true
-						break
-					}
-				}
-				if !found {
-					node.Children = append(node.Children, otherID)
-					tree.Nodes[nodeID] = node
-				}
-			}
-		}
-	}
+	// 构建children关系（每个节点只登记一次，无需去重）
+	linkChildren(tree, order)
 
 	// 设置conversations字段（非所有对话共同节点）
 	totalConvCount := len(conversationIDs)
@@ -254,7 +656,23 @@ func createTreeFromConversations(conversations [][]ConversationNode, conversatio
 	return tree, nil
 }
 
+// 按给定顺序将节点挂到父节点的children下，父节点不在树中的节点跳过
+func linkChildren(tree *Tree, nodeIDs []string) {
+	for _, nodeID := range nodeIDs {
+		parentID := tree.Nodes[nodeID].Parent
+		if parentID == "" || parentID == nodeID {
+			continue
+		}
+		if parentNode, exists := tree.Nodes[parentID]; exists {
+			parentNode.Children = append(parentNode.Children, nodeID)
+			tree.Nodes[parentID] = parentNode
+		}
+	}
+}
+
 // 合并对话到已有树
+// 已有节点的children顺序保持不变，新节点按在对话中首次出现的顺序追加
+// 已在树中的对话重新合并时，先清除其原有归属再按当前内容登记，不再被任何对话引用的节点会被剪除
 func mergeConversationsToTree(tree *Tree, conversations [][]ConversationNode, conversationIDs []string) (*Tree, error) {
 	// 检查新对话是否可以合并
 	for i, conv := range conversations {
@@ -263,61 +681,136 @@ func mergeConversationsToTree(tree *Tree, conversations [][]ConversationNode, co
 		}
 	}
 
-	// 更新conversations列表
+	membership := nodeMembership(tree)
+
+	// 更新conversations列表，已存在的对话清除原有归属
+	known := make(map[string]bool, len(tree.Conversations))
+	for _, convID := range tree.Conversations {
+		known[convID] = true
+	}
+	readded := make(map[string]bool)
 	for _, convID := range conversationIDs {
-		if !contains(tree.Conversations, convID) {
-			tree.Conversations = append(tree.Conversations, convID)
+		if known[convID] {
+			readded[convID] = true
+			continue
 		}
+		known[convID] = true
+		tree.Conversations = append(tree.Conversations, convID)
 	}
-
-	// 构建节点所属对话映射
-	nodeConvMap := make(map[string][]string)
-
-	// 先统计现有树中的节点
-	for nodeID, node := range tree.Nodes {
-		if len(node.Conversations) > 0 {
-			nodeConvMap[nodeID] = node.Conversations
-		} else {
-			// 如果conversations为空，说明是所有已有对话的共同节点
-			nodeConvMap[nodeID] = tree.Conversations[:len(tree.Conversations)-len(conversationIDs)]
+	if len(readded) > 0 {
+		for nodeID, convList := range membership {
+			membership[nodeID] = filterStrings(convList, readded)
 		}
 	}
 
 	// 合并新对话的节点
+	var added []string
 	for i, conv := range conversations {
 		convID := conversationIDs[i]
 		for _, node := range conv {
-			// 添加或更新节点
-			if _, exists := tree.Nodes[node.ID]; exists {
-				// 节点已存在，更新所属对话
-				nodeConvMap[node.ID] = append(nodeConvMap[node.ID], convID)
-			} else {
+			if _, exists := tree.Nodes[node.ID]; !exists {
 				// 新节点
 				tree.Nodes[node.ID] = TreeNode{
 					Parent:   node.ParentID,
 					Children: []string{},
 				}
-				nodeConvMap[node.ID] = []string{convID}
+				added = append(added, node.ID)
 			}
+			membership[node.ID] = append(membership[node.ID], convID)
+		}
+	}
 
-			// 更新父节点的children
-			if node.ParentID != "" {
-				if parentNode, exists := tree.Nodes[node.ParentID]; exists {
-					if !contains(parentNode.Children, node.ID) {
-						parentNode.Children = append(parentNode.Children, node.ID)
-						tree.Nodes[node.ParentID] = parentNode
-					}
-				}
+	// 新节点全部登记后再挂到父节点下，父节点出现在子节点之后时也能正确关联
+	linkChildren(tree, added)
+
+	pruneTree(tree, membership)
+	applyMembership(tree, membership)
+	return tree, nil
+}
+
+// 从树中移除对话，返回被剪除的节点数
+// 只属于被移除对话的节点会被删除；根节点被删除时重新确定根节点
+func removeConversationsFromTree(tree *Tree, conversationIDs []string) (int, error) {
+	removed := make(map[string]bool, len(conversationIDs))
+	for _, convID := range conversationIDs {
+		if !contains(tree.Conversations, convID) {
+			return 0, fmt.Errorf("对话 %s 不在树中", convID)
+		}
+		removed[convID] = true
+	}
+
+	remaining := filterStrings(tree.Conversations, removed)
+	if len(remaining) == 0 {
+		return 0, fmt.Errorf("移除后树中没有剩余对话，请直接删除树文件")
+	}
+
+	membership := nodeMembership(tree)
+	for nodeID, convList := range membership {
+		membership[nodeID] = filterStrings(convList, removed)
+	}
+	tree.Conversations = remaining
+
+	before := len(tree.Nodes)
+	pruneTree(tree, membership)
+	applyMembership(tree, membership)
+
+	if _, exists := tree.Nodes[tree.Root]; !exists {
+		root, err := findTreeRoot(tree)
+		if err != nil {
+			return 0, err
+		}
+		tree.Root = root
+	}
+	return before - len(tree.Nodes), nil
+}
+
+// 展开每个节点的所属对话（conversations为空的节点属于树中所有对话），返回的切片均为副本
+func nodeMembership(tree *Tree) map[string][]string {
+	membership := make(map[string][]string, len(tree.Nodes))
+	for nodeID, node := range tree.Nodes {
+		if len(node.Conversations) > 0 {
+			membership[nodeID] = append([]string(nil), node.Conversations...)
+		} else {
+			membership[nodeID] = append([]string(nil), tree.Conversations...)
+		}
+	}
+	return membership
+}
+
+// 删除不属于任何对话的节点，并从父节点的children中移除
+func pruneTree(tree *Tree, membership map[string][]string) {
+	for nodeID := range tree.Nodes {
+		if len(membership[nodeID]) == 0 {
+			delete(tree.Nodes, nodeID)
+			delete(membership, nodeID)
+		}
+	}
+	for nodeID, node := range tree.Nodes {
+		children := node.Children[:0]
+		for _, childID := range node.Children {
+			if _, exists := tree.Nodes[childID]; exists {
+				children = append(children, childID)
 			}
 		}
+		node.Children = children
+		tree.Nodes[nodeID] = node
+	}
+}
+
+// 按归属写回节点的conversations字段：所有对话的共同节点留空，其余按树中对话的顺序排列
+func applyMembership(tree *Tree, membership map[string][]string) {
+	order := make(map[string]int, len(tree.Conversations))
+	for i, convID := range tree.Conversations {
+		order[convID] = i
 	}
 
-	// 更新所有节点的conversations字段
 	totalConvCount := len(tree.Conversations)
 	for nodeID, node := range tree.Nodes {
-		convList := nodeConvMap[nodeID]
 		// 去重
-		convList = uniqueStrings(convList)
+		convList := uniqueStrings(membership[nodeID])
+		sort.SliceStable(convList, func(i, j int) bool {
+			return order[convList[i]] < order[convList[j]]
+		})
 
 		if len(convList) < totalConvCount {
 			node.Conversations = convList
@@ -326,133 +819,1003 @@ func mergeConversationsToTree(tree *Tree, conversations [][]ConversationNode, co
 		}
 		tree.Nodes[nodeID] = node
 	}
+}
 
-	return tree, nil
+// 查找所有对话共同节点中最顶层的一个（按节点ID排序保证结果稳定）
+func findTreeRoot(tree *Tree) (string, error) {
+	isCommon := func(nodeID string) bool {
+		node, exists := tree.Nodes[nodeID]
+		return exists && len(node.Conversations) == 0
+	}
+
+	nodeIDs := make([]string, 0, len(tree.Nodes))
+	for nodeID := range tree.Nodes {
+		nodeIDs = append(nodeIDs, nodeID)
+	}
+	sort.Strings(nodeIDs)
+	for _, nodeID := range nodeIDs {
+		if isCommon(nodeID) && !isCommon(tree.Nodes[nodeID].Parent) {
+			return nodeID, nil
+		}
+	}
+	return "", fmt.Errorf("剩余对话之间没有共同节点，无法确定根节点")
 }
 
 // 查找共同根节点
+// 只统计第一个对话中的节点：counts[id] == i 表示该节点出现在前 i 个对话中，每个对话至多计数一次
 func findCommonRoot(conversations [][]ConversationNode) (string, error) {
 	if len(conversations) == 0 {
 		return "", fmt.Errorf("没有对话数据")
 	}
 
-	// 收集第一个对话的所有节点ID
-	firstConvNodes := make(map[string]bool)
+	counts := make(map[string]int, len(conversations[0]))
 	for _, node := range conversations[0] {
-		firstConvNodes[node.ID] = true
-	}
-
-	// 找到所有对话都包含的节点
-	commonNodes := make(map[string]bool)
-	for nodeID := range firstConvNodes {
-		isCommon := true
-		for i := 1; i < len(conversations); i++ {
-			found := false
-			for _, node := range conversations[i] {
-				if node.ID == nodeID {
-					found = true
-					break
-				}
-			}
-			if !found {
-				isCommon = false
-				break
+		counts[node.ID] = 1
+	}
+	for i := 1; i < len(conversations); i++ {
+		for _, node := range conversations[i] {
+			if count, exists := counts[node.ID]; exists && count == i {
+				counts[node.ID] = i + 1
 			}
 		}
-		if isCommon {
-			commonNodes[nodeID] = true
-		}
 	}
 
-	if len(commonNodes) == 0 {
-		return "", fmt.Errorf("对话之间没有共同节点，无法合并")
+	// 所有对话都包含的节点
+	total := len(conversations)
+	isCommon := func(nodeID string) bool {
+		return counts[nodeID] == total
 	}
 
 	// 找到最顶层的共同节点（parent_id为空或不在共同节点中）
+	firstCommon := ""
 	for _, node := range conversations[0] {
-		if commonNodes[node.ID] {
-			if node.ParentID == "" || !commonNodes[node.ParentID] {
-				return node.ID, nil
-			}
+		if !isCommon(node.ID) {
+			continue
+		}
+		if node.ParentID == "" || !isCommon(node.ParentID) {
+			return node.ID, nil
+		}
+		if firstCommon == "" {
+			firstCommon = node.ID
 		}
 	}
 
-	// 如果没找到，返回第一个共同节点
-	for nodeID := range commonNodes {
-		return nodeID, nil
+	if firstCommon == "" {
+		return "", fmt.Errorf("对话之间没有共同节点，无法合并")
 	}
 
-	return "", fmt.Errorf("无法确定根节点")
+	// 共同节点的父链成环时，返回第一个对话中最早出现的共同节点
+	return firstCommon, nil
 }
 
-// 检查对话是否可以合并到树
-func canMerge(tree *Tree, conversation []ConversationNode) bool {
-	for _, node := range conversation {
-		if _, exists := tree.Nodes[node.ID]; exists {
-			return true
-		}
+// 按内容对齐对话节点：每个节点的哈希由父节点哈希和自身规范化内容计算，前缀内容相同的节点得到相同哈希，
+// 对齐后改写为同一个ID（最先出现的原始ID），之后即可复用按ID合并的逻辑
+// 父节点在本对话中缺失时（例如恢复的会话指向原会话中的消息），从其他对话或已有树中补全祖先节点
+func alignByContent(tree *Tree, conversations [][]ConversationNode) *ContentAlignment {
+	alignment := &ContentAlignment{
+		Hashes:  make(map[string]string),
+		Aliases: make(map[string][]string),
 	}
-	return false
-}
+	canonical := make(map[string]string) // 哈希 -> 树节点ID
+	hashOf := make(map[string]string)    // 原始节点ID -> 哈希
 
-// 统计信息
-type Statistics struct {
-	CommonNodeCount int
-	MaxDepth        int
-	Percentage      float64
-}
-
-// 计算统计信息
-func calculateStatistics(tree *Tree, conversations [][]ConversationNode) Statistics {
-	stats := Statistics{}
-
-	// 找到最长对话的节点数
-	maxLen := 0
-	for _, conv := range conversations {
-		if len(conv) > maxLen {
-			maxLen = len(conv)
+	// 已有树中的节点哈希
+	if tree != nil {
+		for nodeID, node := range tree.Nodes {
+			if node.Hash != "" {
+				canonical[node.Hash] = nodeID
+				hashOf[nodeID] = node.Hash
+			}
 		}
 	}
-	stats.MaxDepth = maxLen
-
-	// 计算共同节点数量（从root开始到第一个分叉点）
-	commonCount := 0
-	currentID := tree.Root
-
-	for currentID != "" {
-		node := tree.Nodes[currentID]
 
-		// 检查是否所有对话都包含此节点
-		isCommon := true
-		if len(node.Conversations) > 0 {
-			isCommon = false
-		}
-
-		if isCommon {
-			commonCount++
-			// 如果有多个children，说明开始分叉了
-			if len(node.Children) > 1 {
-				break
-			}
-			// 继续沿着单一路径
-			if len(node.Children) == 1 {
-				currentID = node.Children[0]
-			} else {
-				break
+	// 所有对话中的原始节点（ID重复时取最先出现的）
+	nodeMap := make(map[string]ConversationNode)
+	for _, conv := range conversations {
+		for _, node := range conv {
+			if _, exists := nodeMap[node.ID]; !exists {
+				nodeMap[node.ID] = node
 			}
-		} else {
-			break
 		}
 	}
 
-	stats.CommonNodeCount = commonCount
-	if stats.MaxDepth > 0 {
-		stats.Percentage = float64(commonCount) / float64(stats.MaxDepth) * 100
+	// 恢复的会话可能只包含新消息，父节点指向原会话，补全其祖先节点后才能与原会话合并
+	for ci, conv := range conversations {
+		local := make(map[string]bool, len(conv))
+		for _, node := range conv {
+			local[node.ID] = true
+		}
+		var ancestors []ConversationNode
+		for _, node := range conv {
+			if node.ParentID == "" || local[node.ParentID] {
+				continue
+			}
+			for id := node.ParentID; id != "" && !local[id]; {
+				parent, exists := nodeMap[id]
+				if !exists && tree != nil {
+					// 已有树中的节点没有内容，哈希已知，只需要ID和父节点
+					if treeNode, inTree := tree.Nodes[id]; inTree {
+						parent, exists = ConversationNode{ID: id, ParentID: treeNode.Parent}, true
+					}
+				}
+				if !exists {
+					break
+				}
+				local[id] = true
+				ancestors = append(ancestors, parent)
+				id = parent.ParentID
+			}
+			break
+		}
+		if len(ancestors) > 0 {
+			for i, j := 0, len(ancestors)-1; i < j; i, j = i+1, j-1 {
+				ancestors[i], ancestors[j] = ancestors[j], ancestors[i]
+			}
+			conversations[ci] = append(ancestors, conv...)
+		}
+	}
+
+	// 沿父链向上找到第一个已计算的节点，再自上而下计算哈希
+	nodeHash := func(nodeID string) string {
+		var chain []ConversationNode
+		inChain := make(map[string]bool)
+		for id := nodeID; id != ""; {
+			if _, done := hashOf[id]; done {
+				break
+			}
+			node, exists := nodeMap[id]
+			if !exists || inChain[id] {
+				break
+			}
+			inChain[id] = true
+			chain = append(chain, node)
+			id = node.ParentID
+		}
+		for i := len(chain) - 1; i >= 0; i-- {
+			hashOf[chain[i].ID] = contentHash(hashOf[chain[i].ParentID], chain[i])
+		}
+		return hashOf[nodeID]
+	}
+
+	canonicalID := func(hash string, originalID string) string {
+		if nodeID, exists := canonical[hash]; exists {
+			return nodeID
+		}
+		canonical[hash] = originalID
+		alignment.Hashes[originalID] = hash
+		return originalID
+	}
+
+	for _, conv := range conversations {
+		for i, node := range conv {
+			nodeID := canonicalID(nodeHash(node.ID), node.ID)
+			if nodeID != node.ID && !contains(alignment.Aliases[nodeID], node.ID) {
+				alignment.Aliases[nodeID] = append(alignment.Aliases[nodeID], node.ID)
+			}
+			conv[i].ID = nodeID
+			if node.ParentID != "" {
+				if parentHash := nodeHash(node.ParentID); parentHash != "" {
+					conv[i].ParentID = canonicalID(parentHash, node.ParentID)
+				}
+			}
+		}
+	}
+
+	return alignment
+}
+
+// 计算节点的前缀内容哈希：父节点哈希 + 角色 + 规范化的正文和工具调用（空白统一折叠，不含ID和时间）
+func contentHash(parentHash string, node ConversationNode) string {
+	h := sha1.New()
+	h.Write([]byte(parentHash))
+	h.Write([]byte{0})
+	h.Write([]byte(node.Role))
+	h.Write([]byte{0})
+	h.Write([]byte(strings.Join(strings.Fields(node.Content), " ")))
+
+	toolDataList := []map[string]interface{}{node.ToolData}
+	for _, block := range node.Blocks {
+		toolDataList = append(toolDataList, block.ToolData)
+	}
+	for _, toolData := range toolDataList {
+		if toolData == nil {
+			continue
+		}
+		// 工具调用只比较名称、输入和输出，调用ID和耗时在不同会话中不同
+		data, _ := json.Marshal([]interface{}{toolData["name"], toolData["input"], toolData["output"]})
+		h.Write([]byte{0})
+		h.Write(data)
+	}
+	return hex.EncodeToString(h.Sum(nil))[:16]
+}
+
+// 将内容哈希和别名写入树节点
+func (a *ContentAlignment) annotate(tree *Tree) {
+	tree.Strategy = "content"
+	for nodeID, node := range tree.Nodes {
+		if hash, exists := a.Hashes[nodeID]; exists {
+			node.Hash = hash
+		}
+		for _, alias := range a.Aliases[nodeID] {
+			if !contains(node.Aliases, alias) {
+				node.Aliases = append(node.Aliases, alias)
+			}
+		}
+		tree.Nodes[nodeID] = node
+	}
+}
+
+// 可视化调色板，按对话在树中的顺序循环取色
+var branchColors = []string{"#1f77b4", "#d62728", "#2ca02c", "#ff7f0e", "#9467bd", "#8c564b", "#e377c2", "#17becf"}
+
+// ASCII 输出到终端时使用的 ANSI 颜色
+var ansiColors = []string{"\033[34m", "\033[31m", "\033[32m", "\033[33m", "\033[35m", "\033[36m"}
+
+const ansiReset = "\033[0m"
+
+var roleTitles = map[string]string{
+	"user":      "用户",
+	"human":     "用户",
+	"assistant": "助手",
+	"system":    "系统",
+	"tool":      "工具",
+}
+
+// 可视化导出：读取节点内容生成标签，按格式写入 <output>/<root>.dot|mmd|txt
+func renderTreeFile(tree *Tree, format string, conversationDir string, outputDir string, snippetLen int) (string, error) {
+	contents := loadNodeContents(tree, conversationDir)
+	labels := make(map[string]string, len(tree.Nodes))
+	for nodeID := range tree.Nodes {
+		labels[nodeID] = nodeLabel(nodeID, contents, snippetLen)
+	}
+
+	var output, ext string
+	switch format {
+	case "dot":
+		output, ext = renderDOT(tree, labels), ".dot"
+	case "mermaid":
+		output, ext = renderMermaid(tree, labels), ".mmd"
+	default:
+		output, ext = renderASCII(tree, labels, false), ".txt"
+		// 终端中直接显示带颜色的版本
+		if info, err := os.Stdout.Stat(); err == nil && info.Mode()&os.ModeCharDevice != 0 {
+			fmt.Print(renderASCII(tree, labels, true))
+		} else {
+			fmt.Print(output)
+		}
+	}
+
+	outputPath := filepath.Join(outputDir, tree.Root+ext)
+	if err := ioutil.WriteFile(outputPath, []byte(output), 0644); err != nil {
+		return "", fmt.Errorf("写入文件失败: %v", err)
+	}
+	return outputPath, nil
+}
+
+// 读取树中各对话的节点内容；按内容合并的树中，节点ID对应的对话缺失时通过别名查找
+func loadNodeContents(tree *Tree, conversationDir string) map[string]ConversationNode {
+	contents := make(map[string]ConversationNode)
+	for _, convID := range tree.Conversations {
+		conv, _, err := readConversationFile(filepath.Join(conversationDir, convID+".json"))
+		if err != nil {
+			// 输出到 stderr，避免混入 JSON 统计输出
+			fmt.Fprintf(os.Stderr, "[跳过] 对话 %s 读取失败: %v\n", convID, err)
+			continue
+		}
+		for _, node := range conv {
+			if _, exists := contents[node.ID]; !exists {
+				contents[node.ID] = node
+			}
+		}
+	}
+	for nodeID, treeNode := range tree.Nodes {
+		if _, exists := contents[nodeID]; exists {
+			continue
+		}
+		for _, alias := range treeNode.Aliases {
+			if node, ok := contents[alias]; ok {
+				contents[nodeID] = node
+				break
+			}
+		}
+		// 对话文件缺失时使用树中嵌入的内容
+		if _, exists := contents[nodeID]; !exists && treeNode.Role != "" {
+			contents[nodeID] = ConversationNode{
+				ID:          nodeID,
+				Role:        treeNode.Role,
+				ContentType: treeNode.ContentType,
+				Content:     treeNode.Content,
+				CreateTime:  treeNode.CreateTime,
+			}
+		}
+	}
+	return contents
+}
+
+// 节点标签: "角色: 内容摘要"，找不到内容时使用节点ID
+func nodeLabel(nodeID string, contents map[string]ConversationNode, snippetLen int) string {
+	node, exists := contents[nodeID]
+	if !exists {
+		return nodeID
+	}
+
+	text := nodeSnippet(node, snippetLen)
+	role := node.Role
+	if title, ok := roleTitles[role]; ok {
+		role = title
+	}
+	return role + ": " + text
+}
+
+// 节点内容摘要：合并空白后截断，没有文本时显示工具名
+func nodeSnippet(node ConversationNode, snippetLen int) string {
+	text := strings.Join(strings.Fields(node.Content), " ")
+	if text == "" {
+		text = toolLabel(node)
+	}
+	if runes := []rune(text); snippetLen > 0 && len(runes) > snippetLen {
+		text = string(runes[:snippetLen]) + "..."
+	}
+	return text
+}
+
+// 工具调用节点的显示名称
+func toolLabel(node ConversationNode) string {
+	toolData := node.ToolData
+	for _, block := range node.Blocks {
+		if toolData == nil && block.ToolData != nil {
+			toolData = block.ToolData
+		}
+	}
+	if name, _ := toolData["name"].(string); name != "" {
+		return "[工具: " + name + "]"
+	}
+	return ""
+}
+
+// 按节点ID索引对话中的消息，同一节点以最先出现的为准
+func conversationContents(conversations [][]ConversationNode) map[string]ConversationNode {
+	contents := make(map[string]ConversationNode)
+	for _, conv := range conversations {
+		for _, node := range conv {
+			if _, exists := contents[node.ID]; !exists {
+				contents[node.ID] = node
+			}
+		}
+	}
+	return contents
+}
+
+// 将消息的角色、内容类型、内容和时间嵌入树节点，没有内容的节点保留原有嵌入
+// mode 为 snippet 时内容为摘要，为 full 时为全文；overwrite 为 false 时只处理尚未嵌入内容的节点
+func embedContents(tree *Tree, contents map[string]ConversationNode, mode string, snippetLen int, overwrite bool) {
+	for nodeID, treeNode := range tree.Nodes {
+		node, exists := contents[nodeID]
+		if !exists || (!overwrite && treeNode.Role != "") {
+			continue
+		}
+		treeNode.Role = node.Role
+		treeNode.ContentType = node.ContentType
+		treeNode.CreateTime = node.CreateTime
+		if mode == "full" {
+			treeNode.Content = node.Content
+			if treeNode.Content == "" {
+				treeNode.Content = toolLabel(node)
+			}
+		} else {
+			treeNode.Content = nodeSnippet(node, snippetLen)
+		}
+		tree.Nodes[nodeID] = treeNode
+	}
+	tree.Embed = mode
+}
+
+// 按深度优先顺序列出节点：先从根节点开始，再处理父节点不在树中的其他顶层节点（按ID排序）
+func treeOrder(tree *Tree) []string {
+	tops := []string{tree.Root}
+	var others []string
+	for nodeID, node := range tree.Nodes {
+		if _, exists := tree.Nodes[node.Parent]; !exists && nodeID != tree.Root {
+			others = append(others, nodeID)
+		}
+	}
+	sort.Strings(others)
+	tops = append(tops, others...)
+
+	var order []string
+	visited := make(map[string]bool, len(tree.Nodes))
+	var visit func(string)
+	visit = func(nodeID string) {
+		if visited[nodeID] {
+			return
+		}
+		if _, exists := tree.Nodes[nodeID]; !exists {
+			return
+		}
+		visited[nodeID] = true
+		order = append(order, nodeID)
+		for _, childID := range tree.Nodes[nodeID].Children {
+			visit(childID)
+		}
+	}
+	for _, top := range tops {
+		visit(top)
+	}
+	return order
+}
+
+// 节点所属对话在树中的序号，共同节点返回nil
+func conversationIndexes(tree *Tree, node TreeNode) []int {
+	if len(node.Conversations) == 0 {
+		return nil
+	}
+	var indexes []int
+	for i, convID := range tree.Conversations {
+		if contains(node.Conversations, convID) {
+			indexes = append(indexes, i)
+		}
+	}
+	return indexes
+}
+
+func branchColor(index int) string {
+	return branchColors[index%len(branchColors)]
+}
+
+func dotQuote(value string) string {
+	return "\"" + strings.NewReplacer("\\", "\\\\", "\"", "\\\"").Replace(value) + "\""
+}
+
+// Graphviz DOT：共同节点和边为灰色，分支节点边框和边按所属对话着色（多个对话时边使用多色平行线）
+func renderDOT(tree *Tree, labels map[string]string) string {
+	var sb strings.Builder
+	sb.WriteString("digraph " + dotQuote(tree.Root) + " {\n")
+	sb.WriteString("  rankdir=TB;\n")
+	sb.WriteString("  node [shape=box, style=\"rounded\", color=\"#888888\", fontname=\"Helvetica\"];\n")
+	sb.WriteString("  edge [color=\"#888888\"];\n\n")
+
+	// 图例
+	sb.WriteString("  subgraph cluster_legend {\n    label=\"对话\";\n")
+	for i, convID := range tree.Conversations {
+		sb.WriteString(fmt.Sprintf("    %s [label=%s, color=%s, penwidth=2];\n",
+			dotQuote("legend:"+convID), dotQuote(convID), dotQuote(branchColor(i))))
+	}
+	sb.WriteString("  }\n\n")
+
+	order := treeOrder(tree)
+	for _, nodeID := range order {
+		attrs := "label=" + dotQuote(labels[nodeID])
+		if indexes := conversationIndexes(tree, tree.Nodes[nodeID]); len(indexes) > 0 {
+			attrs += ", color=" + dotQuote(branchColor(indexes[0])) + ", penwidth=2"
+		}
+		if nodeID == tree.Root {
+			attrs += ", style=\"rounded,bold\""
+		}
+		sb.WriteString("  " + dotQuote(nodeID) + " [" + attrs + "];\n")
+	}
+	sb.WriteString("\n")
+
+	for _, nodeID := range order {
+		for _, childID := range tree.Nodes[nodeID].Children {
+			child, exists := tree.Nodes[childID]
+			if !exists {
+				continue
+			}
+			edge := "  " + dotQuote(nodeID) + " -> " + dotQuote(childID)
+			if indexes := conversationIndexes(tree, child); len(indexes) > 0 {
+				colors := make([]string, len(indexes))
+				for i, index := range indexes {
+					colors[i] = branchColor(index)
+				}
+				edge += " [color=" + dotQuote(strings.Join(colors, ":")) + ", penwidth=2]"
+			}
+			sb.WriteString(edge + ";\n")
+		}
+	}
+
+	sb.WriteString("}\n")
+	return sb.String()
+}
+
+// Mermaid 流程图：节点使用 n0、n1... 作为ID，分支节点和边按所属的第一个对话着色
+func renderMermaid(tree *Tree, labels map[string]string) string {
+	order := treeOrder(tree)
+	ids := make(map[string]string, len(order))
+	for i, nodeID := range order {
+		ids[nodeID] = fmt.Sprintf("n%d", i)
+	}
+	escape := strings.NewReplacer("\"", "#quot;")
+
+	var sb strings.Builder
+	sb.WriteString("flowchart TD\n")
+	for _, nodeID := range order {
+		sb.WriteString("  " + ids[nodeID] + "[\"" + escape.Replace(labels[nodeID]) + "\"]\n")
+	}
+
+	classMembers := make(map[int][]string)
+	var linkStyles []string
+	edgeIndex := 0
+	for _, nodeID := range order {
+		if indexes := conversationIndexes(tree, tree.Nodes[nodeID]); len(indexes) > 0 {
+			classMembers[indexes[0]] = append(classMembers[indexes[0]], ids[nodeID])
+		}
+		for _, childID := range tree.Nodes[nodeID].Children {
+			child, exists := tree.Nodes[childID]
+			if !exists {
+				continue
+			}
+			sb.WriteString("  " + ids[nodeID] + " --> " + ids[childID] + "\n")
+			if indexes := conversationIndexes(tree, child); len(indexes) > 0 {
+				linkStyles = append(linkStyles, fmt.Sprintf("  linkStyle %d stroke:%s,stroke-width:2px\n", edgeIndex, branchColor(indexes[0])))
+			}
+			edgeIndex++
+		}
+	}
+
+	// 图例
+	sb.WriteString("  subgraph legend[\"对话\"]\n")
+	for i, convID := range tree.Conversations {
+		sb.WriteString(fmt.Sprintf("    legend%d[\"%s\"]\n", i, escape.Replace(convID)))
+		classMembers[i] = append(classMembers[i], fmt.Sprintf("legend%d", i))
+	}
+	sb.WriteString("  end\n")
+
+	for i := range tree.Conversations {
+		sb.WriteString(fmt.Sprintf("  classDef c%d stroke:%s,stroke-width:2px\n", i, branchColor(i)))
+		sb.WriteString(fmt.Sprintf("  class %s c%d\n", strings.Join(classMembers[i], ","), i))
+	}
+	for _, style := range linkStyles {
+		sb.WriteString(style)
+	}
+	return sb.String()
+}
+
+// 缩进的 ASCII 树：所属对话与父节点不同时在节点后标注对话，color 为 true 时按对话着色
+func renderASCII(tree *Tree, labels map[string]string, color bool) string {
+	var sb strings.Builder
+	visited := make(map[string]bool, len(tree.Nodes))
+
+	var visit func(nodeID string, prefix string, connector string, parentConvs []string)
+	visit = func(nodeID string, prefix string, connector string, parentConvs []string) {
+		node, exists := tree.Nodes[nodeID]
+		if !exists || visited[nodeID] {
+			return
+		}
+		visited[nodeID] = true
+
+		line := labels[nodeID]
+		if !equalStrings(node.Conversations, parentConvs) && len(node.Conversations) > 0 {
+			line += "  [" + strings.Join(node.Conversations, ", ") + "]"
+		}
+		if indexes := conversationIndexes(tree, node); color && len(indexes) > 0 {
+			line = ansiColors[indexes[0]%len(ansiColors)] + line + ansiReset
+		}
+		sb.WriteString(prefix + connector + line + "\n")
+
+		childPrefix := prefix
+		switch connector {
+		case "├── ":
+			childPrefix += "│   "
+		case "└── ":
+			childPrefix += "    "
+		}
+		for i, childID := range node.Children {
+			childConnector := "├── "
+			if i == len(node.Children)-1 {
+				childConnector = "└── "
+			}
+			visit(childID, childPrefix, childConnector, node.Conversations)
+		}
+	}
+
+	for _, nodeID := range treeOrder(tree) {
+		if !visited[nodeID] {
+			visit(nodeID, "", "", nil)
+		}
+	}
+
+	sb.WriteString("\n对话:\n")
+	for i, convID := range tree.Conversations {
+		line := "  " + convID
+		if color {
+			line = ansiColors[i%len(ansiColors)] + line + ansiReset
+		}
+		sb.WriteString(line + "\n")
+	}
+	return sb.String()
+}
+
+// 对话在树中的路径：从根节点开始，每一步选择属于该对话的子节点
+func conversationPath(tree *Tree, convID string) []string {
+	belongs := func(nodeID string) bool {
+		node, exists := tree.Nodes[nodeID]
+		return exists && (len(node.Conversations) == 0 || contains(node.Conversations, convID))
+	}
+
+	var path []string
+	visited := make(map[string]bool)
+	for nodeID := tree.Root; nodeID != "" && belongs(nodeID) && !visited[nodeID]; {
+		visited[nodeID] = true
+		path = append(path, nodeID)
+		next := ""
+		for _, childID := range tree.Nodes[nodeID].Children {
+			if belongs(childID) {
+				next = childID
+				break
+			}
+		}
+		nodeID = next
+	}
+	return path
+}
+
+// 比较两个对话的分支：找到分叉节点，并排显示分叉后的消息差异，统计各分支在分叉后继续的轮数
+func diffConversations(tree *Tree, convA, convB string, contents map[string]ConversationNode, width int) (string, error) {
+	for _, convID := range []string{convA, convB} {
+		if !contains(tree.Conversations, convID) {
+			return "", fmt.Errorf("对话 %s 不在树中", convID)
+		}
+	}
+	if convA == convB {
+		return "", fmt.Errorf("需要两个不同的对话")
+	}
+
+	pathA, pathB := conversationPath(tree, convA), conversationPath(tree, convB)
+	common := 0
+	for common < len(pathA) && common < len(pathB) && pathA[common] == pathB[common] {
+		common++
+	}
+	restA, restB := pathA[common:], pathB[common:]
+
+	var sb strings.Builder
+	sb.WriteString(fmt.Sprintf("对话 A: %s（%d 条消息）\n", convA, len(pathA)))
+	sb.WriteString(fmt.Sprintf("对话 B: %s（%d 条消息）\n", convB, len(pathB)))
+	if common == 0 {
+		sb.WriteString("分叉节点: 无（两个对话从根节点起就不同）\n")
+	} else {
+		fork := pathA[common-1]
+		sb.WriteString(fmt.Sprintf("分叉节点: %s（%s）\n", fork, nodeLabel(fork, contents, 40)))
+	}
+	sb.WriteString(fmt.Sprintf("共同消息: %d 条\n", common))
+	sb.WriteString(fmt.Sprintf("分叉后 A: %d 条消息, %d 轮\n", len(restA), countRounds(restA, contents)))
+	sb.WriteString(fmt.Sprintf("分叉后 B: %d 条消息, %d 轮\n", len(restB), countRounds(restB, contents)))
+
+	if len(restA) == 0 && len(restB) == 0 {
+		sb.WriteString("\n两个对话完全相同\n")
+		return sb.String(), nil
+	}
+
+	// 分叉后的消息按位置配对，逐条并排比较
+	column := (width - 3) / 2
+	if column < 10 {
+		column = 10
+	}
+	for i := 0; i < len(restA) || i < len(restB); i++ {
+		var left, right []string
+		titleA, titleB := "（分支已结束）", "（分支已结束）"
+		if i < len(restA) {
+			titleA, left = messageLines(restA[i], contents)
+		}
+		if i < len(restB) {
+			titleB, right = messageLines(restB[i], contents)
+		}
+		sb.WriteString("\n" + strings.Repeat("=", column*2+3) + "\n")
+		sb.WriteString(sideBySideRow(fmt.Sprintf("A#%d %s", i+1, titleA), " ", fmt.Sprintf("B#%d %s", i+1, titleB), column))
+		sb.WriteString(strings.Repeat("-", column*2+3) + "\n")
+		for _, line := range lineDiff(left, right) {
+			sb.WriteString(sideBySideRow(line.Left, line.Op, line.Right, column))
+		}
+	}
+	return sb.String(), nil
+}
+
+// 统计路径中的用户消息数（轮数）
+func countRounds(path []string, contents map[string]ConversationNode) int {
+	rounds := 0
+	for _, nodeID := range path {
+		if role := contents[nodeID].Role; role == "user" || role == "human" {
+			rounds++
+		}
+	}
+	return rounds
+}
+
+// 消息标题（角色和节点ID）和正文行
+func messageLines(nodeID string, contents map[string]ConversationNode) (string, []string) {
+	node, exists := contents[nodeID]
+	if !exists {
+		return nodeID, nil
+	}
+	role := node.Role
+	if title, ok := roleTitles[role]; ok {
+		role = title
+	}
+	text := node.Content
+	if text == "" {
+		text = nodeLabel(nodeID, contents, 0)
+	}
+	return role + " " + nodeID, strings.Split(strings.TrimRight(text, "\n"), "\n")
+}
+
+// 并排差异中的一行，Op: " " 相同，"|" 修改，"<" 仅左侧，">" 仅右侧
+type diffLine struct {
+	Left  string
+	Op    string
+	Right string
+}
+
+// 基于最长公共子序列的逐行差异，相邻的删除和新增配对为修改
+func lineDiff(a, b []string) []diffLine {
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+
+	var lines []diffLine
+	var removed, added []string
+	flush := func() {
+		for k := 0; k < len(removed) || k < len(added); k++ {
+			switch {
+			case k < len(removed) && k < len(added):
+				lines = append(lines, diffLine{removed[k], "|", added[k]})
+			case k < len(removed):
+				lines = append(lines, diffLine{removed[k], "<", ""})
+			default:
+				lines = append(lines, diffLine{"", ">", added[k]})
+			}
+		}
+		removed, added = nil, nil
+	}
+
+	i, j := 0, 0
+	for i < len(a) && j < len(b) {
+		switch {
+		case a[i] == b[j]:
+			flush()
+			lines = append(lines, diffLine{a[i], " ", b[j]})
+			i++
+			j++
+		case lcs[i+1][j] >= lcs[i][j+1]:
+			removed = append(removed, a[i])
+			i++
+		default:
+			added = append(added, b[j])
+			j++
+		}
+	}
+	removed = append(removed, a[i:]...)
+	added = append(added, b[j:]...)
+	flush()
+	return lines
+}
+
+// 并排输出一行，超出列宽的内容折行显示
+func sideBySideRow(left, op, right string, column int) string {
+	leftParts, rightParts := wrapWidth(left, column), wrapWidth(right, column)
+	var sb strings.Builder
+	for k := 0; k < len(leftParts) || k < len(rightParts); k++ {
+		l, r := "", ""
+		if k < len(leftParts) {
+			l = leftParts[k]
+		}
+		if k < len(rightParts) {
+			r = rightParts[k]
+		}
+		marker := op
+		if k > 0 {
+			marker = " "
+		}
+		row := l + strings.Repeat(" ", column-displayWidth(l)) + " " + marker + " " + r
+		sb.WriteString(strings.TrimRight(row, " ") + "\n")
+	}
+	return sb.String()
+}
+
+// 按显示宽度折行（中文等宽字符占两列）
+func wrapWidth(text string, width int) []string {
+	text = strings.ReplaceAll(text, "\t", "    ")
+	if text == "" {
+		return []string{""}
+	}
+	var parts []string
+	var current strings.Builder
+	currentWidth := 0
+	for _, r := range text {
+		w := runeWidth(r)
+		if currentWidth+w > width {
+			parts = append(parts, current.String())
+			current.Reset()
+			currentWidth = 0
+		}
+		current.WriteRune(r)
+		currentWidth += w
+	}
+	return append(parts, current.String())
+}
+
+func displayWidth(text string) int {
+	width := 0
+	for _, r := range text {
+		width += runeWidth(r)
+	}
+	return width
+}
+
+func runeWidth(r rune) int {
+	switch {
+	case r >= 0x1100 && r <= 0x115F,
+		r >= 0x2E80 && r <= 0xA4CF,
+		r >= 0xAC00 && r <= 0xD7A3,
+		r >= 0xF900 && r <= 0xFAFF,
+		r >= 0xFE30 && r <= 0xFE4F,
+		r >= 0xFF00 && r <= 0xFF60,
+		r >= 0xFFE0 && r <= 0xFFE6,
+		r >= 0x1F300 && r <= 0x1FAFF,
+		r >= 0x20000 && r <= 0x3FFFD:
+		return 2
+	}
+	return 1
+}
+
+// 检查对话是否可以合并到树
+func canMerge(tree *Tree, conversation []ConversationNode) bool {
+	for _, node := range conversation {
+		if _, exists := tree.Nodes[node.ID]; exists {
+			return true
+		}
+	}
+	return false
+}
+
+// 树统计信息
+type TreeStats struct {
+	NodeCount          int           `json:"node_count"`
+	ConversationCount  int           `json:"conversation_count"`
+	MaxDepth           int           `json:"max_depth"` // 根节点到最深叶子节点路径上的节点数
+	LeafCount          int           `json:"leaf_count"`
+	ForkCount          int           `json:"fork_count"`        // 子节点多于一个的节点数
+	CommonNodeCount    int           `json:"common_node_count"` // 所有对话共有的节点数
+	CommonPercentage   float64       `json:"common_percentage"` // 共同节点数占最大深度的百分比
+	BranchingHistogram map[int]int   `json:"branching_histogram"`
+	Forks              []ForkStats   `json:"forks"`
+	Branches           []BranchStats `json:"branches"`
+}
+
+// 分叉节点统计
+type ForkStats struct {
+	Node     string `json:"node"`
+	Depth    int    `json:"depth"`
+	Children int    `json:"children"`
+}
+
+// 单个对话分支的统计，分叉节点为该对话与其他对话共有的最后一个节点
+type BranchStats struct {
+	Conversation      string `json:"conversation"`
+	Length            int    `json:"length"`
+	ForkNode          string `json:"fork_node,omitempty"`
+	ForkDepth         int    `json:"fork_depth"`
+	MessagesAfterFork int    `json:"messages_after_fork"`
+	RoundsAfterFork   int    `json:"rounds_after_fork"`
+}
+
+// 计算树的统计信息，contents 用于统计分叉后的轮数（按角色计数，缺少内容的节点不计）
+func computeTreeStats(tree *Tree, contents map[string]ConversationNode) TreeStats {
+	stats := TreeStats{
+		NodeCount:          len(tree.Nodes),
+		ConversationCount:  len(tree.Conversations),
+		BranchingHistogram: make(map[int]int),
+		Forks:              []ForkStats{},
+		Branches:           []BranchStats{},
+	}
+
+	// 前序遍历保证父节点先于子节点，深度从1开始
+	depth := make(map[string]int, len(tree.Nodes))
+	for _, nodeID := range treeOrder(tree) {
+		node := tree.Nodes[nodeID]
+		depth[nodeID] = depth[node.Parent] + 1
+		if depth[nodeID] > stats.MaxDepth {
+			stats.MaxDepth = depth[nodeID]
+		}
+
+		stats.BranchingHistogram[len(node.Children)]++
+		if len(node.Children) == 0 {
+			stats.LeafCount++
+		}
+		if len(node.Children) > 1 {
+			stats.ForkCount++
+			stats.Forks = append(stats.Forks, ForkStats{Node: nodeID, Depth: depth[nodeID], Children: len(node.Children)})
+		}
+		if len(node.Conversations) == 0 {
+			stats.CommonNodeCount++
+		}
+	}
+	if stats.MaxDepth > 0 {
+		stats.CommonPercentage = float64(stats.CommonNodeCount) / float64(stats.MaxDepth) * 100
+	}
+
+	// 节点被其他对话共享：未记录成员（所有对话共有）且树中不止一个对话，或成员多于一个
+	shared := func(nodeID string) bool {
+		members := tree.Nodes[nodeID].Conversations
+		return (len(members) == 0 && len(tree.Conversations) > 1) || len(members) > 1
+	}
+	for _, convID := range tree.Conversations {
+		path := conversationPath(tree, convID)
+		forkDepth := 0
+		for i, nodeID := range path {
+			if shared(nodeID) {
+				forkDepth = i + 1
+			}
+		}
+		branch := BranchStats{
+			Conversation:      convID,
+			Length:            len(path),
+			ForkDepth:         forkDepth,
+			MessagesAfterFork: len(path) - forkDepth,
+			RoundsAfterFork:   countRounds(path[forkDepth:], contents),
+		}
+		if forkDepth > 0 {
+			branch.ForkNode = path[forkDepth-1]
+		}
+		stats.Branches = append(stats.Branches, branch)
 	}
 
 	return stats
 }
 
+// 统计信息的文本输出，detail 为 false 时只输出概要
+func formatTreeStats(stats TreeStats, detail bool) string {
+	var sb strings.Builder
+	sb.WriteString(fmt.Sprintf("节点数量: %d\n", stats.NodeCount))
+	sb.WriteString(fmt.Sprintf("对话数量: %d\n", stats.ConversationCount))
+	sb.WriteString(fmt.Sprintf("最大深度: %d\n", stats.MaxDepth))
+	sb.WriteString(fmt.Sprintf("分叉数量: %d\n", stats.ForkCount))
+	sb.WriteString(fmt.Sprintf("共同节点数量: %d\n", stats.CommonNodeCount))
+	sb.WriteString(fmt.Sprintf("共同节点占比: %.2f%%\n", stats.CommonPercentage))
+	if !detail {
+		return sb.String()
+	}
+
+	sb.WriteString(fmt.Sprintf("叶子节点数量: %d\n", stats.LeafCount))
+	sb.WriteString("\n分支因子分布:\n")
+	var factors []int
+	for factor := range stats.BranchingHistogram {
+		factors = append(factors, factor)
+	}
+	sort.Ints(factors)
+	for _, factor := range factors {
+		sb.WriteString(fmt.Sprintf("  %d 个子节点: %d\n", factor, stats.BranchingHistogram[factor]))
+	}
+
+	if len(stats.Forks) > 0 {
+		sb.WriteString("\n分叉节点:\n")
+		for _, fork := range stats.Forks {
+			sb.WriteString(fmt.Sprintf("  %s（深度 %d，%d 个分支）\n", fork.Node, fork.Depth, fork.Children))
+		}
+	}
+
+	sb.WriteString("\n各对话分支:\n")
+	for _, branch := range stats.Branches {
+		fork := "无共同节点"
+		if branch.ForkNode != "" {
+			fork = fmt.Sprintf("分叉于 %s（深度 %d）", branch.ForkNode, branch.ForkDepth)
+		}
+		sb.WriteString(fmt.Sprintf("  %s: %d 条消息，%s，分叉后 %d 条消息 / %d 轮\n",
+			branch.Conversation, branch.Length, fork, branch.MessagesAfterFork, branch.RoundsAfterFork))
+	}
+	return sb.String()
+}
+
 // 辅助函数：检查字符串是否在切片中
 func contains(slice []string, str string) bool {
 	for _, item := range slice {
@@ -463,6 +1826,30 @@ func contains(slice []string, str string) bool {
 	return false
 }
 
+// 辅助函数：过滤掉集合中的字符串，返回新切片
+func filterStrings(slice []string, excluded map[string]bool) []string {
+	result := []string{}
+	for _, item := range slice {
+		if !excluded[item] {
+			result = append(result, item)
+		}
+	}
+	return result
+}
+
+// 辅助函数：比较两个字符串切片是否相同
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
 // 辅助函数：字符串切片去重
 func uniqueStrings(slice []string) []string {
 	seen := make(map[string]bool)
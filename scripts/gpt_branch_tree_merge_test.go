@@ -0,0 +1,549 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// 运行方式（scripts 目录下各工具都是独立的 main 包，需要指定文件）:
+//
+//	go test -v gpt_branch_tree_merge.go gpt_branch_tree_merge_test.go
+//	go test -run xxx -bench . gpt_branch_tree_merge.go gpt_branch_tree_merge_test.go
+//	TREE_PERF_TEST=1 go test -run ScalesLinearly gpt_branch_tree_merge.go gpt_branch_tree_merge_test.go
+
+// chain 生成一条消息链，第一个节点的父节点为 parentID
+func chain(parentID string, ids ...string) []ConversationNode {
+	nodes := make([]ConversationNode, 0, len(ids))
+	for _, id := range ids {
+		nodes = append(nodes, ConversationNode{ID: id, ParentID: parentID, Role: "user"})
+		parentID = id
+	}
+	return nodes
+}
+
+// syntheticConversations 生成一棵共享主干的合成树：主干长 trunk，之后分出 branches 个分支，每个分支长 length
+// 每个对话是从根到某个分支末端的路径
+func syntheticConversations(trunk, branches, length int) ([][]ConversationNode, []string) {
+	trunkIDs := make([]string, trunk)
+	for i := range trunkIDs {
+		trunkIDs[i] = fmt.Sprintf("t%d", i)
+	}
+	trunkNodes := chain("", trunkIDs...)
+
+	conversations := make([][]ConversationNode, 0, branches)
+	conversationIDs := make([]string, 0, branches)
+	for b := 0; b < branches; b++ {
+		ids := make([]string, length)
+		for i := range ids {
+			ids[i] = fmt.Sprintf("b%d-%d", b, i)
+		}
+		conv := append(append([]ConversationNode(nil), trunkNodes...), chain(trunkIDs[trunk-1], ids...)...)
+		conversations = append(conversations, conv)
+		conversationIDs = append(conversationIDs, fmt.Sprintf("conv-%d", b))
+	}
+	return conversations, conversationIDs
+}
+
+func TestFindCommonRoot(t *testing.T) {
+	conversations := [][]ConversationNode{
+		chain("", "a", "b", "c"),
+		append(chain("", "a", "b"), chain("b", "d")...),
+		// 缺少根节点 a 的对话，共同根下移到 b
+		append(chain("a", "b"), chain("b", "e")...),
+	}
+
+	root, err := findCommonRoot(conversations)
+	if err != nil {
+		t.Fatalf("findCommonRoot 失败: %v", err)
+	}
+	if root != "b" {
+		t.Errorf("共同根节点 = %s, 期望 b", root)
+	}
+
+	if _, err := findCommonRoot([][]ConversationNode{chain("", "a"), chain("", "x")}); err == nil {
+		t.Error("没有共同节点时应返回错误")
+	}
+}
+
+func TestCreateTreeChildrenOrder(t *testing.T) {
+	conversations := [][]ConversationNode{
+		append(chain("", "root", "q"), chain("q", "a1")...),
+		append(chain("", "root", "q"), chain("q", "b1")...),
+		append(chain("", "root", "q"), chain("q", "c1")...),
+	}
+	ids := []string{"conv-a", "conv-b", "conv-c"}
+
+	// 多次构建结果一致，children 按首次出现顺序排列
+	for i := 0; i < 20; i++ {
+		tree, err := createTreeFromConversations(conversations, ids)
+		if err != nil {
+			t.Fatalf("createTreeFromConversations 失败: %v", err)
+		}
+		if tree.Root != "root" {
+			t.Fatalf("根节点 = %s, 期望 root", tree.Root)
+		}
+		if got := tree.Nodes["q"].Children; !reflect.DeepEqual(got, []string{"a1", "b1", "c1"}) {
+			t.Fatalf("children = %v, 期望 [a1 b1 c1]", got)
+		}
+		if got := tree.Nodes["b1"].Conversations; !reflect.DeepEqual(got, []string{"conv-b"}) {
+			t.Fatalf("b1 所属对话 = %v, 期望 [conv-b]", got)
+		}
+		if tree.Nodes["q"].Conversations != nil {
+			t.Fatalf("共同节点不应记录所属对话: %v", tree.Nodes["q"].Conversations)
+		}
+	}
+}
+
+func TestMergeConversationsToTree(t *testing.T) {
+	tree, err := createTreeFromConversations([][]ConversationNode{
+		chain("", "root", "a"),
+		chain("", "root", "b"),
+	}, []string{"conv-a", "conv-b"})
+	if err != nil {
+		t.Fatalf("createTreeFromConversations 失败: %v", err)
+	}
+
+	// 新对话中子节点先于父节点出现
+	conv := []ConversationNode{
+		{ID: "root"},
+		{ID: "c2", ParentID: "c1"},
+		{ID: "c1", ParentID: "root"},
+	}
+	tree, err = mergeConversationsToTree(tree, [][]ConversationNode{conv}, []string{"conv-c"})
+	if err != nil {
+		t.Fatalf("mergeConversationsToTree 失败: %v", err)
+	}
+
+	if !reflect.DeepEqual(tree.Conversations, []string{"conv-a", "conv-b", "conv-c"}) {
+		t.Errorf("对话列表 = %v", tree.Conversations)
+	}
+	if got := tree.Nodes["root"].Children; !reflect.DeepEqual(got, []string{"a", "b", "c1"}) {
+		t.Errorf("root children = %v, 期望 [a b c1]", got)
+	}
+	if got := tree.Nodes["c1"].Children; !reflect.DeepEqual(got, []string{"c2"}) {
+		t.Errorf("c1 children = %v, 期望 [c2]", got)
+	}
+	if got := tree.Nodes["a"].Conversations; !reflect.DeepEqual(got, []string{"conv-a"}) {
+		t.Errorf("a 所属对话 = %v, 期望 [conv-a]", got)
+	}
+}
+
+func TestMergeReaddedConversation(t *testing.T) {
+	tree, err := createTreeFromConversations([][]ConversationNode{
+		chain("", "root", "a1", "a2"),
+		chain("", "root", "b1"),
+	}, []string{"conv-a", "conv-b"})
+	if err != nil {
+		t.Fatalf("createTreeFromConversations 失败: %v", err)
+	}
+
+	// conv-a 内容变化后重新合并：旧节点 a1、a2 不再被引用，应被剪除
+	tree, err = mergeConversationsToTree(tree, [][]ConversationNode{chain("", "root", "a3")}, []string{"conv-a"})
+	if err != nil {
+		t.Fatalf("mergeConversationsToTree 失败: %v", err)
+	}
+
+	if !reflect.DeepEqual(tree.Conversations, []string{"conv-a", "conv-b"}) {
+		t.Errorf("对话列表 = %v", tree.Conversations)
+	}
+	if _, exists := tree.Nodes["a1"]; exists {
+		t.Error("a1 应被剪除")
+	}
+	if got := tree.Nodes["root"].Children; !reflect.DeepEqual(got, []string{"b1", "a3"}) {
+		t.Errorf("root children = %v, 期望 [b1 a3]", got)
+	}
+	if got := tree.Nodes["a3"].Conversations; !reflect.DeepEqual(got, []string{"conv-a"}) {
+		t.Errorf("a3 所属对话 = %v, 期望 [conv-a]", got)
+	}
+	if tree.Nodes["root"].Conversations != nil {
+		t.Errorf("共同节点不应记录所属对话: %v", tree.Nodes["root"].Conversations)
+	}
+}
+
+func TestRemoveConversationsFromTree(t *testing.T) {
+	tree, err := createTreeFromConversations([][]ConversationNode{
+		chain("", "root", "x", "a1"),
+		chain("", "root", "x", "b1"),
+		chain("", "root", "c1"),
+	}, []string{"conv-a", "conv-b", "conv-c"})
+	if err != nil {
+		t.Fatalf("createTreeFromConversations 失败: %v", err)
+	}
+
+	pruned, err := removeConversationsFromTree(tree, []string{"conv-c"})
+	if err != nil {
+		t.Fatalf("removeConversationsFromTree 失败: %v", err)
+	}
+	if pruned != 1 {
+		t.Errorf("剪除节点数 = %d, 期望 1", pruned)
+	}
+	if got := tree.Nodes["root"].Children; !reflect.DeepEqual(got, []string{"x"}) {
+		t.Errorf("root children = %v, 期望 [x]", got)
+	}
+	// x 现在是剩余所有对话的共同节点
+	if tree.Nodes["x"].Conversations != nil {
+		t.Errorf("x 所属对话 = %v, 期望为空", tree.Nodes["x"].Conversations)
+	}
+	if got := tree.Nodes["b1"].Conversations; !reflect.DeepEqual(got, []string{"conv-b"}) {
+		t.Errorf("b1 所属对话 = %v, 期望 [conv-b]", got)
+	}
+
+	if _, err := removeConversationsFromTree(tree, []string{"conv-x"}); err == nil {
+		t.Error("移除不在树中的对话应返回错误")
+	}
+	if _, err := removeConversationsFromTree(tree, []string{"conv-a", "conv-b"}); err == nil {
+		t.Error("移除全部对话应返回错误")
+	}
+}
+
+func TestRenderTree(t *testing.T) {
+	tree, err := createTreeFromConversations([][]ConversationNode{
+		chain("", "root", "a1"),
+		chain("", "root", "b1"),
+	}, []string{"conv-a", "conv-b"})
+	if err != nil {
+		t.Fatalf("createTreeFromConversations 失败: %v", err)
+	}
+	labels := map[string]string{"root": `用户: "问题"`, "a1": "助手: 回答A", "b1": "助手: 回答B"}
+
+	ascii := renderASCII(tree, labels, false)
+	want := "用户: \"问题\"\n├── 助手: 回答A  [conv-a]\n└── 助手: 回答B  [conv-b]\n"
+	if !strings.HasPrefix(ascii, want) {
+		t.Errorf("ASCII 输出:\n%s\n期望前缀:\n%s", ascii, want)
+	}
+
+	dot := renderDOT(tree, labels)
+	for _, expected := range []string{`"root" [label="用户: \"问题\""`, `"root" -> "a1" [color="#1f77b4"`, `"root" -> "b1" [color="#d62728"`} {
+		if !strings.Contains(dot, expected) {
+			t.Errorf("DOT 输出缺少 %s:\n%s", expected, dot)
+		}
+	}
+
+	mermaid := renderMermaid(tree, labels)
+	for _, expected := range []string{`n0["用户: #quot;问题#quot;"]`, "n0 --> n1", "linkStyle 1 stroke:#d62728"} {
+		if !strings.Contains(mermaid, expected) {
+			t.Errorf("Mermaid 输出缺少 %s:\n%s", expected, mermaid)
+		}
+	}
+}
+
+func TestLineDiff(t *testing.T) {
+	got := lineDiff(
+		[]string{"第一步", "使用索引", "完成"},
+		[]string{"第一步", "使用缓存", "补充说明", "完成"},
+	)
+	want := []diffLine{
+		{"第一步", " ", "第一步"},
+		{"使用索引", "|", "使用缓存"},
+		{"", ">", "补充说明"},
+		{"完成", " ", "完成"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("lineDiff = %v, 期望 %v", got, want)
+	}
+}
+
+func TestDiffConversations(t *testing.T) {
+	tree, err := createTreeFromConversations([][]ConversationNode{
+		chain("", "root", "x", "a1", "a2"),
+		chain("", "root", "x", "b1"),
+	}, []string{"conv-a", "conv-b"})
+	if err != nil {
+		t.Fatalf("createTreeFromConversations 失败: %v", err)
+	}
+	contents := map[string]ConversationNode{
+		"root": {ID: "root", Role: "user", Content: "问题"},
+		"x":    {ID: "x", Role: "assistant", Content: "回答"},
+		"a1":   {ID: "a1", Role: "user", Content: "追问A"},
+		"a2":   {ID: "a2", Role: "assistant", Content: "回答A"},
+		"b1":   {ID: "b1", Role: "user", Content: "追问B"},
+	}
+
+	report, err := diffConversations(tree, "conv-a", "conv-b", contents, 80)
+	if err != nil {
+		t.Fatalf("diffConversations 失败: %v", err)
+	}
+	for _, expected := range []string{"分叉节点: x（助手: 回答）", "共同消息: 2 条", "分叉后 A: 2 条消息, 1 轮", "分叉后 B: 1 条消息, 1 轮", "追问A", "| 追问B"} {
+		if !strings.Contains(report, expected) {
+			t.Errorf("差异报告缺少 %q:\n%s", expected, report)
+		}
+	}
+
+	if _, err := diffConversations(tree, "conv-a", "conv-x", contents, 80); err == nil {
+		t.Error("对话不在树中时应返回错误")
+	}
+}
+
+// TestCreateTreeScalesLinearly 节点数增加 10 倍时耗时不应接近平方增长
+// 依赖机器负载的耗时比较，只在设置 TREE_PERF_TEST=1 时运行；日常回归用 Benchmark 观察
+func TestCreateTreeScalesLinearly(t *testing.T) {
+	if os.Getenv("TREE_PERF_TEST") == "" {
+		t.Skip("未设置 TREE_PERF_TEST，跳过耗时比较")
+	}
+
+	measure := func(branches int) time.Duration {
+		conversations, ids := syntheticConversations(100, branches, 100)
+		best := time.Duration(0)
+		for i := 0; i < 3; i++ {
+			start := time.Now()
+			if _, err := createTreeFromConversations(conversations, ids); err != nil {
+				t.Fatalf("createTreeFromConversations 失败: %v", err)
+			}
+			if elapsed := time.Since(start); best == 0 || elapsed < best {
+				best = elapsed
+			}
+		}
+		return best
+	}
+
+	small, large := measure(10), measure(100)
+	if ratio := float64(large) / float64(small); ratio > 40 {
+		t.Errorf("节点数增加 10 倍耗时增加 %.1f 倍（%v -> %v）", ratio, small, large)
+	}
+}
+
+func TestAlignByContent(t *testing.T) {
+	// 分叉的会话消息ID不同，前两条消息内容相同（空白差异忽略）
+	sessionA := []ConversationNode{
+		{ID: "a0", Role: "user", Content: "修复  登录问题"},
+		{ID: "a1", ParentID: "a0", Role: "assistant", ToolData: map[string]interface{}{"id": "call-1", "name": "Bash", "input": map[string]interface{}{"command": "ls"}, "output": "main.go"}},
+		{ID: "a2", ParentID: "a1", Role: "user", Content: "方案一"},
+	}
+	sessionB := []ConversationNode{
+		{ID: "b0", Role: "user", Content: "修复 登录问题"},
+		{ID: "b1", ParentID: "b0", Role: "assistant", ToolData: map[string]interface{}{"id": "call-2", "name": "Bash", "input": map[string]interface{}{"command": "ls"}, "output": "main.go"}},
+		{ID: "b2", ParentID: "b1", Role: "user", Content: "方案二"},
+	}
+	// 恢复的会话只包含新消息，父节点指向 sessionA
+	resumed := []ConversationNode{
+		{ID: "r0", ParentID: "a2", Role: "assistant", Content: "继续"},
+	}
+
+	conversations := [][]ConversationNode{sessionA, sessionB, resumed}
+	ids := []string{"conv-a", "conv-b", "conv-r"}
+	alignment := alignByContent(nil, conversations)
+	tree, err := createTreeFromConversations(conversations, ids)
+	if err != nil {
+		t.Fatalf("createTreeFromConversations 失败: %v", err)
+	}
+	alignment.annotate(tree)
+
+	if tree.Root != "a0" || tree.Strategy != "content" {
+		t.Fatalf("根节点 = %s, 策略 = %s", tree.Root, tree.Strategy)
+	}
+	if got := tree.Nodes["a1"].Children; !reflect.DeepEqual(got, []string{"a2", "b2"}) {
+		t.Errorf("a1 children = %v, 期望 [a2 b2]", got)
+	}
+	if got := tree.Nodes["a1"].Aliases; !reflect.DeepEqual(got, []string{"b1"}) {
+		t.Errorf("a1 aliases = %v, 期望 [b1]", got)
+	}
+	if got := tree.Nodes["a2"].Children; !reflect.DeepEqual(got, []string{"r0"}) {
+		t.Errorf("a2 children = %v, 期望 [r0]", got)
+	}
+	if tree.Nodes["a0"].Hash == "" || tree.Nodes["a0"].Hash == tree.Nodes["a1"].Hash {
+		t.Errorf("节点哈希异常: %q %q", tree.Nodes["a0"].Hash, tree.Nodes["a1"].Hash)
+	}
+}
+
+func benchmarkSizes(b *testing.B, run func(b *testing.B, conversations [][]ConversationNode, ids []string)) {
+	// 节点总数约为 100 + branches*100
+	for _, branches := range []int{10, 100, 1000} {
+		conversations, ids := syntheticConversations(100, branches, 100)
+		nodes := 100 + branches*100
+		b.Run(fmt.Sprintf("nodes=%d", nodes), func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				run(b, conversations, ids)
+			}
+			b.ReportMetric(float64(b.Elapsed().Nanoseconds())/float64(b.N)/float64(nodes), "ns/node")
+		})
+	}
+}
+
+func BenchmarkCreateTreeFromConversations(b *testing.B) {
+	benchmarkSizes(b, func(b *testing.B, conversations [][]ConversationNode, ids []string) {
+		if _, err := createTreeFromConversations(conversations, ids); err != nil {
+			b.Fatal(err)
+		}
+	})
+}
+
+func BenchmarkFindCommonRoot(b *testing.B) {
+	benchmarkSizes(b, func(b *testing.B, conversations [][]ConversationNode, ids []string) {
+		if _, err := findCommonRoot(conversations); err != nil {
+			b.Fatal(err)
+		}
+	})
+}
+
+func BenchmarkMergeConversationsToTree(b *testing.B) {
+	benchmarkSizes(b, func(b *testing.B, conversations [][]ConversationNode, ids []string) {
+		// 先用前半部分对话建树，再合并后半部分（建树时间不计入）
+		half := len(conversations) / 2
+		b.StopTimer()
+		tree, err := createTreeFromConversations(conversations[:half], ids[:half])
+		if err != nil {
+			b.Fatal(err)
+		}
+		b.StartTimer()
+		if _, err := mergeConversationsToTree(tree, conversations[half:], ids[half:]); err != nil {
+			b.Fatal(err)
+		}
+	})
+}
+
+func TestComputeTreeStats(t *testing.T) {
+	tree, err := createTreeFromConversations([][]ConversationNode{
+		chain("", "root", "x", "a1", "a2", "a3"),
+		chain("", "root", "x", "b1"),
+		chain("", "root", "c1"),
+	}, []string{"conv-a", "conv-b", "conv-c"})
+	if err != nil {
+		t.Fatalf("createTreeFromConversations 失败: %v", err)
+	}
+	contents := map[string]ConversationNode{
+		"a1": {ID: "a1", Role: "user"},
+		"a2": {ID: "a2", Role: "assistant"},
+		"a3": {ID: "a3", Role: "user"},
+		"b1": {ID: "b1", Role: "user"},
+	}
+
+	stats := computeTreeStats(tree, contents)
+	if stats.NodeCount != 7 || stats.MaxDepth != 5 || stats.LeafCount != 3 || stats.ForkCount != 2 || stats.CommonNodeCount != 1 {
+		t.Errorf("统计错误: %+v", stats)
+	}
+	histogram := map[int]int{0: 3, 1: 2, 2: 2}
+	for factor, count := range histogram {
+		if stats.BranchingHistogram[factor] != count {
+			t.Errorf("%d 个子节点的节点数为 %d，期望 %d", factor, stats.BranchingHistogram[factor], count)
+		}
+	}
+
+	expected := []BranchStats{
+		{Conversation: "conv-a", Length: 5, ForkNode: "x", ForkDepth: 2, MessagesAfterFork: 3, RoundsAfterFork: 2},
+		{Conversation: "conv-b", Length: 3, ForkNode: "x", ForkDepth: 2, MessagesAfterFork: 1, RoundsAfterFork: 1},
+		{Conversation: "conv-c", Length: 2, ForkNode: "root", ForkDepth: 1, MessagesAfterFork: 1, RoundsAfterFork: 0},
+	}
+	if len(stats.Branches) != len(expected) {
+		t.Fatalf("分支数量为 %d，期望 %d", len(stats.Branches), len(expected))
+	}
+	for i, branch := range stats.Branches {
+		if branch != expected[i] {
+			t.Errorf("分支 %d 为 %+v，期望 %+v", i, branch, expected[i])
+		}
+	}
+}
+
+func TestEmbedContents(t *testing.T) {
+	conversations := [][]ConversationNode{
+		chain("", "root", "a1"),
+		chain("", "root", "b1"),
+	}
+	conversations[0][0].Role, conversations[0][0].Content, conversations[0][0].CreateTime = "user", "如何优化  SQL\n查询", "2025-10-14T01:04:12Z"
+	conversations[0][1].Role, conversations[0][1].ContentType = "assistant", "text"
+	conversations[0][1].ToolData = map[string]interface{}{"name": "shell"}
+	tree, err := createTreeFromConversations(conversations, []string{"conv-a", "conv-b"})
+	if err != nil {
+		t.Fatalf("createTreeFromConversations 失败: %v", err)
+	}
+
+	embedContents(tree, conversationContents(conversations), "snippet", 6, true)
+	if tree.Embed != "snippet" {
+		t.Errorf("嵌入方式为 %q，期望 snippet", tree.Embed)
+	}
+	root := tree.Nodes["root"]
+	if root.Role != "user" || root.Content != "如何优化 S..." || root.CreateTime != "2025-10-14T01:04:12Z" {
+		t.Errorf("根节点嵌入内容错误: %+v", root)
+	}
+	if a1 := tree.Nodes["a1"]; a1.Content != "[工具: s..." || a1.ContentType != "text" {
+		t.Errorf("工具节点嵌入内容错误: %+v", a1)
+	}
+
+	// 不覆盖时保留已嵌入的内容
+	embedContents(tree, conversationContents(conversations), "full", 0, false)
+	if content := tree.Nodes["root"].Content; content != "如何优化 S..." {
+		t.Errorf("不覆盖时根节点内容为 %q", content)
+	}
+	embedContents(tree, conversationContents(conversations), "full", 0, true)
+	if content := tree.Nodes["root"].Content; content != "如何优化  SQL\n查询" {
+		t.Errorf("全文嵌入时根节点内容为 %q", content)
+	}
+}
+
+func TestGroupConversations(t *testing.T) {
+	conversations := [][]ConversationNode{
+		chain("", "r", "x", "a1"),
+		chain("", "s", "s1"),
+		chain("", "y", "b1"),
+		chain("", "x", "y"), // 连接第一个和第三个对话
+		chain("", "s2"),
+	}
+
+	got := groupConversations(conversations)
+	want := [][]int{{0, 2, 3}, {1}, {4}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("groupConversations() = %v，期望 %v", got, want)
+	}
+}
+
+func TestDiscoverTreesIndex(t *testing.T) {
+	conversationDir, outputDir := t.TempDir(), t.TempDir()
+	writeConversation := func(id string, nodes []ConversationNode) {
+		data, err := json.Marshal(ConversationFile{Data: nodes})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(conversationDir, id+".json"), data, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writeConversation("a", chain("", "r", "x", "a1"))
+	writeConversation("b", chain("", "r", "x", "b1"))
+	writeConversation("c", chain("", "c1"))
+	// d、e、f 通过共享节点连成一组，但没有三者共同的节点，无法生成树
+	writeConversation("d", chain("", "q"))
+	writeConversation("e", chain("", "q", "w"))
+	writeConversation("f", chain("", "w"))
+
+	// 旧索引中的树：x 已不再是根节点，gone 的对话已被删除，r 仍在使用
+	oldIndex := TreeIndex{Trees: map[string]TreeIndexEntry{
+		"x":    {Conversations: []string{"a", "b"}},
+		"gone": {Conversations: []string{"g", "h"}},
+		"r":    {Conversations: []string{"a", "b"}},
+	}}
+	data, _ := json.Marshal(oldIndex)
+	files := map[string][]byte{treeIndexFile: data, "x.json": []byte("{}"), "gone.json": []byte("{}")}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(outputDir, name), content, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := discoverTrees(conversationDir, outputDir, "id", "", 0); err != nil {
+		t.Fatalf("discoverTrees 失败: %v", err)
+	}
+
+	index := readTreeIndex(outputDir)
+	if len(index.Trees) != 1 || !reflect.DeepEqual(index.Trees["r"].Conversations, []string{"a", "b"}) {
+		t.Errorf("索引中应只有根为 r 的树，实际 %+v", index.Trees)
+	}
+	if !reflect.DeepEqual(index.Conversations, map[string]string{"a": "r", "b": "r"}) {
+		t.Errorf("对话索引错误: %v", index.Conversations)
+	}
+	if len(index.Failed) != 1 || !reflect.DeepEqual(index.Failed[0].Conversations, []string{"d", "e", "f"}) || index.Failed[0].Error == "" {
+		t.Errorf("失败的分组应记录在索引中，实际 %+v", index.Failed)
+	}
+
+	for name, exists := range map[string]bool{"r.json": true, "x.json": false, "gone.json": false} {
+		_, err := os.Stat(filepath.Join(outputDir, name))
+		if exists != (err == nil) {
+			t.Errorf("%s 是否存在: 期望 %v，实际 err=%v", name, exists, err)
+		}
+	}
+}
@@ -0,0 +1,724 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// 映射规则：用类 JSONPath 表达式描述任意 JSON/JSONL 对话导出的结构
+// 表达式语法：$ 表示当前对象，.key / ["key"] 取字段，[n] 取数组下标，[*] 展开数组
+//
+// 处理流程：
+//  1. 读取输入文件（json 为整个文档；jsonl 为每行一个元素的数组）
+//  2. conversations 从文档中选出对话对象，records 从每个对话中选出消息记录
+//  3. 指定 current_id 时，从该消息沿 parent_id 回溯出当前分支，按对话顺序排列（如 Open WebUI 以 id 为键的消息表）
+//  4. 指定 group_by 时，按该字段把同一对话中的记录再拆分为多个对话（如 JSONL 中的 sessionId）
+//  5. fields 中的表达式相对于单条记录求值，生成标准输出节点
+type MappingSpec struct {
+	Source          string            `json:"source"`           // 来源名称，决定默认输出目录 parsed/<source>/conversation
+	Format          string            `json:"format"`           // json 或 jsonl，为空时按扩展名判断
+	Conversations   string            `json:"conversations"`    // 对话选择器，默认 $
+	ConversationID  string            `json:"conversation_id"`  // 对话 id，相对于对话对象
+	Title           string            `json:"title"`            // 对话标题，相对于对话对象
+	Records         string            `json:"records"`          // 记录选择器，相对于对话对象，默认 $[*]
+	CurrentID       string            `json:"current_id"`       // 当前分支末端消息 id，相对于对话对象，需要 fields.id 和 fields.parent_id
+	GroupBy         string            `json:"group_by"`         // 拆分对话的字段，相对于记录
+	Filter          *MappingFilter    `json:"filter"`           // 只保留满足条件的记录
+	Fields          MappingFields     `json:"fields"`           // 记录字段映射
+	RoleMap         map[string]string `json:"role_map"`         // 角色名转换，如 human -> user
+	TimestampFormat string            `json:"timestamp_format"` // unix、unix_ms 或 rfc3339，为空时自动识别
+}
+
+type MappingFilter struct {
+	Path   string   `json:"path"`
+	Values []string `json:"values"`
+}
+
+type MappingFields struct {
+	ID           string `json:"id"`             // 为空时按记录位置生成
+	ParentID     string `json:"parent_id"`      // 为空时按记录顺序串成单链
+	Role         string `json:"role"`           // 角色
+	ContentType  string `json:"content_type"`   // 为空时根据内容推断
+	Content      string `json:"content"`        // 字符串、内容块数组或 {text} 对象
+	Timestamp    string `json:"timestamp"`      // 时间戳
+	ToolName     string `json:"tool_name"`      // 工具调用名称
+	ToolInput    string `json:"tool_input"`     // 工具调用参数
+	ToolCallID   string `json:"tool_call_id"`   // 工具调用 id
+	ToolOutput   string `json:"tool_output"`    // 工具输出
+	ToolResultID string `json:"tool_result_id"` // 工具结果对应的调用 id，存在时合并到调用节点
+}
+
+// 输出节点结构（与GPT格式保持一致）
+type OutputNode struct {
+	ID          string                 `json:"id"`
+	ParentID    string                 `json:"parent_id"`
+	ChildID     string                 `json:"child_id"`
+	Role        string                 `json:"role"`
+	ContentType string                 `json:"content_type"`
+	Content     string                 `json:"content,omitempty"`
+	ToolData    map[string]interface{} `json:"tool_data,omitempty"`
+	CreateTime  *string                `json:"create_time"`
+}
+
+// 输出文件结构
+type OutputFile struct {
+	RoundCount int                    `json:"round_count"` // 对话轮数（user/human消息数量）
+	TotalCount int                    `json:"total_count"` // 总消息数量
+	Metadata   map[string]interface{} `json:"metadata,omitempty"`
+	Data       []OutputNode           `json:"data"`
+}
+
+// 按映射规则选出的一个对话
+type MappedConversation struct {
+	ID      string
+	Title   string
+	Records []interface{}
+}
+
+func main() {
+	// 解析命令行参数
+	mappingFile := flag.String("mapping", "", "映射规则文件路径")
+	inputFile := flag.String("input", "", "输入的JSON/JSONL文件路径")
+	outputDir := flag.String("output", "", "输出目录（默认 parsed/<source>/conversation）")
+	flag.Parse()
+
+	if *mappingFile == "" || *inputFile == "" {
+		fmt.Println("错误: 必须指定映射规则和输入文件")
+		fmt.Println("用法: mapping_conversation_parse -mapping <spec.json> -input <file> [-output <dir>]")
+		os.Exit(1)
+	}
+
+	spec, err := readMappingSpec(*mappingFile)
+	if err != nil {
+		fmt.Printf("读取映射规则失败: %v\n", err)
+		os.Exit(1)
+	}
+
+	if *outputDir == "" {
+		*outputDir = filepath.Join("parsed", spec.Source, "conversation")
+	}
+
+	// 创建输出目录
+	if err := os.MkdirAll(*outputDir, 0755); err != nil {
+		fmt.Printf("创建输出目录失败: %v\n", err)
+		os.Exit(1)
+	}
+
+	doc, err := readDocument(*inputFile, spec.Format)
+	if err != nil {
+		fmt.Printf("读取文件失败: %v\n", err)
+		os.Exit(1)
+	}
+
+	defaultID := strings.TrimSuffix(filepath.Base(*inputFile), filepath.Ext(*inputFile))
+	conversations, err := selectConversations(spec, doc, defaultID)
+	if err != nil {
+		fmt.Printf("应用映射规则失败: %v\n", err)
+		os.Exit(1)
+	}
+
+	if len(conversations) == 0 {
+		fmt.Println("警告: 没有匹配到任何对话")
+		os.Exit(0)
+	}
+
+	fmt.Printf("共匹配到 %d 个对话\n", len(conversations))
+
+	successCount := 0
+	for _, conv := range conversations {
+		outputFile, count, err := processConversation(spec, conv, *outputDir)
+		if err != nil {
+			fmt.Printf("处理对话 %s 失败: %v\n", conv.ID, err)
+			continue
+		}
+		successCount++
+		fmt.Printf("已生成: %s (共 %d 条消息)\n", outputFile, count)
+	}
+
+	fmt.Printf("\n处理完成: 成功 %d/%d\n", successCount, len(conversations))
+}
+
+func readMappingSpec(filename string) (*MappingSpec, error) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	var spec MappingSpec
+	if err := json.Unmarshal(data, &spec); err != nil {
+		return nil, fmt.Errorf("解析JSON失败: %v", err)
+	}
+
+	if spec.Source == "" {
+		return nil, fmt.Errorf("缺少 source")
+	}
+	if spec.Fields.Role == "" || spec.Fields.Content == "" {
+		return nil, fmt.Errorf("fields 中必须指定 role 和 content")
+	}
+	if spec.CurrentID != "" && (spec.Fields.ID == "" || spec.Fields.ParentID == "") {
+		return nil, fmt.Errorf("指定 current_id 时 fields 中必须指定 id 和 parent_id")
+	}
+	if spec.Conversations == "" {
+		spec.Conversations = "$"
+	}
+	if spec.Records == "" {
+		spec.Records = "$[*]"
+	}
+
+	// 提前校验所有表达式
+	paths := []string{spec.Conversations, spec.ConversationID, spec.Title, spec.Records, spec.CurrentID, spec.GroupBy,
+		spec.Fields.ID, spec.Fields.ParentID, spec.Fields.Role, spec.Fields.ContentType, spec.Fields.Content,
+		spec.Fields.Timestamp, spec.Fields.ToolName, spec.Fields.ToolInput, spec.Fields.ToolCallID,
+		spec.Fields.ToolOutput, spec.Fields.ToolResultID}
+	if spec.Filter != nil {
+		paths = append(paths, spec.Filter.Path)
+	}
+	for _, path := range paths {
+		if path == "" {
+			continue
+		}
+		if _, err := parsePath(path); err != nil {
+			return nil, err
+		}
+	}
+
+	return &spec, nil
+}
+
+// readDocument 读取输入文件，jsonl 文件转换为每行一个元素的数组
+func readDocument(filename string, format string) (interface{}, error) {
+	if format == "" {
+		format = "json"
+		if strings.HasSuffix(filename, ".jsonl") {
+			format = "jsonl"
+		}
+	}
+
+	if format == "json" {
+		data, err := ioutil.ReadFile(filename)
+		if err != nil {
+			return nil, err
+		}
+		var doc interface{}
+		if err := json.Unmarshal(data, &doc); err != nil {
+			return nil, fmt.Errorf("解析JSON失败: %v", err)
+		}
+		return doc, nil
+	}
+
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	lines := []interface{}{}
+	scanner := bufio.NewScanner(file)
+	// 增加缓冲区大小以处理长行
+	buf := make([]byte, 0, 1024*1024)
+	scanner.Buffer(buf, 10*1024*1024)
+
+	lineNum := 0
+	for scanner.Scan() {
+		lineNum++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+
+		var record interface{}
+		if err := json.Unmarshal([]byte(line), &record); err != nil {
+			fmt.Printf("警告: 第 %d 行解析失败: %v\n", lineNum, err)
+			continue
+		}
+		lines = append(lines, record)
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return lines, nil
+}
+
+// selectConversations 按规则选出对话及其记录
+func selectConversations(spec *MappingSpec, doc interface{}, defaultID string) ([]MappedConversation, error) {
+	convValues, err := selectAll(spec.Conversations, doc)
+	if err != nil {
+		return nil, err
+	}
+
+	var conversations []MappedConversation
+	for i, convValue := range convValues {
+		id := valueString(selectFirst(spec.ConversationID, convValue))
+		if id == "" {
+			id = defaultID
+			if len(convValues) > 1 {
+				id = fmt.Sprintf("%s-%d", defaultID, i)
+			}
+		}
+
+		records, err := selectAll(spec.Records, convValue)
+		if err != nil {
+			return nil, err
+		}
+		if spec.CurrentID != "" {
+			records = branchRecords(spec.Fields, records, valueString(selectFirst(spec.CurrentID, convValue)))
+		}
+		records = filterRecords(spec.Filter, records)
+
+		conv := MappedConversation{
+			ID:      id,
+			Title:   valueString(selectFirst(spec.Title, convValue)),
+			Records: records,
+		}
+
+		if spec.GroupBy == "" {
+			conversations = append(conversations, conv)
+			continue
+		}
+
+		// 按 group_by 拆分，保持各组首次出现的顺序
+		var groupIDs []string
+		groups := make(map[string][]interface{})
+		for _, record := range records {
+			groupID := valueString(selectFirst(spec.GroupBy, record))
+			if groupID == "" {
+				groupID = id
+			}
+			if _, exists := groups[groupID]; !exists {
+				groupIDs = append(groupIDs, groupID)
+			}
+			groups[groupID] = append(groups[groupID], record)
+		}
+		for _, groupID := range groupIDs {
+			conversations = append(conversations, MappedConversation{
+				ID:      groupID,
+				Title:   conv.Title,
+				Records: groups[groupID],
+			})
+		}
+	}
+
+	return conversations, nil
+}
+
+// branchRecords 从当前消息沿 parent_id 回溯到根，返回按对话顺序排列的当前分支
+// 找不到当前消息时保留原有记录
+func branchRecords(fields MappingFields, records []interface{}, currentID string) []interface{} {
+	byID := make(map[string]interface{}, len(records))
+	for _, record := range records {
+		if id := valueString(selectFirst(fields.ID, record)); id != "" {
+			byID[id] = record
+		}
+	}
+	if _, exists := byID[currentID]; !exists {
+		return records
+	}
+
+	var branch []interface{}
+	seen := make(map[string]bool)
+	for id := currentID; id != "" && !seen[id]; {
+		record, exists := byID[id]
+		if !exists {
+			break
+		}
+		seen[id] = true
+		branch = append(branch, record)
+		id = valueString(selectFirst(fields.ParentID, record))
+	}
+	for i, j := 0, len(branch)-1; i < j; i, j = i+1, j-1 {
+		branch[i], branch[j] = branch[j], branch[i]
+	}
+	return branch
+}
+
+func filterRecords(filter *MappingFilter, records []interface{}) []interface{} {
+	if filter == nil || filter.Path == "" {
+		return records
+	}
+
+	allowed := make(map[string]bool)
+	for _, value := range filter.Values {
+		allowed[value] = true
+	}
+
+	var kept []interface{}
+	for _, record := range records {
+		if allowed[valueString(selectFirst(filter.Path, record))] {
+			kept = append(kept, record)
+		}
+	}
+	return kept
+}
+
+func processConversation(spec *MappingSpec, conv MappedConversation, outputDir string) (string, int, error) {
+	fields := spec.Fields
+	nodes := []OutputNode{}
+	toolIndex := make(map[string]int)        // tool_call_id -> 节点下标
+	skippedParent := make(map[string]string) // 被跳过的记录 id -> 其父记录 id
+	prevID := ""
+
+	for i, record := range conv.Records {
+		id := valueString(selectFirst(fields.ID, record))
+		if id == "" {
+			id = fmt.Sprintf("%s-%d", conv.ID, i)
+		}
+
+		parentID := prevID
+		if fields.ParentID != "" {
+			parentID = valueString(selectFirst(fields.ParentID, record))
+		}
+
+		// 工具结果合并到对应的调用节点，记录被跳过，其子记录挂接到它的父节点上
+		if resultID := valueString(selectFirst(fields.ToolResultID, record)); resultID != "" {
+			if idx, ok := toolIndex[resultID]; ok {
+				nodes[idx].ToolData["output"] = contentText(selectFirst(fields.ToolOutput, record))
+				skippedParent[id] = parentID
+				continue
+			}
+		}
+
+		role := valueString(selectFirst(fields.Role, record))
+		if mapped, ok := spec.RoleMap[role]; ok {
+			role = mapped
+		}
+
+		content := contentText(selectFirst(fields.Content, record))
+		contentType := valueString(selectFirst(fields.ContentType, record))
+
+		var toolData map[string]interface{}
+		if toolName := valueString(selectFirst(fields.ToolName, record)); toolName != "" {
+			toolData = map[string]interface{}{"name": toolName}
+			if input := selectFirst(fields.ToolInput, record); input != nil {
+				toolData["input"] = input
+			}
+			if output := selectFirst(fields.ToolOutput, record); output != nil {
+				toolData["output"] = contentText(output)
+			}
+			if callID := valueString(selectFirst(fields.ToolCallID, record)); callID != "" {
+				toolData["id"] = callID
+				toolIndex[callID] = len(nodes)
+			}
+		} else if resultID := valueString(selectFirst(fields.ToolResultID, record)); resultID != "" {
+			// 找不到对应调用的工具结果单独保留
+			toolData = map[string]interface{}{
+				"tool_use_id": resultID,
+				"output":      contentText(selectFirst(fields.ToolOutput, record)),
+			}
+			if contentType == "" {
+				contentType = "tool_result"
+			}
+		}
+
+		// 空内容的记录跳过，并记录其父节点，使子节点可以挂接到最近的保留祖先上
+		if content == "" && toolData == nil {
+			skippedParent[id] = parentID
+			continue
+		}
+
+		if contentType == "" {
+			contentType = "text"
+			if toolData != nil && content == "" {
+				contentType = "tool_use"
+			}
+		}
+
+		node := OutputNode{
+			ID:          id,
+			ParentID:    parentID,
+			Role:        role,
+			ContentType: contentType,
+			Content:     content,
+			ToolData:    toolData,
+		}
+		if timestamp := formatTimestamp(selectFirst(fields.Timestamp, record), spec.TimestampFormat); timestamp != "" {
+			node.CreateTime = &timestamp
+		}
+
+		nodes = append(nodes, node)
+		prevID = id
+	}
+
+	if len(nodes) == 0 {
+		return "", 0, fmt.Errorf("没有有效的内容节点")
+	}
+
+	// 重新挂接父节点，并根据parent关系补全child_id
+	for i := range nodes {
+		nodes[i].ParentID = resolveParent(nodes[i].ParentID, skippedParent)
+	}
+	linkChildren(nodes)
+
+	// 生成输出文件名
+	outputFile := filepath.Join(outputDir, sanitizeFilename(conv.ID)+".json")
+
+	// 计算统计信息
+	roundCount := 0
+	for _, node := range nodes {
+		if node.Role == "user" || node.Role == "human" {
+			roundCount++
+		}
+	}
+
+	// 构建输出文件结构
+	output := OutputFile{
+		RoundCount: roundCount,
+		TotalCount: len(nodes),
+		Metadata:   map[string]interface{}{"source": spec.Source},
+		Data:       nodes,
+	}
+	if conv.Title != "" {
+		output.Metadata["title"] = conv.Title
+	}
+
+	// 序列化为JSON
+	jsonData, err := json.MarshalIndent(output, "", "  ")
+	if err != nil {
+		return "", 0, fmt.Errorf("序列化JSON失败: %v", err)
+	}
+
+	// 写入文件
+	if err := ioutil.WriteFile(outputFile, jsonData, 0644); err != nil {
+		return "", 0, fmt.Errorf("写入文件失败: %v", err)
+	}
+
+	return outputFile, len(nodes), nil
+}
+
+// 路径中的一段：字段名、数组下标或通配符
+type pathStep struct {
+	Key      string
+	Index    int
+	IsIndex  bool
+	Wildcard bool
+}
+
+// parsePath 解析类 JSONPath 表达式
+func parsePath(path string) ([]pathStep, error) {
+	path = strings.TrimSpace(path)
+	if !strings.HasPrefix(path, "$") {
+		return nil, fmt.Errorf("表达式必须以 $ 开头: %s", path)
+	}
+
+	var steps []pathStep
+	rest := path[1:]
+	for rest != "" {
+		switch rest[0] {
+		case '.':
+			rest = rest[1:]
+			end := strings.IndexAny(rest, ".[")
+			if end == -1 {
+				end = len(rest)
+			}
+			if end == 0 {
+				return nil, fmt.Errorf("表达式中缺少字段名: %s", path)
+			}
+			steps = append(steps, pathStep{Key: rest[:end]})
+			rest = rest[end:]
+		case '[':
+			end := strings.Index(rest, "]")
+			if end == -1 {
+				return nil, fmt.Errorf("表达式中括号不匹配: %s", path)
+			}
+			inner := strings.TrimSpace(rest[1:end])
+			rest = rest[end+1:]
+			switch {
+			case inner == "*":
+				steps = append(steps, pathStep{Wildcard: true})
+			case strings.HasPrefix(inner, "\"") || strings.HasPrefix(inner, "'"):
+				steps = append(steps, pathStep{Key: strings.Trim(inner, "\"'")})
+			default:
+				index, err := strconv.Atoi(inner)
+				if err != nil {
+					return nil, fmt.Errorf("无效的数组下标 %s: %s", inner, path)
+				}
+				steps = append(steps, pathStep{Index: index, IsIndex: true})
+			}
+		default:
+			return nil, fmt.Errorf("无法解析表达式: %s", path)
+		}
+	}
+	return steps, nil
+}
+
+// selectAll 返回表达式匹配到的所有值
+func selectAll(path string, value interface{}) ([]interface{}, error) {
+	steps, err := parsePath(path)
+	if err != nil {
+		return nil, err
+	}
+
+	current := []interface{}{value}
+	for _, step := range steps {
+		var next []interface{}
+		for _, item := range current {
+			switch {
+			case step.Wildcard:
+				if arr, ok := item.([]interface{}); ok {
+					next = append(next, arr...)
+				} else if obj, ok := item.(map[string]interface{}); ok {
+					keys := make([]string, 0, len(obj))
+					for key := range obj {
+						keys = append(keys, key)
+					}
+					sort.Strings(keys)
+					for _, key := range keys {
+						next = append(next, obj[key])
+					}
+				}
+			case step.IsIndex:
+				if arr, ok := item.([]interface{}); ok {
+					index := step.Index
+					if index < 0 {
+						index += len(arr)
+					}
+					if index >= 0 && index < len(arr) {
+						next = append(next, arr[index])
+					}
+				}
+			default:
+				if obj, ok := item.(map[string]interface{}); ok {
+					if v, exists := obj[step.Key]; exists && v != nil {
+						next = append(next, v)
+					}
+				}
+			}
+		}
+		current = next
+	}
+	return current, nil
+}
+
+// selectFirst 返回表达式匹配到的第一个值，表达式为空或无匹配时返回 nil
+// 表达式已在读取映射规则时校验过
+func selectFirst(path string, value interface{}) interface{} {
+	if path == "" {
+		return nil
+	}
+	values, err := selectAll(path, value)
+	if err != nil || len(values) == 0 {
+		return nil
+	}
+	return values[0]
+}
+
+// valueString 将标量值转换为字符串
+func valueString(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case bool:
+		return strconv.FormatBool(v)
+	default:
+		data, _ := json.Marshal(v)
+		return string(data)
+	}
+}
+
+// contentText 提取内容文本：字符串直接返回，内容块数组拼接其中的文本，其余值序列化为JSON
+func contentText(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case []interface{}:
+		var textParts []string
+		for _, item := range v {
+			if text := contentText(item); text != "" {
+				textParts = append(textParts, text)
+			}
+		}
+		return strings.Join(textParts, "\n")
+	case map[string]interface{}:
+		if text, ok := v["text"].(string); ok {
+			return text
+		}
+		if _, ok := v["type"]; ok {
+			// 非文本内容块（如 tool_use）由工具字段单独映射
+			return ""
+		}
+	}
+	return valueString(value)
+}
+
+// formatTimestamp 将时间戳统一为 RFC3339 字符串
+func formatTimestamp(value interface{}, format string) string {
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case float64:
+		// 自动识别时按数值大小区分秒和毫秒
+		if format == "unix_ms" || (format == "" && v > 1e12) {
+			return time.UnixMilli(int64(v)).UTC().Format(time.RFC3339Nano)
+		}
+		sec := int64(v)
+		nsec := int64((v - float64(sec)) * 1e9)
+		return time.Unix(sec, nsec).UTC().Format(time.RFC3339Nano)
+	case string:
+		if format == "unix" || format == "unix_ms" {
+			if n, err := strconv.ParseFloat(v, 64); err == nil {
+				return formatTimestamp(n, format)
+			}
+		}
+		return v
+	}
+	return valueString(value)
+}
+
+// resolveParent 沿被跳过的记录向上查找，返回最近的保留祖先
+func resolveParent(parentID string, skippedParent map[string]string) string {
+	seen := make(map[string]bool)
+	for parentID != "" && !seen[parentID] {
+		next, skipped := skippedParent[parentID]
+		if !skipped {
+			break
+		}
+		seen[parentID] = true
+		parentID = next
+	}
+	return parentID
+}
+
+// linkChildren 根据 parent 关系补全 child_id
+func linkChildren(nodes []OutputNode) {
+	firstChild := make(map[string]string)
+	for _, node := range nodes {
+		if node.ParentID == "" {
+			continue
+		}
+		if _, exists := firstChild[node.ParentID]; !exists {
+			firstChild[node.ParentID] = node.ID
+		}
+	}
+	for i := range nodes {
+		nodes[i].ChildID = firstChild[nodes[i].ID]
+	}
+}
+
+func sanitizeFilename(name string) string {
+	// 替换文件名中的非法字符
+	name = strings.ReplaceAll(name, "/", "_")
+	name = strings.ReplaceAll(name, "\\", "_")
+	name = strings.ReplaceAll(name, ":", "_")
+	name = strings.ReplaceAll(name, "*", "_")
+	name = strings.ReplaceAll(name, "?", "_")
+	name = strings.ReplaceAll(name, "\"", "_")
+	name = strings.ReplaceAll(name, "<", "_")
+	name = strings.ReplaceAll(name, ">", "_")
+	name = strings.ReplaceAll(name, "|", "_")
+	return name
+}
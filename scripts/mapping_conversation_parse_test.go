@@ -0,0 +1,130 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"testing"
+)
+
+// 运行方式（scripts 目录下各工具都是独立的 main 包，需要指定文件）:
+//
+//	go test -v mapping_conversation_parse.go mapping_conversation_parse_test.go
+
+// decodeRecords 将JSON数组解析为记录
+func decodeRecords(t *testing.T, data string) []interface{} {
+	t.Helper()
+	var records []interface{}
+	if err := json.Unmarshal([]byte(data), &records); err != nil {
+		t.Fatalf("解析记录失败: %v", err)
+	}
+	return records
+}
+
+func TestProcessConversationReparenting(t *testing.T) {
+	spec := &MappingSpec{
+		Source: "test",
+		Fields: MappingFields{
+			ID:           "$.id",
+			ParentID:     "$.parent",
+			Role:         "$.role",
+			Content:      "$.text",
+			ToolName:     "$.tool",
+			ToolCallID:   "$.call_id",
+			ToolOutput:   "$.output",
+			ToolResultID: "$.result_for",
+		},
+	}
+
+	tests := []struct {
+		name    string
+		records string
+		parents map[string]string // 节点 id -> 期望的父节点
+	}{
+		{
+			name: "合并到调用的工具结果",
+			records: `[
+				{"id": "a", "role": "user", "text": "列出文件"},
+				{"id": "b", "parent": "a", "role": "assistant", "tool": "ls", "call_id": "c1"},
+				{"id": "r", "parent": "b", "role": "tool", "result_for": "c1", "output": "x.go"},
+				{"id": "c", "parent": "r", "role": "assistant", "text": "只有 x.go"}
+			]`,
+			parents: map[string]string{"a": "", "b": "a", "c": "b"},
+		},
+		{
+			name: "空内容记录",
+			records: `[
+				{"id": "a", "role": "user", "text": "你好"},
+				{"id": "e1", "parent": "a", "role": "assistant", "text": ""},
+				{"id": "e2", "parent": "e1", "role": "assistant"},
+				{"id": "c", "parent": "e2", "role": "assistant", "text": "你好！"}
+			]`,
+			parents: map[string]string{"a": "", "c": "a"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conv := MappedConversation{ID: "conv", Records: decodeRecords(t, tt.records)}
+			outputFile, count, err := processConversation(spec, conv, t.TempDir())
+			if err != nil {
+				t.Fatalf("processConversation 失败: %v", err)
+			}
+			if count != len(tt.parents) {
+				t.Fatalf("节点数量为 %d，期望 %d", count, len(tt.parents))
+			}
+
+			data, err := os.ReadFile(outputFile)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var output OutputFile
+			if err := json.Unmarshal(data, &output); err != nil {
+				t.Fatal(err)
+			}
+			for _, node := range output.Data {
+				expected, exists := tt.parents[node.ID]
+				if !exists {
+					t.Errorf("不应输出节点 %s", node.ID)
+					continue
+				}
+				if node.ParentID != expected {
+					t.Errorf("节点 %s 的父节点为 %q，期望 %q", node.ID, node.ParentID, expected)
+				}
+			}
+		})
+	}
+}
+
+func TestBranchRecords(t *testing.T) {
+	fields := MappingFields{ID: "$.id", ParentID: "$.parentId"}
+	// Open WebUI 的消息表以 id 为键，展开后按键排序而不是对话顺序
+	records := decodeRecords(t, `[
+		{"id": "a-answer", "parentId": "z-question"},
+		{"id": "b-edited", "parentId": "z-question"},
+		{"id": "m-followup", "parentId": "a-answer"},
+		{"id": "z-question", "parentId": null}
+	]`)
+
+	tests := []struct {
+		name      string
+		currentID string
+		expected  []string
+	}{
+		{"当前分支", "m-followup", []string{"z-question", "a-answer", "m-followup"}},
+		{"另一个分支", "b-edited", []string{"z-question", "b-edited"}},
+		{"找不到当前消息时保留原有记录", "missing", []string{"a-answer", "b-edited", "m-followup", "z-question"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			branch := branchRecords(fields, records, tt.currentID)
+			if len(branch) != len(tt.expected) {
+				t.Fatalf("分支长度为 %d，期望 %d", len(branch), len(tt.expected))
+			}
+			for i, record := range branch {
+				if id := valueString(selectFirst(fields.ID, record)); id != tt.expected[i] {
+					t.Errorf("第 %d 条为 %s，期望 %s", i, id, tt.expected[i])
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,780 @@
+package main
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// 解析后的对话文件（parsed/<source>/conversation/<id>.json），兼容各来源的输出格式
+type ConversationFile struct {
+	RoundCount int                    `json:"round_count"`
+	TotalCount int                    `json:"total_count"`
+	ProjectID  string                 `json:"project_id"`
+	Metadata   map[string]interface{} `json:"metadata"`
+	Data       []ConversationNode     `json:"data"`
+}
+
+type ConversationNode struct {
+	ID          string                 `json:"id"`
+	ParentID    string                 `json:"parent_id"`
+	Role        string                 `json:"role"`
+	ContentType string                 `json:"content_type"`
+	Content     string                 `json:"content"`
+	ToolData    map[string]interface{} `json:"tool_data"`
+	Blocks      []ContentBlock         `json:"blocks"`
+	CreateTime  interface{}            `json:"create_time"` // GPT 为 Unix 秒，其他来源为 RFC3339 字符串
+}
+
+type ContentBlock struct {
+	Type     string                 `json:"type"`
+	Text     string                 `json:"text"`
+	ToolData map[string]interface{} `json:"tool_data"`
+}
+
+// 标签、收藏和片段信息（来自数据库导出），格式：
+//
+//	{
+//	  "conversations": {"<uuid>": {"tags": ["监控"], "favorite": true}},
+//	  "favorites": [{"target_type": "message", "target_id": "<message uuid>", "category": "inspiration", "notes": "..."}],
+//	  "fragments": [{"uuid": "...", "conversation_uuid": "...", "message_uuid": "...", "fragment_type": "code", "content": "...", "language": "go"}]
+//	}
+type VaultMeta struct {
+	Conversations map[string]struct {
+		Title    string   `json:"title"`
+		Tags     []string `json:"tags"`
+		Favorite bool     `json:"favorite"`
+	} `json:"conversations"`
+	Favorites []Favorite `json:"favorites"`
+	Fragments []Fragment `json:"fragments"`
+}
+
+type Favorite struct {
+	TargetType string `json:"target_type"` // conversation | round | message | fragment
+	TargetID   string `json:"target_id"`
+	Category   string `json:"category"`
+	Notes      string `json:"notes"`
+}
+
+type Fragment struct {
+	UUID             string `json:"uuid"`
+	ConversationUUID string `json:"conversation_uuid"`
+	MessageUUID      string `json:"message_uuid"`
+	FragmentType     string `json:"fragment_type"`
+	Content          string `json:"content"`
+	Language         string `json:"language"`
+}
+
+// 分支树文件（gpt_branch_tree_merge 输出），只需要其中的对话列表
+type TreeFile struct {
+	Root          string   `json:"root"`
+	Conversations []string `json:"conversations"`
+}
+
+// 待导出的对话
+type VaultNote struct {
+	ID       string
+	Source   string
+	Title    string
+	Conv     *ConversationFile
+	Tags     []string
+	Favorite bool
+	Trees    []string // 所属分支树的根节点ID
+}
+
+// 笔记中属于导出工具管理的区域，区域外的内容（用户的批注等）在重新导出时保留
+const (
+	beginMarker = "<!-- gpt-tools:begin -->"
+	endMarker   = "<!-- gpt-tools:end -->"
+)
+
+// 由导出工具维护的 frontmatter 字段，其余字段在重新导出时保留
+var managedKeys = []string{"id", "title", "source", "created", "updated", "tags", "project", "round_count", "favorite", "trees"}
+
+var roleTitles = map[string]string{
+	"user":      "用户",
+	"human":     "用户",
+	"assistant": "助手",
+	"system":    "系统",
+	"tool":      "工具",
+}
+
+// 各角色使用的 callout 类型
+var roleCallouts = map[string]string{
+	"user":      "question",
+	"human":     "question",
+	"assistant": "note",
+}
+
+func main() {
+	// 解析命令行参数
+	parsedDir := flag.String("parsed", "parsed", "解析结果根目录（包含 <source>/conversation/*.json）")
+	vaultDir := flag.String("vault", "", "Obsidian 仓库中存放对话笔记的目录")
+	sources := flag.String("sources", "", "只导出指定来源，逗号分隔（默认全部）")
+	metaFile := flag.String("meta", "", "标签、收藏和片段信息JSON文件（可选）")
+	treeDirs := flag.String("trees", "", "分支树目录，逗号分隔（默认 <parsed>/*/tree）")
+	flag.Parse()
+
+	if *vaultDir == "" {
+		fmt.Println("错误: 必须指定 Obsidian 仓库目录")
+		fmt.Println("用法: obsidian_vault_export -vault <dir> [-parsed parsed] [-meta <meta.json>] [-sources gpt,claude_code]")
+		os.Exit(1)
+	}
+
+	var meta VaultMeta
+	if *metaFile != "" {
+		data, err := ioutil.ReadFile(*metaFile)
+		if err != nil {
+			fmt.Printf("读取标签和收藏信息失败: %v\n", err)
+			os.Exit(1)
+		}
+		if err := json.Unmarshal(data, &meta); err != nil {
+			fmt.Printf("解析标签和收藏信息失败: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
+	files, err := collectConversationFiles(*parsedDir, splitSet(*sources))
+	if err != nil {
+		fmt.Printf("遍历目录失败: %v\n", err)
+		os.Exit(1)
+	}
+	fmt.Printf("共找到 %d 个对话文件\n", len(files))
+
+	// 读取全部对话，笔记之间的链接需要知道每个对话的来源和标题
+	var notes []*VaultNote
+	noteMap := make(map[string]*VaultNote)
+	messageConv := make(map[string]string) // 消息ID -> 对话ID
+	failed := 0
+	for _, file := range files {
+		conv, err := readConversation(file)
+		if err != nil {
+			failed++
+			fmt.Printf("[失败] %s: %v\n", file, err)
+			continue
+		}
+
+		id := strings.TrimSuffix(filepath.Base(file), ".json")
+		info := meta.Conversations[id]
+		note := &VaultNote{
+			ID:       id,
+			Source:   filepath.Base(filepath.Dir(filepath.Dir(file))),
+			Title:    conversationTitle(id, conv, info.Title),
+			Conv:     conv,
+			Tags:     info.Tags,
+			Favorite: info.Favorite,
+		}
+		notes = append(notes, note)
+		noteMap[id] = note
+		for _, node := range conv.Data {
+			messageConv[node.ID] = id
+		}
+	}
+
+	// 收藏和片段按所属对话归类
+	fragmentConv := make(map[string]string)
+	fragments := make(map[string][]Fragment)
+	for _, fragment := range meta.Fragments {
+		fragmentConv[fragment.UUID] = fragment.ConversationUUID
+		fragments[fragment.ConversationUUID] = append(fragments[fragment.ConversationUUID], fragment)
+	}
+	favorites := make(map[string][]Favorite)
+	unmatched := 0
+	for _, favorite := range meta.Favorites {
+		var convID string
+		switch favorite.TargetType {
+		case "conversation":
+			convID = favorite.TargetID
+		case "message":
+			convID = messageConv[favorite.TargetID]
+		case "fragment":
+			convID = fragmentConv[favorite.TargetID]
+		}
+		note, ok := noteMap[convID]
+		if !ok {
+			unmatched++
+			continue
+		}
+		if favorite.TargetType == "conversation" {
+			note.Favorite = true
+			continue
+		}
+		favorites[convID] = append(favorites[convID], favorite)
+	}
+
+	if *treeDirs == "" {
+		matches, _ := filepath.Glob(filepath.Join(*parsedDir, "*", "tree"))
+		*treeDirs = strings.Join(matches, ",")
+	}
+	trees, err := readTrees(strings.Split(*treeDirs, ","))
+	if err != nil {
+		fmt.Printf("读取分支树失败: %v\n", err)
+		os.Exit(1)
+	}
+	for _, tree := range trees {
+		for _, convID := range tree.Conversations {
+			if note, ok := noteMap[convID]; ok {
+				note.Trees = append(note.Trees, tree.Root)
+			}
+		}
+	}
+
+	created, updated, unchanged := 0, 0, 0
+	for _, note := range notes {
+		body := renderNote(note, favorites[note.ID], fragments[note.ID], trees, noteMap)
+		status, err := writeNote(filepath.Join(*vaultDir, notePath(note)+".md"), noteFrontmatter(note), body)
+		if err != nil {
+			failed++
+			fmt.Printf("[失败] %s: %v\n", note.ID, err)
+			continue
+		}
+		switch status {
+		case "created":
+			created++
+		case "updated":
+			updated++
+		default:
+			unchanged++
+		}
+	}
+
+	// 汇总所有收藏和片段的索引笔记
+	if len(meta.Favorites) > 0 || len(meta.Fragments) > 0 {
+		index := renderFavoritesIndex(notes, favorites, fragments)
+		if _, err := writeNote(filepath.Join(*vaultDir, "收藏与片段.md"), nil, index); err != nil {
+			failed++
+			fmt.Printf("[失败] 收藏与片段索引: %v\n", err)
+		}
+	}
+
+	fmt.Printf("\n导出完成: 新建 %d, 更新 %d, 未变化 %d, 失败 %d, 未匹配的收藏 %d\n", created, updated, unchanged, failed, unmatched)
+	fmt.Printf("输出目录: %s\n", *vaultDir)
+}
+
+func splitSet(value string) map[string]bool {
+	set := make(map[string]bool)
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			set[item] = true
+		}
+	}
+	return set
+}
+
+// collectConversationFiles 收集 parsed/<source>/conversation/*.json（按路径排序）
+func collectConversationFiles(parsedDir string, sources map[string]bool) ([]string, error) {
+	sourceDirs, err := ioutil.ReadDir(parsedDir)
+	if err != nil {
+		return nil, err
+	}
+
+	var files []string
+	for _, sourceDir := range sourceDirs {
+		if !sourceDir.IsDir() || (len(sources) > 0 && !sources[sourceDir.Name()]) {
+			continue
+		}
+		matches, err := filepath.Glob(filepath.Join(parsedDir, sourceDir.Name(), "conversation", "*.json"))
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, matches...)
+	}
+
+	sort.Strings(files)
+	return files, nil
+}
+
+func readConversation(filename string) (*ConversationFile, error) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	var conv ConversationFile
+	if err := json.Unmarshal(data, &conv); err != nil {
+		return nil, fmt.Errorf("解析JSON失败: %v", err)
+	}
+	return &conv, nil
+}
+
+// readTrees 读取分支树目录中的树文件，没有对话列表的 JSON 文件跳过
+func readTrees(dirs []string) ([]TreeFile, error) {
+	var trees []TreeFile
+	for _, dir := range dirs {
+		if dir = strings.TrimSpace(dir); dir == "" {
+			continue
+		}
+		matches, err := filepath.Glob(filepath.Join(dir, "*.json"))
+		if err != nil {
+			return nil, err
+		}
+		sort.Strings(matches)
+		for _, match := range matches {
+			data, err := ioutil.ReadFile(match)
+			if err != nil {
+				return nil, err
+			}
+			var tree TreeFile
+			if err := json.Unmarshal(data, &tree); err != nil || tree.Root == "" || len(tree.Conversations) == 0 {
+				continue
+			}
+			trees = append(trees, tree)
+		}
+	}
+	return trees, nil
+}
+
+// conversationTitle 依次使用数据库中的标题、解析结果中的标题和首条用户消息
+func conversationTitle(id string, conv *ConversationFile, title string) string {
+	if title == "" {
+		title, _ = conv.Metadata["title"].(string)
+	}
+	if title == "" {
+		for _, node := range conv.Data {
+			if (node.Role == "user" || node.Role == "human") && strings.TrimSpace(node.Content) != "" {
+				title = strings.SplitN(strings.TrimSpace(node.Content), "\n", 2)[0]
+				break
+			}
+		}
+	}
+	if title == "" {
+		return id
+	}
+	if runes := []rune(title); len(runes) > 50 {
+		title = string(runes[:50]) + "..."
+	}
+	return title
+}
+
+// notePath 返回笔记在仓库中的相对路径（不含扩展名），文件名使用对话ID，重新导出时标题变化不会产生新笔记
+func notePath(note *VaultNote) string {
+	return note.Source + "/" + note.ID
+}
+
+// wikiLink 生成指向笔记（可选块）的链接，标题中的 | 和 ] 会破坏链接语法
+func wikiLink(note *VaultNote, block string) string {
+	target := notePath(note)
+	if block != "" {
+		target += "#^" + block
+	}
+	alias := strings.NewReplacer("|", "/", "[", "(", "]", ")").Replace(note.Title)
+	return "[[" + target + "|" + alias + "]]"
+}
+
+// blockID 将消息或片段ID转换为合法的块标识（只允许字母、数字和 -）
+// 有字符被替换时追加原始ID的短哈希，避免 a_b 和 a.b 得到相同的块标识
+func blockID(prefix, id string) string {
+	var sb strings.Builder
+	sb.WriteString(prefix + "-")
+	replaced := false
+	for _, r := range id {
+		if (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') || r == '-' {
+			sb.WriteRune(r)
+		} else {
+			sb.WriteRune('-')
+			replaced = true
+		}
+	}
+	if replaced {
+		hash := sha1.Sum([]byte(id))
+		sb.WriteString("-" + hex.EncodeToString(hash[:])[:6])
+	}
+	return sb.String()
+}
+
+// parseTime 解析 create_time（Unix 秒或 RFC3339 字符串）
+func parseTime(value interface{}) (time.Time, bool) {
+	switch v := value.(type) {
+	case float64:
+		sec := int64(v)
+		return time.Unix(sec, int64((v-float64(sec))*1e9)), true
+	case string:
+		if t, err := time.Parse(time.RFC3339Nano, v); err == nil {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// timeRange 返回对话中最早和最晚的消息时间
+func timeRange(nodes []ConversationNode) (time.Time, time.Time) {
+	var first, last time.Time
+	for _, node := range nodes {
+		t, ok := parseTime(node.CreateTime)
+		if !ok {
+			continue
+		}
+		if first.IsZero() || t.Before(first) {
+			first = t
+		}
+		if t.After(last) {
+			last = t
+		}
+	}
+	return first, last
+}
+
+// noteFrontmatter 生成由导出工具维护的 frontmatter 字段（按 managedKeys 顺序）
+func noteFrontmatter(note *VaultNote) [][2]string {
+	fields := [][2]string{
+		{"id", yamlString(note.ID)},
+		{"title", yamlString(note.Title)},
+		{"source", yamlString(note.Source)},
+	}
+	first, last := timeRange(note.Conv.Data)
+	if !first.IsZero() {
+		fields = append(fields, [2]string{"created", first.Local().Format("2006-01-02T15:04:05")})
+		fields = append(fields, [2]string{"updated", last.Local().Format("2006-01-02T15:04:05")})
+	}
+	if len(note.Tags) > 0 {
+		fields = append(fields, [2]string{"tags", yamlList(obsidianTags(note.Tags))})
+	}
+	project := note.Conv.ProjectID
+	if project == "" {
+		project, _ = note.Conv.Metadata["cwd"].(string)
+	}
+	if project != "" {
+		fields = append(fields, [2]string{"project", yamlString(project)})
+	}
+	fields = append(fields, [2]string{"round_count", strconv.Itoa(note.Conv.RoundCount)})
+	if note.Favorite {
+		fields = append(fields, [2]string{"favorite", "true"})
+	}
+	if len(note.Trees) > 0 {
+		fields = append(fields, [2]string{"trees", yamlList(note.Trees)})
+	}
+	return fields
+}
+
+// obsidianTags 将标签中的空白替换为 -（Obsidian 标签不能包含空格）
+func obsidianTags(tags []string) []string {
+	result := make([]string, 0, len(tags))
+	for _, tag := range tags {
+		tag = strings.Join(strings.Fields(strings.TrimPrefix(tag, "#")), "-")
+		if tag != "" {
+			result = append(result, tag)
+		}
+	}
+	return result
+}
+
+func yamlString(value string) string {
+	return strconv.Quote(value)
+}
+
+func yamlList(values []string) string {
+	var sb strings.Builder
+	for _, value := range values {
+		sb.WriteString("\n  - " + yamlString(value))
+	}
+	return sb.String()
+}
+
+// formatTime 统一格式化 create_time（Unix 秒或 RFC3339 字符串）
+func formatTime(value interface{}) string {
+	if t, ok := parseTime(value); ok {
+		return t.Local().Format("2006-01-02 15:04:05")
+	}
+	return ""
+}
+
+func roleTitle(role string) string {
+	if title, ok := roleTitles[role]; ok {
+		return title
+	}
+	return role
+}
+
+// codeFence 返回不与内容冲突的代码块围栏
+func codeFence(content string) string {
+	fence := "```"
+	for strings.Contains(content, fence) {
+		fence += "`"
+	}
+	return fence
+}
+
+// quote 为每一行加上 callout 前缀
+func quote(content string) string {
+	lines := strings.Split(strings.TrimRight(content, "\n"), "\n")
+	for i, line := range lines {
+		if line == "" {
+			lines[i] = ">"
+		} else {
+			lines[i] = "> " + line
+		}
+	}
+	return strings.Join(lines, "\n") + "\n"
+}
+
+// messageText 返回消息正文，工具调用以代码块展示
+func messageText(node ConversationNode) string {
+	var parts []string
+	if node.Content != "" {
+		if node.ContentType == "code" {
+			fence := codeFence(node.Content)
+			parts = append(parts, fence+"\n"+node.Content+"\n"+fence)
+		} else {
+			parts = append(parts, node.Content)
+		}
+	}
+
+	toolCalls := []map[string]interface{}{}
+	if node.ToolData != nil {
+		toolCalls = append(toolCalls, node.ToolData)
+	}
+	for _, block := range node.Blocks {
+		if block.ToolData != nil {
+			toolCalls = append(toolCalls, block.ToolData)
+		}
+	}
+	for _, toolData := range toolCalls {
+		name, _ := toolData["name"].(string)
+		if name == "" {
+			name = "工具结果"
+		}
+		data, _ := json.MarshalIndent(toolData, "", "  ")
+		fence := codeFence(string(data))
+		parts = append(parts, "**工具调用: "+name+"**\n\n"+fence+"json\n"+string(data)+"\n"+fence)
+	}
+	return strings.Join(parts, "\n\n")
+}
+
+// renderNote 生成笔记的托管区域：收藏、片段、相关对话和完整的对话内容
+// 每条消息渲染为一个 callout 并带有块标识 ^msg-<id>，收藏和片段通过块引用指向对应消息
+func renderNote(note *VaultNote, favorites []Favorite, fragments []Fragment, trees []TreeFile, noteMap map[string]*VaultNote) string {
+	var sb strings.Builder
+	sb.WriteString("# " + note.Title + "\n\n")
+
+	nodeMap := make(map[string]ConversationNode, len(note.Conv.Data))
+	for _, node := range note.Conv.Data {
+		nodeMap[node.ID] = node
+	}
+
+	if len(favorites) > 0 {
+		sb.WriteString("## 收藏\n\n")
+		for _, favorite := range favorites {
+			var link string
+			if favorite.TargetType == "fragment" {
+				link = "[[#^" + blockID("frag", favorite.TargetID) + "|片段]]"
+			} else if node, ok := nodeMap[favorite.TargetID]; ok {
+				link = "[[#^" + blockID("msg", node.ID) + "|" + roleTitle(node.Role) + "]]"
+				if createTime := formatTime(node.CreateTime); createTime != "" {
+					link += " · " + createTime
+				}
+			} else {
+				continue
+			}
+			line := "- " + link
+			if favorite.Category != "" && favorite.Category != "default" {
+				line += " `" + favorite.Category + "`"
+			}
+			if favorite.Notes != "" {
+				line += " " + favorite.Notes
+			}
+			sb.WriteString(line + "\n")
+		}
+		sb.WriteString("\n")
+	}
+
+	if len(fragments) > 0 {
+		sb.WriteString("## 片段\n\n")
+		for _, fragment := range fragments {
+			title := fragment.FragmentType
+			if fragment.Language != "" {
+				title += " · " + fragment.Language
+			}
+			if _, ok := nodeMap[fragment.MessageUUID]; ok {
+				title += " · [[#^" + blockID("msg", fragment.MessageUUID) + "|来源消息]]"
+			}
+			content := fragment.Content
+			if fragment.FragmentType == "code" {
+				fence := codeFence(content)
+				content = fence + fragment.Language + "\n" + content + "\n" + fence
+			}
+			sb.WriteString("> [!quote] " + title + "\n" + quote(content) + "\n^" + blockID("frag", fragment.UUID) + "\n\n")
+		}
+	}
+
+	// 同一分支树中的其他对话
+	var related []string
+	seen := map[string]bool{note.ID: true}
+	for _, tree := range trees {
+		if !containsString(note.Trees, tree.Root) {
+			continue
+		}
+		for _, convID := range tree.Conversations {
+			if other, ok := noteMap[convID]; ok && !seen[convID] {
+				seen[convID] = true
+				related = append(related, "- "+wikiLink(other, ""))
+			}
+		}
+	}
+	if len(related) > 0 {
+		sb.WriteString("## 相关对话\n\n" + strings.Join(related, "\n") + "\n\n")
+	}
+
+	sb.WriteString("## 对话\n\n")
+	for _, node := range note.Conv.Data {
+		text := messageText(node)
+		if text == "" {
+			continue
+		}
+		callout, ok := roleCallouts[node.Role]
+		if !ok {
+			callout = "abstract"
+		}
+		title := roleTitle(node.Role)
+		if createTime := formatTime(node.CreateTime); createTime != "" {
+			title += " · " + createTime
+		}
+		// callout 整体是一个块，块标识单独成行放在其后，引用时包含整条消息
+		sb.WriteString("> [!" + callout + "] " + title + "\n" + quote(text) + "\n^" + blockID("msg", node.ID) + "\n\n")
+	}
+
+	return strings.TrimRight(sb.String(), "\n") + "\n"
+}
+
+// renderFavoritesIndex 生成汇总所有收藏和片段的索引笔记，内容通过块嵌入引用各对话笔记
+func renderFavoritesIndex(notes []*VaultNote, favorites map[string][]Favorite, fragments map[string][]Fragment) string {
+	var sb strings.Builder
+	sb.WriteString("# 收藏与片段\n\n")
+	for _, note := range notes {
+		if !note.Favorite && len(favorites[note.ID]) == 0 && len(fragments[note.ID]) == 0 {
+			continue
+		}
+		sb.WriteString("## " + wikiLink(note, "") + "\n\n")
+		var blocks []string
+		for _, favorite := range favorites[note.ID] {
+			if favorite.TargetType == "fragment" {
+				blocks = append(blocks, blockID("frag", favorite.TargetID))
+			} else {
+				blocks = append(blocks, blockID("msg", favorite.TargetID))
+			}
+		}
+		for _, fragment := range fragments[note.ID] {
+			blocks = append(blocks, blockID("frag", fragment.UUID))
+		}
+		// 收藏的片段同时出现在片段列表中，只嵌入一次
+		seen := make(map[string]bool)
+		for _, block := range blocks {
+			if !seen[block] {
+				seen[block] = true
+				sb.WriteString("![[" + notePath(note) + "#^" + block + "]]\n\n")
+			}
+		}
+	}
+	return strings.TrimRight(sb.String(), "\n") + "\n"
+}
+
+func containsString(values []string, target string) bool {
+	for _, value := range values {
+		if value == target {
+			return true
+		}
+	}
+	return false
+}
+
+// writeNote 写入笔记并返回 created/updated/unchanged
+// 已有笔记只替换 frontmatter 中的托管字段和标记之间的内容，用户添加的字段和标记外的内容保持不变
+func writeNote(path string, fields [][2]string, body string) (string, error) {
+	existing, readErr := ioutil.ReadFile(path)
+	if readErr != nil && !os.IsNotExist(readErr) {
+		return "", readErr
+	}
+
+	frontmatter, rest := splitFrontmatter(string(existing))
+	managed := make(map[string]bool, len(managedKeys))
+	for _, key := range managedKeys {
+		managed[key] = true
+	}
+
+	var sb strings.Builder
+	if len(fields) > 0 || len(frontmatter) > 0 {
+		sb.WriteString("---\n")
+		for _, field := range fields {
+			if strings.HasPrefix(field[1], "\n") {
+				sb.WriteString(field[0] + ":" + field[1] + "\n")
+			} else {
+				sb.WriteString(field[0] + ": " + field[1] + "\n")
+			}
+		}
+		for _, entry := range frontmatter {
+			if fields == nil || !managed[entry[0]] {
+				sb.WriteString(entry[1])
+			}
+		}
+		sb.WriteString("---\n")
+	}
+
+	region := beginMarker + "\n" + body + endMarker
+	start := strings.Index(rest, beginMarker)
+	end := strings.Index(rest, endMarker)
+	switch {
+	case readErr != nil:
+		sb.WriteString(region + "\n")
+	case start >= 0 && end > start:
+		sb.WriteString(rest[:start] + region + rest[end+len(endMarker):])
+	default:
+		// 标记被删除时在末尾重新生成，不覆盖用户内容
+		sb.WriteString(strings.TrimRight(rest, "\n"))
+		if strings.TrimSpace(rest) != "" {
+			sb.WriteString("\n\n")
+		}
+		sb.WriteString(region + "\n")
+	}
+
+	content := sb.String()
+	if readErr == nil && content == string(existing) {
+		return "unchanged", nil
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return "", err
+	}
+	if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+		return "", err
+	}
+	if readErr != nil {
+		return "created", nil
+	}
+	return "updated", nil
+}
+
+// splitFrontmatter 拆分 frontmatter 和正文，frontmatter 按顶层字段切分为 [字段名, 原始文本]
+func splitFrontmatter(content string) ([][2]string, string) {
+	if !strings.HasPrefix(content, "---\n") {
+		return nil, content
+	}
+	end := strings.Index(content[4:], "\n---\n")
+	if end < 0 {
+		return nil, content
+	}
+
+	var entries [][2]string
+	for _, line := range strings.SplitAfter(content[4:4+end+1], "\n") {
+		if line == "" {
+			continue
+		}
+		// 缩进行和列表项属于上一个字段
+		if len(entries) > 0 && (strings.HasPrefix(line, " ") || strings.HasPrefix(line, "\t") || strings.HasPrefix(line, "-")) {
+			entries[len(entries)-1][1] += line
+			continue
+		}
+		key := line
+		if idx := strings.Index(line, ":"); idx >= 0 {
+			key = line[:idx]
+		}
+		entries = append(entries, [2]string{strings.TrimSpace(key), line})
+	}
+	return entries, content[4+end+5:]
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// 运行方式（scripts 目录下各工具都是独立的 main 包，需要指定文件）:
+//
+//	go test -v obsidian_vault_export.go obsidian_vault_export_test.go
+
+func TestWriteNoteRoundTrip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "notes", "conv.md")
+	fields := [][2]string{{"id", "conv-1"}, {"title", "旧标题"}, {"tags", "\n  - 监控"}}
+
+	if status, err := writeNote(path, fields, "第一版\n"); err != nil || status != "created" {
+		t.Fatalf("首次写入应为 created，实际 %s, %v", status, err)
+	}
+
+	// 用户添加 frontmatter 字段和标记外的内容
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	edited := strings.Replace(string(data), "---\n", "---\nrating: 5\naliases:\n  - 别名\n", 1)
+	edited = strings.Replace(edited, beginMarker, "我的笔记\n\n"+beginMarker, 1) + "\n后记\n"
+	if err := os.WriteFile(path, []byte(edited), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	fields = [][2]string{{"id", "conv-1"}, {"title", "新标题"}, {"tags", "\n  - 监控\n  - 新标签"}}
+	if status, err := writeNote(path, fields, "第二版\n"); err != nil || status != "updated" {
+		t.Fatalf("再次导出应为 updated，实际 %s, %v", status, err)
+	}
+	data, err = os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	content := string(data)
+
+	for _, want := range []string{"title: 新标题", "  - 新标签", "rating: 5", "aliases:\n  - 别名", "我的笔记\n\n" + beginMarker, "第二版", endMarker + "\n\n后记\n"} {
+		if !strings.Contains(content, want) {
+			t.Errorf("更新后的笔记缺少 %q:\n%s", want, content)
+		}
+	}
+	for _, unwanted := range []string{"旧标题", "第一版"} {
+		if strings.Contains(content, unwanted) {
+			t.Errorf("更新后的笔记不应包含 %q:\n%s", unwanted, content)
+		}
+	}
+	if strings.Count(content, beginMarker) != 1 || strings.Count(content, "title:") != 1 {
+		t.Errorf("托管字段和内容不应重复:\n%s", content)
+	}
+
+	if status, err := writeNote(path, fields, "第二版\n"); err != nil || status != "unchanged" {
+		t.Fatalf("内容相同时应为 unchanged，实际 %s, %v", status, err)
+	}
+}
+
+func TestBlockID(t *testing.T) {
+	if id := blockID("msg", "abc-123"); id != "msg-abc-123" {
+		t.Errorf("合法字符组成的ID应保持不变，实际 %s", id)
+	}
+
+	ids := map[string]bool{}
+	for _, raw := range []string{"a_b", "a.b", "a-b"} {
+		id := blockID("msg", raw)
+		if ids[id] {
+			t.Errorf("%s 的块标识 %s 与其他ID冲突", raw, id)
+		}
+		ids[id] = true
+		if strings.Trim(id, "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789-") != "" {
+			t.Errorf("块标识 %s 包含非法字符", id)
+		}
+	}
+}
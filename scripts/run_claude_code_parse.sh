@@ -28,8 +28,9 @@ echo "编译成功!"
 
 # 运行程序
 if [ -z "$1" ]; then
-    echo "用法: $0 <input_jsonl_file> [output_dir]"
+    echo "用法: $0 <input_jsonl_file|sessions_dir> [output_dir]"
     echo "示例: $0 data/claude_code/projects/-Users-your_username/session.jsonl"
+    echo "示例: $0 ~/.claude/projects"
     exit 1
 fi
 
@@ -38,11 +39,16 @@ if [ ! -z "$2" ]; then
     OUTPUT_DIR="$2"
 fi
 
-echo "输入文件: $INPUT_FILE"
+echo "输入: $INPUT_FILE"
 echo "输出目录: $OUTPUT_DIR"
 echo ""
 
-"$BIN_DIR/claude_code_conversation_parse" -input "$INPUT_FILE" -output "$OUTPUT_DIR"
+# 输入为目录时使用批量模式
+if [ -d "$INPUT_FILE" ]; then
+    "$BIN_DIR/claude_code_conversation_parse" -dir "$INPUT_FILE" -output "$OUTPUT_DIR"
+else
+    "$BIN_DIR/claude_code_conversation_parse" -input "$INPUT_FILE" -output "$OUTPUT_DIR"
+fi
 
 # 保存退出码
 EXIT_CODE=$?
@@ -29,6 +29,9 @@ echo "编译成功!"
 if [ -z "$1" ]; then
     echo "用法: $0 <input_json_file> [output_dir]"
     echo "示例: $0 data/claude/conversations.json"
+    echo ""
+    echo "说明:"
+    echo "  - 如果输入文件同目录下存在 projects.json，会同时导出项目到 parsed/claude/project"
     exit 1
 fi
 
@@ -0,0 +1,59 @@
+#!/bin/bash
+
+# Conversation Export 编译和运行脚本
+
+SCRIPT_DIR="$(cd "$(dirname "${BASH_SOURCE[0]}")" && pwd)"
+BIN_DIR="$SCRIPT_DIR/../bin"
+
+# 创建bin目录
+mkdir -p "$BIN_DIR"
+
+# 删除旧的可执行文件
+if [ -f "$BIN_DIR/conversation_export" ]; then
+    echo "删除旧的可执行文件..."
+    rm -f "$BIN_DIR/conversation_export"
+fi
+
+# 编译Go程序
+echo "正在编译 conversation_export..."
+go build -o "$BIN_DIR/conversation_export" "$SCRIPT_DIR/conversation_export.go"
+
+if [ $? -ne 0 ]; then
+    echo "编译失败!"
+    exit 1
+fi
+
+echo "编译成功!"
+
+# 运行程序
+if [ -z "$1" ]; then
+    echo "用法: $0 <parsed_conversation_json> [markdown|html] [leaf_node_id]"
+    echo "示例: $0 parsed/gpt/conversation/d4d4ddf6-5452-4dbb-9c1c-8a59ebfdb8fa.json html"
+    exit 1
+fi
+
+INPUT_FILE="$1"
+FORMAT="${2:-markdown}"
+
+echo "输入: $INPUT_FILE"
+echo "格式: $FORMAT"
+echo ""
+
+if [ ! -z "$3" ]; then
+    "$BIN_DIR/conversation_export" -input "$INPUT_FILE" -format "$FORMAT" -leaf "$3"
+else
+    "$BIN_DIR/conversation_export" -input "$INPUT_FILE" -format "$FORMAT"
+fi
+
+# 保存退出码
+EXIT_CODE=$?
+
+# 删除可执行文件
+if [ -f "$BIN_DIR/conversation_export" ]; then
+    echo ""
+    echo "清理可执行文件..."
+    rm -f "$BIN_DIR/conversation_export"
+fi
+
+# 返回原始退出码
+exit $EXIT_CODE
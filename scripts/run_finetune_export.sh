@@ -0,0 +1,49 @@
+#!/bin/bash
+
+# Fine-tuning Dataset Export 编译和运行脚本
+
+SCRIPT_DIR="$(cd "$(dirname "${BASH_SOURCE[0]}")" && pwd)"
+BIN_DIR="$SCRIPT_DIR/../bin"
+
+# 创建bin目录
+mkdir -p "$BIN_DIR"
+
+# 删除旧的可执行文件
+if [ -f "$BIN_DIR/finetune_dataset_export" ]; then
+    echo "删除旧的可执行文件..."
+    rm -f "$BIN_DIR/finetune_dataset_export"
+fi
+
+# 编译Go程序
+echo "正在编译 finetune_dataset_export..."
+go build -o "$BIN_DIR/finetune_dataset_export" "$SCRIPT_DIR/finetune_dataset_export.go"
+
+if [ $? -ne 0 ]; then
+    echo "编译失败!"
+    exit 1
+fi
+
+echo "编译成功!"
+
+# 运行程序（参数原样传递）
+if [ -z "$1" ]; then
+    echo "用法: $0 -output <dataset.jsonl> [-format openai|anthropic] [-sources gpt,claude_code] [其他参数]"
+    echo "示例: $0 -parsed parsed -output dataset.jsonl -format anthropic -sources claude_code,codex -drop-tools -dedupe"
+    echo "示例: $0 -output favorites.jsonl -meta data/conversation_meta.json -favorites -since 2025-01-01"
+    exit 1
+fi
+
+"$BIN_DIR/finetune_dataset_export" "$@"
+
+# 保存退出码
+EXIT_CODE=$?
+
+# 删除可执行文件
+if [ -f "$BIN_DIR/finetune_dataset_export" ]; then
+    echo ""
+    echo "清理可执行文件..."
+    rm -f "$BIN_DIR/finetune_dataset_export"
+fi
+
+# 返回原始退出码
+exit $EXIT_CODE
@@ -0,0 +1,60 @@
+#!/bin/bash
+
+# 基于映射规则的通用对话导入工具 编译和运行脚本
+
+SCRIPT_DIR="$(cd "$(dirname "${BASH_SOURCE[0]}")" && pwd)"
+BIN_DIR="$SCRIPT_DIR/../bin"
+
+# 创建bin目录
+mkdir -p "$BIN_DIR"
+
+# 删除旧的可执行文件
+if [ -f "$BIN_DIR/mapping_conversation_parse" ]; then
+    echo "删除旧的可执行文件..."
+    rm -f "$BIN_DIR/mapping_conversation_parse"
+fi
+
+# 编译Go程序
+echo "正在编译 mapping_conversation_parse..."
+go build -o "$BIN_DIR/mapping_conversation_parse" "$SCRIPT_DIR/mapping_conversation_parse.go"
+
+if [ $? -ne 0 ]; then
+    echo "编译失败!"
+    exit 1
+fi
+
+echo "编译成功!"
+
+# 运行程序
+if [ -z "$1" ] || [ -z "$2" ]; then
+    echo "用法: $0 <mapping_file> <input_file> [output_dir]"
+    echo "示例: $0 scripts/mappings/open_webui.json data/open_webui/chat-export.json"
+    echo "默认输出目录: parsed/<source>/conversation"
+    exit 1
+fi
+
+MAPPING_FILE="$1"
+INPUT_FILE="$2"
+
+echo "映射规则: $MAPPING_FILE"
+echo "输入: $INPUT_FILE"
+echo ""
+
+if [ ! -z "$3" ]; then
+    "$BIN_DIR/mapping_conversation_parse" -mapping "$MAPPING_FILE" -input "$INPUT_FILE" -output "$3"
+else
+    "$BIN_DIR/mapping_conversation_parse" -mapping "$MAPPING_FILE" -input "$INPUT_FILE"
+fi
+
+# 保存退出码
+EXIT_CODE=$?
+
+# 删除可执行文件
+if [ -f "$BIN_DIR/mapping_conversation_parse" ]; then
+    echo ""
+    echo "清理可执行文件..."
+    rm -f "$BIN_DIR/mapping_conversation_parse"
+fi
+
+# 返回原始退出码
+exit $EXIT_CODE
@@ -5,6 +5,12 @@
 SCRIPT_DIR="$(cd "$(dirname "${BASH_SOURCE[0]}")" && pwd)"
 BIN_DIR="$SCRIPT_DIR/../bin"
 OUTPUT_DIR="$SCRIPT_DIR/../parsed/gpt/tree"
+# 合并策略: id（按消息ID，GPT）或 content（按内容哈希，Codex、Claude Code），可通过环境变量 STRATEGY 指定
+STRATEGY="${STRATEGY:-id}"
+# --stats 时设置环境变量 JSON=1 输出JSON
+JSON="${JSON:-0}"
+# 在树节点中嵌入消息内容: snippet（摘要）或 full（全文），可通过环境变量 EMBED 指定，缺省不嵌入
+EMBED="${EMBED:-}"
 
 # 创建bin目录
 mkdir -p "$BIN_DIR"
@@ -29,26 +35,132 @@ echo "编译成功!"
 # 运行程序
 if [ -z "$1" ]; then
     echo "用法: $0 <input_files> [output_dir]"
+    echo "      $0 --discover [conversation_dir] [output_dir]"
+    echo "      $0 --remove <tree_file> <conversation_ids> [output_dir]"
+    echo "      $0 --rebuild <tree_file> [conversation_dir] [output_dir]"
+    echo "      $0 --render <dot|mermaid|ascii> <tree_file> [conversation_dir] [output_dir]"
+    echo "      $0 --diff <conv_a,conv_b> <tree_file> [conversation_dir]"
+    echo "      $0 --stats <tree_file> [conversation_dir]"
     echo "示例: $0 conv_a.json,conv_b.json"
     echo "示例: $0 tree.json,conv_c.json gpt_tree"
+    echo "示例: $0 --discover parsed/gpt/conversation"
+    echo "示例: STRATEGY=content $0 --discover parsed/claude_code/conversation parsed/claude_code/tree"
+    echo "示例: $0 --remove parsed/gpt/tree/root.json conv_b,conv_c"
+    echo "示例: $0 --rebuild parsed/gpt/tree/root.json parsed/gpt/conversation"
+    echo "示例: $0 --render mermaid parsed/gpt/tree/root.json"
+    echo "示例: $0 --diff conv_a,conv_b parsed/gpt/tree/root.json"
+    echo "示例: JSON=1 $0 --stats parsed/gpt/tree/root.json"
+    echo "示例: EMBED=snippet $0 parsed/gpt/conversation/a.json,parsed/gpt/conversation/b.json"
     echo ""
     echo "说明:"
     echo "  - input_files: 用逗号分隔的多个JSON文件路径"
     echo "  - 如果第一个文件是树结构，会将后续对话合并到该树中"
     echo "  - 如果都是对话文件，会合并成新的树结构"
+    echo "  - --discover: 扫描对话目录，自动将共享消息的对话分组并生成全部树，同时输出 tree_index.json"
+    echo "  - --remove: 从树中移除对话，只属于这些对话的节点会被剪除"
+    echo "  - --rebuild: 按树中对话的当前文件重新生成树，文件已不存在的对话会被移除"
+    echo "  - --render: 导出 Graphviz DOT、Mermaid 流程图或 ASCII 树，节点显示角色和内容摘要，分支按对话着色"
+    echo "  - --diff: 找到两个对话的分叉节点，并排显示分叉后的消息差异和各分支继续的轮数"
+    echo "  - --stats: 输出最大深度、分叉数、分支因子分布、各分支长度及分叉后轮数，JSON=1 时输出JSON"
+    echo "  - STRATEGY=content: 按消息及其前缀的内容哈希对齐，用于合并分叉或恢复的 Codex、Claude Code 会话"
+    echo "  - EMBED=snippet|full: 在树节点中嵌入角色、内容类型、内容摘要或全文和时间，查看时无需读取对话文件"
     exit 1
 fi
 
-INPUT_FILES="$1"
-if [ ! -z "$2" ]; then
-    OUTPUT_DIR="$2"
-fi
+if [ "$1" == "--discover" ]; then
+    # 自动发现模式
+    CONVERSATION_DIR="$SCRIPT_DIR/../parsed/gpt/conversation"
+    if [ ! -z "$2" ]; then
+        CONVERSATION_DIR="$2"
+    fi
+    if [ ! -z "$3" ]; then
+        OUTPUT_DIR="$3"
+    fi
+
+    echo "对话目录: $CONVERSATION_DIR"
+    echo "输出目录: $OUTPUT_DIR"
+    echo "合并策略: $STRATEGY"
+    echo ""
+
+    "$BIN_DIR/gpt_branch_tree_merge" -discover -dir "$CONVERSATION_DIR" -output "$OUTPUT_DIR" -strategy "$STRATEGY" -embed "$EMBED"
+elif [ "$1" == "--remove" ]; then
+    # 移除对话
+    if [ ! -z "$4" ]; then
+        OUTPUT_DIR="$4"
+    fi
+
+    echo "树文件: $2"
+    echo "移除对话: $3"
+    echo "输出目录: $OUTPUT_DIR"
+    echo ""
+
+    "$BIN_DIR/gpt_branch_tree_merge" -action remove -input "$2" -ids "$3" -output "$OUTPUT_DIR"
+elif [ "$1" == "--rebuild" ]; then
+    # 重建树
+    CONVERSATION_DIR="$SCRIPT_DIR/../parsed/gpt/conversation"
+    if [ ! -z "$3" ]; then
+        CONVERSATION_DIR="$3"
+    fi
+    if [ ! -z "$4" ]; then
+        OUTPUT_DIR="$4"
+    fi
+
+    echo "树文件: $2"
+    echo "对话目录: $CONVERSATION_DIR"
+    echo "输出目录: $OUTPUT_DIR"
+    echo ""
 
-echo "输入文件: $INPUT_FILES"
-echo "输出目录: $OUTPUT_DIR"
-echo ""
+    "$BIN_DIR/gpt_branch_tree_merge" -action rebuild -input "$2" -dir "$CONVERSATION_DIR" -output "$OUTPUT_DIR" -strategy "$STRATEGY" -embed "$EMBED"
+elif [ "$1" == "--render" ]; then
+    # 可视化导出
+    CONVERSATION_DIR="$SCRIPT_DIR/../parsed/gpt/conversation"
+    if [ ! -z "$4" ]; then
+        CONVERSATION_DIR="$4"
+    fi
+    if [ ! -z "$5" ]; then
+        OUTPUT_DIR="$5"
+    fi
 
-"$BIN_DIR/gpt_branch_tree_merge" -input "$INPUT_FILES" -output "$OUTPUT_DIR"
+    echo "树文件: $3"
+    echo "对话目录: $CONVERSATION_DIR"
+    echo "输出目录: $OUTPUT_DIR"
+    echo ""
+
+    "$BIN_DIR/gpt_branch_tree_merge" -render "$2" -input "$3" -dir "$CONVERSATION_DIR" -output "$OUTPUT_DIR"
+elif [ "$1" == "--diff" ]; then
+    # 分支差异
+    CONVERSATION_DIR="$SCRIPT_DIR/../parsed/gpt/conversation"
+    if [ ! -z "$4" ]; then
+        CONVERSATION_DIR="$4"
+    fi
+
+    "$BIN_DIR/gpt_branch_tree_merge" -diff "$2" -input "$3" -dir "$CONVERSATION_DIR"
+elif [ "$1" == "--stats" ]; then
+    # 统计信息
+    CONVERSATION_DIR="$SCRIPT_DIR/../parsed/gpt/conversation"
+    if [ ! -z "$3" ]; then
+        CONVERSATION_DIR="$3"
+    fi
+
+    JSON_FLAG=""
+    if [ "$JSON" == "1" ]; then
+        JSON_FLAG="-json"
+    fi
+
+    "$BIN_DIR/gpt_branch_tree_merge" -stats $JSON_FLAG -input "$2" -dir "$CONVERSATION_DIR"
+else
+    INPUT_FILES="$1"
+    if [ ! -z "$2" ]; then
+        OUTPUT_DIR="$2"
+    fi
+
+    echo "输入文件: $INPUT_FILES"
+    echo "输出目录: $OUTPUT_DIR"
+    echo "合并策略: $STRATEGY"
+    echo ""
+
+    "$BIN_DIR/gpt_branch_tree_merge" -input "$INPUT_FILES" -output "$OUTPUT_DIR" -strategy "$STRATEGY" -embed "$EMBED"
+fi
 
 # 保存退出码
 EXIT_CODE=$?
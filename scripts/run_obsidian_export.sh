@@ -0,0 +1,49 @@
+#!/bin/bash
+
+# Obsidian Vault Export 编译和运行脚本
+
+SCRIPT_DIR="$(cd "$(dirname "${BASH_SOURCE[0]}")" && pwd)"
+BIN_DIR="$SCRIPT_DIR/../bin"
+
+# 创建bin目录
+mkdir -p "$BIN_DIR"
+
+# 删除旧的可执行文件
+if [ -f "$BIN_DIR/obsidian_vault_export" ]; then
+    echo "删除旧的可执行文件..."
+    rm -f "$BIN_DIR/obsidian_vault_export"
+fi
+
+# 编译Go程序
+echo "正在编译 obsidian_vault_export..."
+go build -o "$BIN_DIR/obsidian_vault_export" "$SCRIPT_DIR/obsidian_vault_export.go"
+
+if [ $? -ne 0 ]; then
+    echo "编译失败!"
+    exit 1
+fi
+
+echo "编译成功!"
+
+# 运行程序（参数原样传递）
+if [ -z "$1" ]; then
+    echo "用法: $0 -vault <dir> [-parsed parsed] [-meta <meta.json>] [-trees <dir1,dir2>] [-sources gpt,claude_code]"
+    echo "示例: $0 -vault ~/Notes/AI对话 -parsed parsed -meta data/conversation_meta.json"
+    echo "说明: 笔记按对话ID命名，重复运行只更新标记之间的内容和托管的 frontmatter 字段"
+    exit 1
+fi
+
+"$BIN_DIR/obsidian_vault_export" "$@"
+
+# 保存退出码
+EXIT_CODE=$?
+
+# 删除可执行文件
+if [ -f "$BIN_DIR/obsidian_vault_export" ]; then
+    echo ""
+    echo "清理可执行文件..."
+    rm -f "$BIN_DIR/obsidian_vault_export"
+fi
+
+# 返回原始退出码
+exit $EXIT_CODE